@@ -0,0 +1,144 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"slices"
+
+	"github.com/gregwight/mistclient"
+	"github.com/gregwight/mistexporter/internal/apikey"
+	"github.com/gregwight/mistexporter/internal/config"
+	"github.com/gregwight/mistexporter/internal/filter"
+	"github.com/gregwight/mistexporter/internal/metrics"
+	"github.com/gregwight/mistexporter/internal/server"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// checkConfig validates the configuration file, writing every problem found to w, and
+// returns the process exit code. If checkAPI is set and the configuration is valid, the
+// Mist API is also queried to verify connectivity and the privileges of the API key.
+func checkConfig(w io.Writer, configFile string, overrides config.Overrides, checkAPI bool) int {
+	if configFile == "" {
+		fmt.Fprintln(w, "Checking configuration from environment variables and flags")
+	} else {
+		fmt.Fprintf(w, "Checking %s\n", configFile)
+	}
+
+	cfg, err := config.LoadConfig(configFile, overrides)
+	if err != nil {
+		fmt.Fprintf(w, "  FAILED: %v\n", err)
+		return 1
+	}
+
+	errs := validateConfig(cfg)
+	if len(errs) == 0 && checkAPI {
+		errs = checkMistAPI(cfg)
+	}
+	if len(errs) > 0 {
+		fmt.Fprintf(w, "  FAILED: %d problem(s) found\n", len(errs))
+		for _, err := range errs {
+			fmt.Fprintf(w, "  - %v\n", err)
+		}
+		return 1
+	}
+
+	fmt.Fprintln(w, "  SUCCESS: configuration is valid")
+	return 0
+}
+
+// validateConfig validates the configuration, and then constructs each of the exporter
+// components from it without starting them, catching errors that are only detected by
+// the components themselves.
+func validateConfig(cfg *config.Config) []error {
+	if err := cfg.Validate(); err != nil {
+		return unwrapErrors(err)
+	}
+
+	var errs []error
+	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+	if cfg.MistClient.APIKeyFile != "" {
+		keyFile, err := apikey.NewFile(cfg.MistClient.APIKeyFile, cfg.MistClient.APIKeyFileRefreshInterval, logger)
+		if err != nil {
+			return append(errs, fmt.Errorf("unable to load API key file: %w", err))
+		}
+		cfg.MistClient.APIKey = keyFile.Key()
+	}
+	client, err := mistclient.New(&cfg.MistClient.Config, logger)
+	if err != nil {
+		return append(errs, fmt.Errorf("unable to initialize Mist API client: %w", err))
+	}
+	siteFilter, err := filter.New(cfg.Collector.SiteFilter)
+	if err != nil {
+		errs = append(errs, fmt.Errorf("invalid site filter: %w", err))
+	}
+	if err := metrics.ConfigureNamespace(cfg.Exporter.Namespace, cfg.Exporter.StaticLabels); err != nil {
+		errs = append(errs, fmt.Errorf("invalid exporter namespace or static labels: %w", err))
+	}
+	resolvers := metrics.SiteLabelResolvers{
+		SiteGroupName: func(string) string { return "" },
+		SiteVariable:  func(string, string) string { return "" },
+	}
+	if err := metrics.ConfigureSiteLabels(cfg.Collector.SiteLabels, resolvers); err != nil {
+		errs = append(errs, fmt.Errorf("invalid site labels: %w", err))
+	}
+	if len(errs) > 0 {
+		return errs
+	}
+
+	reg := prometheus.NewPedanticRegistry()
+	if _, err := metrics.New(client, cfg.OrgId, siteFilter, cfg.Collector, reg, logger); err != nil {
+		errs = append(errs, fmt.Errorf("invalid collector configuration: %w", err))
+	}
+	if _, err := server.New(cfg, reg, server.Options{}); err != nil {
+		errs = append(errs, fmt.Errorf("invalid server configuration: %w", err))
+	}
+	if _, err := server.NewAdmin(cfg, server.Options{}); err != nil {
+		errs = append(errs, fmt.Errorf("invalid admin server configuration: %w", err))
+	}
+
+	return errs
+}
+
+// checkMistAPI verifies that the Mist API can be reached with the configured API key, and
+// that the key has access to the configured (or a single discoverable) organization.
+func checkMistAPI(cfg *config.Config) []error {
+	client, err := mistclient.New(&cfg.MistClient.Config, slog.New(slog.NewTextHandler(io.Discard, nil)))
+	if err != nil {
+		return []error{fmt.Errorf("unable to initialize Mist API client: %w", err)}
+	}
+
+	self, err := client.GetSelf()
+	if err != nil {
+		return []error{fmt.Errorf("unable to authenticate with the Mist API at %s: %w", cfg.MistClient.BaseURL, err)}
+	}
+
+	orgID := cfg.OrgId
+	if orgID == "" {
+		if orgID, err = autoOrgID(cfg, client); err != nil {
+			return []error{err}
+		}
+		if orgID == "" {
+			return []error{errors.New("api key does not have access to any Mist organization")}
+		}
+	} else if !slices.ContainsFunc(self.Privileges, func(p mistclient.Privilege) bool {
+		return p.Scope == "org" && p.OrgID == orgID
+	}) {
+		return []error{fmt.Errorf("api key does not have access to Mist organization %s", orgID)}
+	}
+
+	if _, err := client.GetOrgSites(orgID); err != nil {
+		return []error{fmt.Errorf("unable to list sites of Mist organization %s: %w", orgID, err)}
+	}
+
+	return nil
+}
+
+// unwrapErrors returns the errors joined by errors.Join, or the error itself.
+func unwrapErrors(err error) []error {
+	if joined, ok := err.(interface{ Unwrap() []error }); ok {
+		return joined.Unwrap()
+	}
+	return []error{err}
+}
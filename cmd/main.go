@@ -2,8 +2,10 @@ package main
 
 import (
 	"context"
+	"errors"
 	"flag"
 	"fmt"
+	"io/fs"
 	"log/slog"
 	"net/http"
 	"os"
@@ -12,22 +14,37 @@ import (
 	"time"
 
 	"github.com/gregwight/mistclient"
+	"github.com/gregwight/mistexporter/internal/apikey"
 	"github.com/gregwight/mistexporter/internal/collector"
 	"github.com/gregwight/mistexporter/internal/config"
+	"github.com/gregwight/mistexporter/internal/errorlog"
 	"github.com/gregwight/mistexporter/internal/filter"
 	"github.com/gregwight/mistexporter/internal/metrics"
 	"github.com/gregwight/mistexporter/internal/server"
+	"github.com/gregwight/mistexporter/internal/sitegroup"
+	"github.com/gregwight/mistexporter/internal/sitevars"
 	"github.com/gregwight/mistexporter/internal/version"
 	"github.com/prometheus/client_golang/prometheus"
 	"github.com/prometheus/client_golang/prometheus/collectors"
 	"golang.org/x/sync/errgroup"
 )
 
+// defaultConfigFile is the configuration file loaded when the -config flag is not set.
+const defaultConfigFile = "config.yaml"
+
 func main() {
-	configFile := flag.String("config", "config.yaml", "Path to the configuration file")
+	configFile := flag.String("config", defaultConfigFile, "Path to the configuration file, or empty to configure the exporter from environment variables and flags alone")
 	debug := flag.Bool("debug", false, "Enable debug mode")
+	checkOnly := flag.Bool("check-config", false, "Validate the configuration file and exit")
+	checkAPI := flag.Bool("check-api", false, "With -check-config, also verify Mist API connectivity and API key privileges")
+	overrides := config.RegisterFlags(flag.CommandLine)
 	version.AddVersionFlag()
 	flag.Parse()
+	*configFile = resolveConfigFile(*configFile)
+
+	if *checkOnly {
+		os.Exit(checkConfig(os.Stdout, *configFile, overrides, *checkAPI))
+	}
 
 	// Create context with signal handling
 	ctx, cancel := signal.NotifyContext(context.Background(),
@@ -45,17 +62,36 @@ func main() {
 	if *debug {
 		loggerOpts.Level = slog.LevelDebug
 	}
-	logger := slog.New(slog.NewTextHandler(os.Stdout, loggerOpts))
+	// The most recent errors are recorded for the status page.
+	recentErrors := errorlog.NewHandler(slog.NewTextHandler(os.Stdout, loggerOpts), 20)
+	logger := slog.New(recentErrors)
 
 	// Load configuration
-	cfg, err := config.LoadConfig(*configFile)
+	cfg, err := config.LoadConfig(*configFile, overrides)
 	if err != nil {
 		logger.Error("unable to load configuration", "error", err)
 		os.Exit(1)
 	}
+	if err := cfg.Validate(); err != nil {
+		logger.Error("invalid configuration", "error", err)
+		os.Exit(1)
+	}
+
+	// Load the API key from the API key file, if configured, which is
+	// watched for changes once the exporter has started
+	clientCfg := cfg.MistClient.Config
+	var keyFile *apikey.File
+	if cfg.MistClient.APIKeyFile != "" {
+		keyFile, err = apikey.NewFile(cfg.MistClient.APIKeyFile, cfg.MistClient.APIKeyFileRefreshInterval, logger)
+		if err != nil {
+			logger.Error("unable to load API key file", "error", err)
+			os.Exit(1)
+		}
+		clientCfg.APIKey = keyFile.Key()
+	}
 
 	// Initialize Mist API client
-	client, err := mistclient.New(cfg.MistClient, logger)
+	client, err := mistclient.New(&clientCfg, logger)
 	if err != nil {
 		logger.Error("unable to initialize Mist API client", "error", err)
 		os.Exit(1)
@@ -75,6 +111,55 @@ func main() {
 		os.Exit(1)
 	}
 
+	// Initialize site attribute caches, only fetching site groups and
+	// variables from the API when required by the site filter or labels
+	siteLabelSources := make(map[string]bool)
+	for _, label := range cfg.Collector.SiteLabels {
+		siteLabelSources[label.Source] = true
+	}
+
+	var siteGroups *sitegroup.Cache
+	if siteFilter.RequiresSiteGroups() || siteLabelSources["site_group"] {
+		siteGroups, err = sitegroup.NewCache(client, cfg.MistClient.BaseURL, orgID, cfg.Collector.SiteRefreshInterval, logger)
+		if err != nil {
+			logger.Error("unable to initialize site group cache", "error", err)
+			os.Exit(1)
+		}
+		if err := siteGroups.Update(); err != nil {
+			logger.Error("unable to fetch site groups", "error", err)
+			os.Exit(1)
+		}
+		siteFilter.SetSiteGroupResolver(siteGroups.Name)
+	}
+
+	var siteVars *sitevars.Cache
+	if siteLabelSources["variable"] {
+		siteVars, err = sitevars.NewCache(client, cfg.MistClient.BaseURL, cfg.Collector.SiteVariableRefreshInterval, logger)
+		if err != nil {
+			logger.Error("unable to initialize site variable cache", "error", err)
+			os.Exit(1)
+		}
+	}
+
+	// Configure the namespace and static labels of all Mist metrics
+	if err := metrics.ConfigureNamespace(cfg.Exporter.Namespace, cfg.Exporter.StaticLabels); err != nil {
+		logger.Error("invalid exporter namespace or static labels", "error", err)
+		os.Exit(1)
+	}
+
+	// Configure the labels attached to all site metrics
+	resolvers := metrics.SiteLabelResolvers{}
+	if siteGroups != nil {
+		resolvers.SiteGroupName = siteGroups.Name
+	}
+	if siteVars != nil {
+		resolvers.SiteVariable = siteVars.Value
+	}
+	if err := metrics.ConfigureSiteLabels(cfg.Collector.SiteLabels, resolvers); err != nil {
+		logger.Error("invalid site labels", "error", err)
+		os.Exit(1)
+	}
+
 	// Create a pedantic reg
 	reg := prometheus.NewPedanticRegistry()
 
@@ -93,43 +178,117 @@ func main() {
 	// Use errgroup for managing goroutines
 	eg, ctx := errgroup.WithContext(ctx)
 
+	// Start the site attribute caches
+	if siteGroups != nil {
+		eg.Go(func() error {
+			return siteGroups.Run(ctx)
+		})
+	}
+	if siteVars != nil {
+		eg.Go(func() error {
+			return siteVars.Run(ctx)
+		})
+	}
+
 	// Create and start metrics streamer
-	m, err := metrics.New(client, orgID, siteFilter, cfg.Collector.SiteRefreshInterval, cfg.Collector.DeviceNameRefreshInterval, reg, logger)
+	m, err := metrics.New(client, orgID, siteFilter, cfg.Collector, reg, logger)
 	if err != nil {
 		logger.Error("unable to initialize metrics streamer", "error", err)
 		os.Exit(1)
 	}
+	// Site variables are only fetched for the sites whose metrics are streamed
+	if siteVars != nil {
+		m.OnStreamedSites(siteVars.SetSites)
+	}
 	eg.Go(func() error {
 		logger.Info("starting metrics streamer...", "org_id", orgID)
 		return m.Run(ctx)
 	})
 
-	select {
-	case <-ctx.Done():
-		if err := eg.Wait(); err != nil {
-			logger.Error("metrics streamer failed to start", "error", err)
-			os.Exit(1)
-		}
-		logger.Info("server startup terminated")
-		return
-	case <-m.Ready():
-		logger.Info("metrics streamer started successfully")
+	// Switch all components to a new API client when the API key file changes
+	if keyFile != nil {
+		reg.MustRegister(prometheus.NewGaugeFunc(prometheus.GaugeOpts{
+			Namespace:   metrics.Namespace(),
+			Subsystem:   "exporter",
+			Name:        "api_key_file_last_load_timestamp_seconds",
+			Help:        "The time the Mist API key was last loaded from the API key file, as a Unix timestamp.",
+			ConstLabels: metrics.ConstLabels(),
+		}, func() float64 {
+			return float64(keyFile.LastLoad().Unix())
+		}))
+
+		eg.Go(func() error {
+			return keyFile.Run(ctx, func(key string) {
+				clientCfg.APIKey = key
+				client, err := mistclient.New(&clientCfg, logger)
+				if err != nil {
+					logger.Error("unable to initialize Mist API client with new API key", "error", err)
+					return
+				}
+				c.SetClient(client)
+				m.SetClient(client)
+				if siteGroups != nil {
+					siteGroups.SetClient(client)
+				}
+				if siteVars != nil {
+					siteVars.SetClient(client)
+				}
+			})
+		})
 	}
 
-	// Create and start HTTP server
-	svr, err := server.New(cfg, reg)
+	// Reload the configuration on SIGHUP or a request to /-/reload. The handler is
+	// registered before the metrics streamer has started, so that an early SIGHUP
+	// does not terminate the process.
+	r := newReloader(cfg, *configFile, overrides, siteGroups, m, c, reg, logger)
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+	eg.Go(func() error {
+		defer signal.Stop(hup)
+		for {
+			select {
+			case <-ctx.Done():
+				return nil
+			case <-hup:
+				r.Reload()
+			}
+		}
+	})
+
+	// Create and start HTTP server. The servers are started before the metrics
+	// streamer is ready, with /-/ready reporting 503 until the site streams start.
+	opts := server.Options{
+		Status:         m.Status,
+		RecentErrors:   recentErrors.Entries,
+		StreamMessages: m.StreamMessages,
+		Reload:         r.Reload,
+		Config:         r.Config,
+	}
+	svr, err := server.New(cfg, reg, opts)
 	if err != nil {
 		logger.Error("unable to create HTTP server", "error", err)
 		os.Exit(1)
 	}
 
-	eg.Go(func() error {
-		logger.Info("starting HTTP server...", "address", svr.Addr)
-		if err := svr.ListenAndServe(); err != nil && err != http.ErrServerClosed {
-			return fmt.Errorf("HTTP server error: %w", err)
-		}
-		return nil
-	})
+	adminSvr, err := server.NewAdmin(cfg, opts)
+	if err != nil {
+		logger.Error("unable to create admin HTTP server", "error", err)
+		os.Exit(1)
+	}
+	servers := []*http.Server{svr}
+	if adminSvr != nil {
+		servers = append(servers, adminSvr)
+	}
+
+	for _, s := range servers {
+		eg.Go(func() error {
+			logger.Info("starting HTTP server...", "address", s.Addr, "tls", s.TLSConfig != nil, "admin", s == adminSvr)
+			if err := server.ListenAndServe(s); err != nil && err != http.ErrServerClosed {
+				return fmt.Errorf("HTTP server error: %w", err)
+			}
+			return nil
+		})
+	}
 
 	// Handle graceful shutdown
 	eg.Go(func() error {
@@ -139,9 +298,25 @@ func main() {
 		shutdownCtx, shutdownCancel := context.WithTimeout(context.Background(), 30*time.Second)
 		defer shutdownCancel()
 
-		return svr.Shutdown(shutdownCtx)
+		var errs []error
+		for _, s := range servers {
+			errs = append(errs, s.Shutdown(shutdownCtx))
+		}
+		return errors.Join(errs...)
 	})
 
+	select {
+	case <-ctx.Done():
+		if err := eg.Wait(); err != nil {
+			logger.Error("exporter failed to start", "error", err)
+			os.Exit(1)
+		}
+		logger.Info("server startup terminated")
+		return
+	case <-m.Ready():
+		logger.Info("metrics streamer started successfully")
+	}
+
 	// Wait for all goroutines to complete
 	if err := eg.Wait(); err != nil {
 		logger.Error("server shutdown failure", "error", err)
@@ -151,6 +326,24 @@ func main() {
 	logger.Info("server shutdown success")
 }
 
+// resolveConfigFile returns the configuration file to load. The default file is optional
+// when the -config flag is not set, so that the exporter can be configured by environment
+// variables and flags alone.
+func resolveConfigFile(configFile string) string {
+	explicit := false
+	flag.Visit(func(f *flag.Flag) {
+		if f.Name == "config" {
+			explicit = true
+		}
+	})
+	if !explicit {
+		if _, err := os.Stat(configFile); errors.Is(err, fs.ErrNotExist) {
+			return ""
+		}
+	}
+	return configFile
+}
+
 func autoOrgID(cfg *config.Config, client *mistclient.APIClient) (string, error) {
 	if cfg.OrgId != "" {
 		return cfg.OrgId, nil
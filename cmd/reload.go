@@ -0,0 +1,114 @@
+package main
+
+import (
+	"fmt"
+	"log/slog"
+	"sync"
+
+	"github.com/gregwight/mistexporter/internal/collector"
+	"github.com/gregwight/mistexporter/internal/config"
+	"github.com/gregwight/mistexporter/internal/filter"
+	"github.com/gregwight/mistexporter/internal/metrics"
+	"github.com/gregwight/mistexporter/internal/sitegroup"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// reloader re-reads the configuration file, applying the site filter and refresh
+// intervals in place. All other settings require a restart to take effect, and a
+// warning is logged for each of them which is changed.
+type reloader struct {
+	configFile string
+	overrides  config.Overrides
+	siteGroups *sitegroup.Cache
+	metrics    *metrics.MistMetrics
+	collector  *collector.MistCollector
+	successful prometheus.Gauge
+	logger     *slog.Logger
+
+	// mu serializes reloads and guards config, the configuration currently in effect.
+	mu     sync.Mutex
+	config *config.Config
+}
+
+func newReloader(cfg *config.Config, configFile string, overrides config.Overrides, siteGroups *sitegroup.Cache, m *metrics.MistMetrics, c *collector.MistCollector, reg *prometheus.Registry, logger *slog.Logger) *reloader {
+	r := &reloader{
+		config:     cfg,
+		configFile: configFile,
+		overrides:  overrides,
+		siteGroups: siteGroups,
+		metrics:    m,
+		collector:  c,
+		successful: prometheus.NewGauge(prometheus.GaugeOpts{
+			Namespace:   metrics.Namespace(),
+			Subsystem:   "exporter",
+			Name:        "config_last_reload_successful",
+			Help:        "Whether the last configuration reload attempt was successful.",
+			ConstLabels: metrics.ConstLabels(),
+		}),
+		logger: logger.With(slog.String("component", "reloader")),
+	}
+	r.successful.Set(1)
+	reg.MustRegister(r.successful)
+
+	return r
+}
+
+// Reload reloads the configuration file. If the new configuration is invalid it is
+// rejected and the previous configuration remains in effect.
+func (r *reloader) Reload() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.logger.Info("reloading configuration...", "config", r.configFile)
+	if err := r.reload(); err != nil {
+		r.successful.Set(0)
+		r.logger.Error("unable to reload configuration, keeping previous configuration", "error", err)
+		return err
+	}
+	r.successful.Set(1)
+	r.logger.Info("configuration reloaded successfully")
+
+	return nil
+}
+
+// Config returns the configuration currently in effect, reflecting the last successful reload.
+func (r *reloader) Config() *config.Config {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	return r.config
+}
+
+func (r *reloader) reload() error {
+	cfg, err := config.LoadConfig(r.configFile, r.overrides)
+	if err != nil {
+		return fmt.Errorf("unable to load configuration: %w", err)
+	}
+	if err := cfg.Validate(); err != nil {
+		return fmt.Errorf("invalid configuration: %w", err)
+	}
+
+	siteFilter, err := filter.New(cfg.Collector.SiteFilter)
+	if err != nil {
+		return fmt.Errorf("unable to initialize site filter: %w", err)
+	}
+	if siteFilter.RequiresSiteGroups() {
+		if r.siteGroups == nil {
+			return fmt.Errorf("site filter rules matching on site groups require a restart when site groups were not previously used")
+		}
+		siteFilter.SetSiteGroupResolver(r.siteGroups.Name)
+	}
+
+	if err := r.metrics.ApplyConfig(siteFilter, cfg.Collector); err != nil {
+		return fmt.Errorf("unable to apply collector configuration: %w", err)
+	}
+	r.collector.SetFilter(siteFilter)
+
+	applied, restart := r.config.Reload(cfg)
+	for _, key := range restart {
+		r.logger.Warn("configuration change requires a restart to take effect", "key", key)
+	}
+	r.config = applied
+
+	return nil
+}
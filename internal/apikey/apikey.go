@@ -0,0 +1,102 @@
+package apikey
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// File holds a Mist API key loaded from a file, periodically re-read so that the key
+// can be rotated without restarting the exporter.
+type File struct {
+	path            string
+	refreshInterval time.Duration
+	logger          *slog.Logger
+
+	mu       sync.RWMutex
+	key      string
+	lastLoad time.Time
+}
+
+// NewFile creates a new API key file, loading the key from the file at the given path.
+func NewFile(path string, refreshInterval time.Duration, logger *slog.Logger) (*File, error) {
+	f := &File{
+		path:            path,
+		refreshInterval: refreshInterval,
+		logger:          logger.With(slog.String("component", "apikey"), slog.String("path", path)),
+	}
+	if _, err := f.Update(); err != nil {
+		return nil, err
+	}
+
+	return f, nil
+}
+
+// Run periodically re-reads the API key file until the context is done, calling onChange
+// with the new key whenever it changes. If the file cannot be read, or is empty, the
+// previous key is kept.
+func (f *File) Run(ctx context.Context, onChange func(key string)) error {
+	ticker := time.NewTicker(f.refreshInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-ticker.C:
+			changed, err := f.Update()
+			if err != nil {
+				f.logger.Error("unable to reload API key file, keeping previous API key", "error", err)
+				continue
+			}
+			if changed {
+				f.logger.Info("API key file changed, switching to new API key")
+				onChange(f.Key())
+			}
+		}
+	}
+}
+
+// Update reads the API key file, reporting whether the key has changed.
+func (f *File) Update() (bool, error) {
+	data, err := os.ReadFile(f.path)
+	if err != nil {
+		return false, fmt.Errorf("unable to read API key file: %w", err)
+	}
+	key := strings.TrimSpace(string(data))
+	if key == "" {
+		return false, fmt.Errorf("API key file %s is empty", f.path)
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	f.lastLoad = time.Now()
+	if key == f.key {
+		return false, nil
+	}
+	f.key = key
+
+	return true, nil
+}
+
+// Key returns the current API key.
+func (f *File) Key() string {
+	f.mu.RLock()
+	defer f.mu.RUnlock()
+
+	return f.key
+}
+
+// LastLoad returns the time the API key was last successfully read from the file, whether
+// or not it had changed.
+func (f *File) LastLoad() time.Time {
+	f.mu.RLock()
+	defer f.mu.RUnlock()
+
+	return f.lastLoad
+}
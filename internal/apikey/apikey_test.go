@@ -0,0 +1,109 @@
+package apikey
+
+import (
+	"context"
+	"io"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestFile(t *testing.T) {
+	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+	path := filepath.Join(t.TempDir(), "api-key")
+
+	if _, err := NewFile(path, time.Minute, logger); err == nil {
+		t.Error("NewFile() with a missing file, expected error")
+	}
+
+	if err := os.WriteFile(path, []byte("first-key\n"), 0600); err != nil {
+		t.Fatalf("failed to write API key file: %v", err)
+	}
+	f, err := NewFile(path, time.Minute, logger)
+	if err != nil {
+		t.Fatalf("NewFile() returned an unexpected error: %v", err)
+	}
+	if got := f.Key(); got != "first-key" {
+		t.Errorf("Key() = %q, want %q", got, "first-key")
+	}
+	firstLoad := f.LastLoad()
+	if firstLoad.IsZero() {
+		t.Error("LastLoad() is zero after the initial load")
+	}
+
+	time.Sleep(time.Millisecond)
+	if changed, err := f.Update(); err != nil || changed {
+		t.Errorf("Update() of an unchanged file = %v, %v, want false, nil", changed, err)
+	}
+	secondLoad := f.LastLoad()
+	if !secondLoad.After(firstLoad) {
+		t.Errorf("LastLoad() after an unchanged update = %v, want after %v", secondLoad, firstLoad)
+	}
+	time.Sleep(time.Millisecond)
+	if changed, err := f.Update(); err != nil || changed {
+		t.Errorf("Update() of an unchanged file = %v, %v, want false, nil", changed, err)
+	}
+	if !f.LastLoad().After(secondLoad) {
+		t.Errorf("LastLoad() after a repeated unchanged update = %v, want after %v", f.LastLoad(), secondLoad)
+	}
+
+	if err := os.WriteFile(path, []byte(""), 0600); err != nil {
+		t.Fatalf("failed to write API key file: %v", err)
+	}
+	failedLoad := f.LastLoad()
+	if _, err := f.Update(); err == nil {
+		t.Error("Update() of an empty file, expected error")
+	}
+	if got := f.Key(); got != "first-key" {
+		t.Errorf("Key() after a failed update = %q, want %q", got, "first-key")
+	}
+	if !f.LastLoad().Equal(failedLoad) {
+		t.Errorf("LastLoad() after a failed update = %v, want %v", f.LastLoad(), failedLoad)
+	}
+
+	if err := os.WriteFile(path, []byte("second-key"), 0600); err != nil {
+		t.Fatalf("failed to write API key file: %v", err)
+	}
+	if changed, err := f.Update(); err != nil || !changed {
+		t.Errorf("Update() of a changed file = %v, %v, want true, nil", changed, err)
+	}
+	if got := f.Key(); got != "second-key" {
+		t.Errorf("Key() = %q, want %q", got, "second-key")
+	}
+	if f.LastLoad().Before(firstLoad) {
+		t.Errorf("LastLoad() = %v, want at or after %v", f.LastLoad(), firstLoad)
+	}
+}
+
+func TestFileRun(t *testing.T) {
+	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+	path := filepath.Join(t.TempDir(), "api-key")
+	if err := os.WriteFile(path, []byte("first-key"), 0600); err != nil {
+		t.Fatalf("failed to write API key file: %v", err)
+	}
+
+	f, err := NewFile(path, 10*time.Millisecond, logger)
+	if err != nil {
+		t.Fatalf("NewFile() returned an unexpected error: %v", err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	keys := make(chan string, 1)
+	go f.Run(ctx, func(key string) { keys <- key })
+
+	if err := os.WriteFile(path, []byte("second-key"), 0600); err != nil {
+		t.Fatalf("failed to write API key file: %v", err)
+	}
+	select {
+	case key := <-keys:
+		if key != "second-key" {
+			t.Errorf("onChange() called with %q, want %q", key, "second-key")
+		}
+	case <-time.After(time.Second):
+		t.Error("onChange() was not called after the API key file changed")
+	}
+}
@@ -12,11 +12,16 @@ import (
 
 // MistCollector implements the prometheus.Collector interface.
 type MistCollector struct {
-	client *mistclient.APIClient
 	orgID  string
-	filter *filter.Filter
 	wg     *sync.WaitGroup
 	logger *slog.Logger
+
+	mu     sync.RWMutex
+	client *mistclient.APIClient
+	filter *filter.Filter
+
+	orgDescs  *orgDescs
+	siteDescs *siteDescs
 }
 
 // New creates a new MistCollector.
@@ -31,9 +36,36 @@ func New(client *mistclient.APIClient, orgID string, siteFilter *filter.Filter,
 		filter: siteFilter,
 		wg:     &sync.WaitGroup{},
 		logger: logger.With(slog.String("component", "collector")),
+
+		orgDescs:  newOrgDescs(),
+		siteDescs: newSiteDescs(),
 	}, nil
 }
 
+// SetFilter replaces the site filter applied to subsequent collections.
+func (c *MistCollector) SetFilter(siteFilter *filter.Filter) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.filter = siteFilter
+}
+
+// SetClient replaces the Mist API client, e.g. after the API key has been rotated.
+func (c *MistCollector) SetClient(client *mistclient.APIClient) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.client = client
+}
+
+// apiClient returns the current Mist API client.
+func (c *MistCollector) apiClient() *mistclient.APIClient {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	return c.client
+}
+
 // Describe implements the prometheus.Collector interface.
 func (c *MistCollector) Describe(ch chan<- *prometheus.Desc) {
 	prometheus.DescribeByCollect(c, ch)
@@ -1,47 +1,59 @@
 package collector
 
-import "github.com/prometheus/client_golang/prometheus"
-
-var (
-	alarmsDesc = prometheus.NewDesc(
-		"mist_org_alarms",
-		"Total number of unresolved alarms in the organization.",
-		[]string{"alarm_type"},
-		nil,
-	)
-	ticketsDesc = prometheus.NewDesc(
-		"mist_org_tickets",
-		"Total number of tickets in the organization by status.",
-		[]string{"ticket_status"},
-		nil,
-	)
+import (
+	"github.com/gregwight/mistexporter/internal/metrics"
+	"github.com/prometheus/client_golang/prometheus"
 )
 
+// orgDescs holds the descriptors of the organization metrics. These are created with
+// the collector as the metric namespace and static labels are configurable.
+type orgDescs struct {
+	alarms  *prometheus.Desc
+	tickets *prometheus.Desc
+}
+
+func newOrgDescs() *orgDescs {
+	return &orgDescs{
+		alarms: prometheus.NewDesc(
+			metrics.FQName("org_alarms"),
+			"Total number of unresolved alarms in the organization.",
+			[]string{"alarm_type"},
+			metrics.ConstLabels(),
+		),
+		tickets: prometheus.NewDesc(
+			metrics.FQName("org_tickets"),
+			"Total number of tickets in the organization by status.",
+			[]string{"ticket_status"},
+			metrics.ConstLabels(),
+		),
+	}
+}
+
 func (c *MistCollector) collectOrgAlarms(ch chan<- prometheus.Metric) {
 	defer c.wg.Done()
 
-	alarms, err := c.client.CountOrgAlarms(c.orgID)
+	alarms, err := c.apiClient().CountOrgAlarms(c.orgID)
 	if err != nil {
 		c.logger.Error("unable to fetch org alarms", "error", err)
 		return
 	}
 
 	for alarmType, count := range alarms {
-		c.sendMetric(ch, alarmsDesc, prometheus.GaugeValue, float64(count), alarmType)
+		c.sendMetric(ch, c.orgDescs.alarms, prometheus.GaugeValue, float64(count), alarmType)
 	}
 }
 
 func (c *MistCollector) collectOrgTickets(ch chan<- prometheus.Metric) {
 	defer c.wg.Done()
 
-	tickets, err := c.client.CountOrgTickets(c.orgID)
+	tickets, err := c.apiClient().CountOrgTickets(c.orgID)
 	if err != nil {
 		c.logger.Error("unable to fetch org tickets", "error", err)
 		return
 	}
 
 	for status, count := range tickets {
-		c.sendMetric(ch, ticketsDesc, prometheus.GaugeValue, float64(count), status.String())
+		c.sendMetric(ch, c.orgDescs.tickets, prometheus.GaugeValue, float64(count), status.String())
 
 	}
 }
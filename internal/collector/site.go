@@ -5,92 +5,115 @@ import (
 	"github.com/prometheus/client_golang/prometheus"
 )
 
-var (
-	latDesc = prometheus.NewDesc(
-		"mist_site_lat",
-		"Geographic latitude of the site.",
-		metrics.SiteLabelNames,
-		nil,
-	)
-	lngDesc = prometheus.NewDesc(
-		"mist_site_lng",
-		"Geographic longitude of the site.",
-		metrics.SiteLabelNames,
-		nil,
-	)
-	modifiedTimeDesc = prometheus.NewDesc(
-		"mist_site_modified_time",
-		"The last time site was modified, as a Unix timestamp.",
-		metrics.SiteLabelNames,
-		nil,
-	)
-	numAPDesc = prometheus.NewDesc(
-		"mist_site_num_ap",
-		"Total number of APs configured for the site.",
-		metrics.SiteLabelNames,
-		nil,
-	)
-	numAPConnectedDesc = prometheus.NewDesc(
-		"mist_site_num_ap_connected",
-		"Number of APs currently online at the site.",
-		metrics.SiteLabelNames,
-		nil,
-	)
-	numClientsDesc = prometheus.NewDesc(
-		"mist_site_num_clients",
-		"Total number of clients currently connected to the site.",
-		metrics.SiteLabelNames,
-		nil,
-	)
-	numDevicesDesc = prometheus.NewDesc(
-		"mist_site_num_devices",
-		"Total number of Mist devices (APs, switches, gateways) at the site.",
-		metrics.SiteLabelNames,
-		nil,
-	)
-	numDevicesConnectedDesc = prometheus.NewDesc(
-		"mist_site_num_devices_connected",
-		"Number of Mist devices (APs, switches, gateways) currently online at the site.",
-		metrics.SiteLabelNames,
-		nil,
-	)
-	numGatewayDesc = prometheus.NewDesc(
-		"mist_site_num_gateway",
-		"Total number of gateways configured for the site.",
-		metrics.SiteLabelNames,
-		nil,
-	)
-	numGatewayConnectedDesc = prometheus.NewDesc(
-		"mist_site_num_gateway_connected",
-		"Number of gateways currently online at the site.",
-		metrics.SiteLabelNames,
-		nil,
-	)
-	numSwitchDesc = prometheus.NewDesc(
-		"mist_site_num_switch",
-		"Total number of switches configured for the site.",
-		metrics.SiteLabelNames,
-		nil,
-	)
-	numSwitchConnectedDesc = prometheus.NewDesc(
-		"mist_site_num_switch_connected",
-		"Number of switches currently online at the site.",
-		metrics.SiteLabelNames,
-		nil,
-	)
-)
+// siteDescs holds the descriptors of the site metrics. These are created with the
+// collector as the site labels are configurable.
+type siteDescs struct {
+	lat                 *prometheus.Desc
+	lng                 *prometheus.Desc
+	modifiedTime        *prometheus.Desc
+	numAP               *prometheus.Desc
+	numAPConnected      *prometheus.Desc
+	numClients          *prometheus.Desc
+	numDevices          *prometheus.Desc
+	numDevicesConnected *prometheus.Desc
+	numGateway          *prometheus.Desc
+	numGatewayConnected *prometheus.Desc
+	numSwitch           *prometheus.Desc
+	numSwitchConnected  *prometheus.Desc
+}
+
+func newSiteDescs() *siteDescs {
+	return &siteDescs{
+		lat: prometheus.NewDesc(
+			metrics.FQName("site_lat"),
+			"Geographic latitude of the site.",
+			metrics.SiteLabelNames(),
+			metrics.ConstLabels(),
+		),
+		lng: prometheus.NewDesc(
+			metrics.FQName("site_lng"),
+			"Geographic longitude of the site.",
+			metrics.SiteLabelNames(),
+			metrics.ConstLabels(),
+		),
+		modifiedTime: prometheus.NewDesc(
+			metrics.FQName("site_modified_time"),
+			"The last time site was modified, as a Unix timestamp.",
+			metrics.SiteLabelNames(),
+			metrics.ConstLabels(),
+		),
+		numAP: prometheus.NewDesc(
+			metrics.FQName("site_num_ap"),
+			"Total number of APs configured for the site.",
+			metrics.SiteLabelNames(),
+			metrics.ConstLabels(),
+		),
+		numAPConnected: prometheus.NewDesc(
+			metrics.FQName("site_num_ap_connected"),
+			"Number of APs currently online at the site.",
+			metrics.SiteLabelNames(),
+			metrics.ConstLabels(),
+		),
+		numClients: prometheus.NewDesc(
+			metrics.FQName("site_num_clients"),
+			"Total number of clients currently connected to the site.",
+			metrics.SiteLabelNames(),
+			metrics.ConstLabels(),
+		),
+		numDevices: prometheus.NewDesc(
+			metrics.FQName("site_num_devices"),
+			"Total number of Mist devices (APs, switches, gateways) at the site.",
+			metrics.SiteLabelNames(),
+			metrics.ConstLabels(),
+		),
+		numDevicesConnected: prometheus.NewDesc(
+			metrics.FQName("site_num_devices_connected"),
+			"Number of Mist devices (APs, switches, gateways) currently online at the site.",
+			metrics.SiteLabelNames(),
+			metrics.ConstLabels(),
+		),
+		numGateway: prometheus.NewDesc(
+			metrics.FQName("site_num_gateway"),
+			"Total number of gateways configured for the site.",
+			metrics.SiteLabelNames(),
+			metrics.ConstLabels(),
+		),
+		numGatewayConnected: prometheus.NewDesc(
+			metrics.FQName("site_num_gateway_connected"),
+			"Number of gateways currently online at the site.",
+			metrics.SiteLabelNames(),
+			metrics.ConstLabels(),
+		),
+		numSwitch: prometheus.NewDesc(
+			metrics.FQName("site_num_switch"),
+			"Total number of switches configured for the site.",
+			metrics.SiteLabelNames(),
+			metrics.ConstLabels(),
+		),
+		numSwitchConnected: prometheus.NewDesc(
+			metrics.FQName("site_num_switch_connected"),
+			"Number of switches currently online at the site.",
+			metrics.SiteLabelNames(),
+			metrics.ConstLabels(),
+		),
+	}
+}
 
 func (c *MistCollector) collectSiteStats(ch chan<- prometheus.Metric) {
 	defer c.wg.Done()
 
-	sites, err := c.client.GetOrgSites(c.orgID)
+	sites, err := c.apiClient().GetOrgSites(c.orgID)
 	if err != nil {
 		c.logger.Error("unable to fetch sites", "error", err)
 		return
 	}
 
+	c.mu.RLock()
+	siteFilter := c.filter
+	c.mu.RUnlock()
+
 	for _, site := range sites {
-		if isFiltered, err := c.filter.IsFiltered(site); err != nil {
+		if isFiltered, err := siteFilter.IsFiltered(site); err != nil {
 			c.logger.Error("unable to apply site filter to site", "site", site.Name, "error", err)
 			continue
 		} else if isFiltered {
@@ -101,7 +124,7 @@ func (c *MistCollector) collectSiteStats(ch chan<- prometheus.Metric) {
 		go func() {
 			defer c.wg.Done()
 
-			stat, err := c.client.GetSiteStats(site.ID)
+			stat, err := c.apiClient().GetSiteStats(site.ID)
 			if err != nil {
 				c.logger.Error("unable to fetch site stats", "error", err)
 				return
@@ -109,18 +132,18 @@ func (c *MistCollector) collectSiteStats(ch chan<- prometheus.Metric) {
 
 			labels := metrics.SiteLabelValues(site)
 
-			c.sendMetric(ch, latDesc, prometheus.GaugeValue, float64(stat.Lat), labels...)
-			c.sendMetric(ch, lngDesc, prometheus.GaugeValue, float64(stat.Lng), labels...)
-			c.sendMetric(ch, modifiedTimeDesc, prometheus.GaugeValue, float64(stat.ModifiedTime.Unix()), labels...)
-			c.sendMetric(ch, numAPDesc, prometheus.GaugeValue, float64(stat.NumAP), labels...)
-			c.sendMetric(ch, numAPConnectedDesc, prometheus.GaugeValue, float64(stat.NumAPConnected), labels...)
-			c.sendMetric(ch, numClientsDesc, prometheus.GaugeValue, float64(stat.NumClients), labels...)
-			c.sendMetric(ch, numDevicesDesc, prometheus.GaugeValue, float64(stat.NumDevices), labels...)
-			c.sendMetric(ch, numDevicesConnectedDesc, prometheus.GaugeValue, float64(stat.NumDevicesConnected), labels...)
-			c.sendMetric(ch, numGatewayDesc, prometheus.GaugeValue, float64(stat.NumGateway), labels...)
-			c.sendMetric(ch, numGatewayConnectedDesc, prometheus.GaugeValue, float64(stat.NumGatewayConnected), labels...)
-			c.sendMetric(ch, numSwitchDesc, prometheus.GaugeValue, float64(stat.NumSwitch), labels...)
-			c.sendMetric(ch, numSwitchConnectedDesc, prometheus.GaugeValue, float64(stat.NumSwitchConnected), labels...)
+			c.sendMetric(ch, c.siteDescs.lat, prometheus.GaugeValue, float64(stat.Lat), labels...)
+			c.sendMetric(ch, c.siteDescs.lng, prometheus.GaugeValue, float64(stat.Lng), labels...)
+			c.sendMetric(ch, c.siteDescs.modifiedTime, prometheus.GaugeValue, float64(stat.ModifiedTime.Unix()), labels...)
+			c.sendMetric(ch, c.siteDescs.numAP, prometheus.GaugeValue, float64(stat.NumAP), labels...)
+			c.sendMetric(ch, c.siteDescs.numAPConnected, prometheus.GaugeValue, float64(stat.NumAPConnected), labels...)
+			c.sendMetric(ch, c.siteDescs.numClients, prometheus.GaugeValue, float64(stat.NumClients), labels...)
+			c.sendMetric(ch, c.siteDescs.numDevices, prometheus.GaugeValue, float64(stat.NumDevices), labels...)
+			c.sendMetric(ch, c.siteDescs.numDevicesConnected, prometheus.GaugeValue, float64(stat.NumDevicesConnected), labels...)
+			c.sendMetric(ch, c.siteDescs.numGateway, prometheus.GaugeValue, float64(stat.NumGateway), labels...)
+			c.sendMetric(ch, c.siteDescs.numGatewayConnected, prometheus.GaugeValue, float64(stat.NumGatewayConnected), labels...)
+			c.sendMetric(ch, c.siteDescs.numSwitch, prometheus.GaugeValue, float64(stat.NumSwitch), labels...)
+			c.sendMetric(ch, c.siteDescs.numSwitchConnected, prometheus.GaugeValue, float64(stat.NumSwitchConnected), labels...)
 
 		}()
 
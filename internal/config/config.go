@@ -1,8 +1,12 @@
 package config
 
 import (
+	"errors"
 	"fmt"
+	"io"
 	"os"
+	"reflect"
+	"strings"
 	"time"
 
 	"github.com/gregwight/mistclient"
@@ -10,74 +14,396 @@ import (
 )
 
 const (
-	defaultAPIURL                    string        = "https://api.mist.com"
-	defaultExporterAddress           string        = "0.0.0.0"
-	defaultExporterPort              int           = 10038
-	defaultCollectTimeout            time.Duration = 30 * time.Second
-	defaultSiteRefreshInterval       time.Duration = 1 * time.Minute
-	defaultDeviceNameRefreshInterval time.Duration = 1 * time.Minute
+	defaultAPIURL                      string        = "https://api.mist.com"
+	defaultAPIKeyFileRefreshInterval   time.Duration = 30 * time.Second
+	defaultExporterAddress             string        = "0.0.0.0"
+	defaultExporterPort                int           = 10038
+	defaultExporterNamespace           string        = "mist"
+	defaultCollectTimeout              time.Duration = 30 * time.Second
+	defaultSiteRefreshInterval         time.Duration = 1 * time.Minute
+	defaultDeviceNameRefreshInterval   time.Duration = 1 * time.Minute
+	defaultSiteVariableRefreshInterval time.Duration = 10 * time.Minute
+	defaultClientTTL                   time.Duration = 5 * time.Minute
+	defaultClientBreakdownTopN         int           = 10
+	defaultClientTopNRankBy            string        = "throughput"
+	defaultClientTopNInterval          time.Duration = 1 * time.Minute
+	defaultClientHistogramInterval     time.Duration = 1 * time.Minute
+	defaultNativeHistogramFactor       float64       = 1.1
+	defaultSeriesLimitPolicy           string        = "reject"
+	defaultReadyMinStreamsPercent      float64       = 50
+	defaultReadyMaxAPISuccessAge       time.Duration = 5 * time.Minute
 )
 
-// Config holds the top-level exporter configuration.
+// Config holds the top-level exporter configuration. Fields holding credentials must be
+// tagged `secret:"true"` so that they are redacted by Config.Redacted.
 type Config struct {
-	OrgId      string             `yaml:"org_id,omitempty"`
-	MistClient *mistclient.Config `yaml:"mist_api,omitempty"`
-	Exporter   *Exporter          `yaml:"exporter,omitempty"`
-	Collector  *Collector         `yaml:"collector,omitempty"`
+	OrgId      string     `yaml:"org_id,omitempty"`
+	MistClient *MistAPI   `yaml:"mist_api,omitempty"`
+	Exporter   *Exporter  `yaml:"exporter,omitempty"`
+	Collector  *Collector `yaml:"collector,omitempty"`
+
+	MetricRelabelConfigs []*RelabelConfig `yaml:"metric_relabel_configs,omitempty"`
+
+	source  string
+	modTime time.Time
+}
+
+// MistAPI holds configuration relevant to the Mist API client. APIKeyFile may be set instead
+// of APIKey to load the API key from a file, which is re-read every APIKeyFileRefreshInterval
+// so that the key can be rotated without restarting the exporter.
+type MistAPI struct {
+	mistclient.Config         `yaml:",inline" secret:"api_key"`
+	APIKeyFile                string        `yaml:"api_key_file,omitempty"`
+	APIKeyFileRefreshInterval time.Duration `yaml:"api_key_file_refresh_interval,omitempty"`
+}
+
+// RelabelConfig defines a metric relabeling rule, with the same semantics as the
+// Prometheus metric_relabel_configs. Action is one of "replace", "keep", "drop",
+// "hashmod", "labelmap", "labeldrop" or "labelkeep".
+type RelabelConfig struct {
+	SourceLabels []string `yaml:"source_labels,flow,omitempty"`
+	Separator    string   `yaml:"separator,omitempty"`
+	Regex        string   `yaml:"regex,omitempty"`
+	Modulus      uint64   `yaml:"modulus,omitempty"`
+	TargetLabel  string   `yaml:"target_label,omitempty"`
+	Replacement  string   `yaml:"replacement,omitempty"`
+	Action       string   `yaml:"action,omitempty"`
+}
+
+// UnmarshalYAML implements the yaml.Unmarshaler interface, applying the Prometheus
+// defaults to fields which are not set.
+func (c *RelabelConfig) UnmarshalYAML(value *yaml.Node) error {
+	*c = RelabelConfig{
+		Separator:   ";",
+		Regex:       "(.*)",
+		Replacement: "$1",
+		Action:      "replace",
+	}
+	if err := checkKnownFields(value, c); err != nil {
+		return err
+	}
+	type plain RelabelConfig
+	return value.Decode((*plain)(c))
 }
 
-// Exporter holds configuration relevant to exporter's HTTP server.
+// checkKnownFields returns an error if the mapping node has a key which is not a field
+// of the struct pointed to by v. It is required by custom unmarshalers, as decoding a
+// node does not inherit the strictness of the parent decoder.
+func checkKnownFields(node *yaml.Node, v any) error {
+	if node.Kind != yaml.MappingNode {
+		return nil
+	}
+
+	t := reflect.TypeOf(v).Elem()
+	known := make(map[string]bool, t.NumField())
+	for i := range t.NumField() {
+		name, _, _ := strings.Cut(t.Field(i).Tag.Get("yaml"), ",")
+		known[name] = true
+	}
+	for i := 0; i < len(node.Content); i += 2 {
+		if key := node.Content[i]; !known[key.Value] {
+			return fmt.Errorf("line %d: field %s not found in type %s", key.Line, key.Value, t)
+		}
+	}
+
+	return nil
+}
+
+// Exporter holds configuration relevant to exporter's HTTP server. If AdminAddress is set,
+// the administrative endpoints are served on a separate listener at that host:port address
+// rather than alongside the metrics.
 type Exporter struct {
-	Address string `yaml:"address,omitempty"`
-	Port    int    `yaml:"port,omitempty"`
+	Address      string            `yaml:"address,omitempty"`
+	Port         int               `yaml:"port,omitempty"`
+	AdminAddress string            `yaml:"admin_address,omitempty"`
+	Namespace    string            `yaml:"namespace,omitempty"`
+	StaticLabels map[string]string `yaml:"static_labels,omitempty"`
+	TLS          *TLS              `yaml:"tls,omitempty"`
+	Auth         *Auth             `yaml:"auth,omitempty"`
+	Readiness    *Readiness        `yaml:"readiness,omitempty"`
+}
+
+// Readiness holds the thresholds of the /-/ready endpoint. The exporter is ready once the
+// device name map has been loaded, at least MinStreamsPercent of the site streams are
+// connected, and a Mist API request has succeeded within MaxAPISuccessAge.
+type Readiness struct {
+	MinStreamsPercent float64       `yaml:"min_streams_percent,omitempty"`
+	MaxAPISuccessAge  time.Duration `yaml:"max_api_success_age,omitempty"`
+}
+
+// TLS holds the TLS configuration of the exporter's HTTP server. The certificate and key
+// are re-read when their files change. ClientAuthType is one of the crypto/tls client
+// auth type names, e.g. "RequireAndVerifyClientCert", and defaults to requiring and
+// verifying a client certificate when ClientCAFile is set. MinVersion is one of "TLS10",
+// "TLS11", "TLS12" (the default) or "TLS13".
+type TLS struct {
+	CertFile       string `yaml:"cert_file,omitempty"`
+	KeyFile        string `yaml:"key_file,omitempty"`
+	ClientCAFile   string `yaml:"client_ca_file,omitempty"`
+	ClientAuthType string `yaml:"client_auth_type,omitempty"`
+	MinVersion     string `yaml:"min_version,omitempty"`
+}
+
+// Auth holds the authentication required by the exporter's HTTP server. Requests must
+// present the credentials of one of the BasicAuthUsers, which maps user names to plain
+// text or "sha256:"-prefixed hex encoded SHA-256 passwords, or the bearer token. Endpoints
+// lists the paths requiring authentication, where paths ending in a slash match all paths
+// below them, and defaults to all endpoints.
+type Auth struct {
+	BasicAuthUsers  map[string]string `yaml:"basic_auth_users,omitempty" secret:"true"`
+	BearerToken     string            `yaml:"bearer_token,omitempty" secret:"true"`
+	BearerTokenFile string            `yaml:"bearer_token_file,omitempty"`
+	Endpoints       []string          `yaml:"endpoints,flow,omitempty"`
 }
 
 // Collector holds configuration relevant to metrics collection.
 type Collector struct {
-	CollectTimeout            time.Duration `yaml:"collect_timeout,omitempty"`
-	DeviceNameRefreshInterval time.Duration `yaml:"device_name_refresh_interval,omitempty"`
-	SiteRefreshInterval       time.Duration `yaml:"site_refresh_interval,omitempty"`
-	SiteFilter                *SiteFilter   `yaml:"site_filter,omitempty"`
+	CollectTimeout              time.Duration `yaml:"collect_timeout,omitempty"`
+	DeviceNameRefreshInterval   time.Duration `yaml:"device_name_refresh_interval,omitempty"`
+	SiteRefreshInterval         time.Duration `yaml:"site_refresh_interval,omitempty"`
+	SiteVariableRefreshInterval time.Duration `yaml:"site_variable_refresh_interval,omitempty"`
+	SiteFilter                  *SiteFilter   `yaml:"site_filter,omitempty"`
+	SiteLabels                  []SiteLabel   `yaml:"site_labels,omitempty"`
+	DeviceFilter                *DeviceFilter `yaml:"device_filter,omitempty"`
+	ClientFilter                *ClientFilter `yaml:"client_filter,omitempty"`
+	Clients                     *Clients      `yaml:"clients,omitempty"`
+	SeriesLimits                *SeriesLimits `yaml:"series_limits,omitempty"`
+	StreamDebug                 *StreamDebug  `yaml:"stream_debug,omitempty"`
+}
+
+// StreamDebug holds configuration relevant to the /debug/streams endpoint, which shows the
+// last Messages device and client stats received from the stream of each site. It is
+// disabled when Messages is 0, the default. If RedactClients is set, client identifiers
+// are removed from the stats as they are received.
+type StreamDebug struct {
+	Messages      int  `yaml:"messages,omitempty"`
+	RedactClients bool `yaml:"redact_clients"`
+}
+
+// SeriesLimits holds limits on the number of series exported for each metric family.
+// Default applies to all families not listed in Families, which is keyed by the full
+// metric name. A limit of 0 disables the limit. Policy is one of "reject" or "evict".
+// The aggregated client families are computed at scrape time and are not limited.
+type SeriesLimits struct {
+	Default  int            `yaml:"default,omitempty"`
+	Families map[string]int `yaml:"families,omitempty"`
+	Policy   string         `yaml:"policy,omitempty"`
+}
+
+// Clients holds configuration relevant to wireless client metrics.
+type Clients struct {
+	TTL              time.Duration     `yaml:"ttl,omitempty"`
+	PerClientMetrics bool              `yaml:"per_client_metrics"`
+	BreakdownTopN    int               `yaml:"breakdown_top_n,omitempty"`
+	Labels           []string          `yaml:"labels,omitempty"`
+	Privacy          *ClientPrivacy    `yaml:"privacy,omitempty"`
+	TopN             *ClientTopN       `yaml:"top_n,omitempty"`
+	Histograms       *ClientHistograms `yaml:"histograms,omitempty"`
+}
+
+// ClientTopN holds configuration for limiting per-client series to the top ranked
+// clients at each site. RankBy is one of "throughput", "retries" or "rssi".
+type ClientTopN struct {
+	Limit    int           `yaml:"limit,omitempty"`
+	RankBy   string        `yaml:"rank_by,omitempty"`
+	Interval time.Duration `yaml:"interval,omitempty"`
+}
+
+// ClientPrivacy defines how client label values are protected before being exported.
+// Labels maps a client label name to one of "keep", "drop" or "hash".
+type ClientPrivacy struct {
+	HashKey string            `yaml:"hash_key,omitempty" secret:"true"`
+	Labels  map[string]string `yaml:"labels,omitempty"`
+}
+
+// ClientHistograms holds configuration relevant to the aggregated client signal quality histograms.
+// Each connected client is observed once every Interval, regardless of how often it is streamed.
+type ClientHistograms struct {
+	Enabled                     bool          `yaml:"enabled,omitempty"`
+	Interval                    time.Duration `yaml:"interval,omitempty"`
+	NativeHistograms            bool          `yaml:"native_histograms,omitempty"`
+	NativeHistogramBucketFactor float64       `yaml:"native_histogram_bucket_factor,omitempty"`
+}
+
+// SiteLabel defines an extra label attached to all site-scoped metrics. Source is one
+// of "site_id", "site_group", "address" or "variable", which requires Variable to name
+// the site variable whose value is used.
+type SiteLabel struct {
+	Name     string `yaml:"name"`
+	Source   string `yaml:"source"`
+	Variable string `yaml:"variable,omitempty"`
 }
 
 // SiteFilter defines rules for including or excluding sites from collection.
+// Include and Exclude hold glob patterns matched against site names, and are
+// combined with the rules in IncludeRules and ExcludeRules respectively.
 type SiteFilter struct {
-	Include []string `yaml:"include,omitempty"`
-	Exclude []string `yaml:"exclude,omitempty"`
+	Include      []string    `yaml:"include,omitempty"`
+	Exclude      []string    `yaml:"exclude,omitempty"`
+	IncludeRules []SiteMatch `yaml:"include_rules,omitempty"`
+	ExcludeRules []SiteMatch `yaml:"exclude_rules,omitempty"`
 }
 
-// LoadConfig loads and processes the YAML configuration with environment variable substitution.
-func LoadConfig(configPath string) (*Config, error) {
-	data, err := os.ReadFile(configPath)
-	if err != nil {
-		return nil, fmt.Errorf("error reading config file: %w", err)
-	}
+// SiteMatch defines a rule matching sites by their attributes. Match is one of
+// "all" (the default), requiring every configured attribute to match, or "any".
+// NameRegex holds regular expressions, and all other attributes glob patterns.
+// SiteGroup patterns are matched against the IDs and names of the site's groups.
+type SiteMatch struct {
+	Match       string   `yaml:"match,omitempty"`
+	ID          []string `yaml:"id,omitempty"`
+	Name        []string `yaml:"name,omitempty"`
+	NameRegex   []string `yaml:"name_regex,omitempty"`
+	CountryCode []string `yaml:"country_code,omitempty"`
+	Timezone    []string `yaml:"timezone,omitempty"`
+	SiteGroup   []string `yaml:"site_group,omitempty"`
+}
 
-	// Replace environment variables in the format ${VAR_NAME}
-	configStr := string(data)
-	configStr = os.ExpandEnv(configStr)
+// DeviceFilter defines rules for including or excluding devices from collection.
+// A device matches a list of rules if it matches any one rule. If DropClients is
+// set, the wireless clients of filtered devices are also excluded. The filter only
+// applies to streamed stats; site statistics and the device name map are unaffected.
+type DeviceFilter struct {
+	Include     []DeviceMatch `yaml:"include,omitempty"`
+	Exclude     []DeviceMatch `yaml:"exclude,omitempty"`
+	DropClients bool          `yaml:"drop_clients,omitempty"`
+}
+
+// DeviceMatch defines a rule matching devices by their attributes. A device
+// matches the rule if every configured attribute matches one of its glob patterns.
+type DeviceMatch struct {
+	Name  []string `yaml:"name,omitempty"`
+	MAC   []string `yaml:"mac,omitempty"`
+	Model []string `yaml:"model,omitempty"`
+	Type  []string `yaml:"type,omitempty"`
+}
+
+// ClientFilter defines rules for including or excluding wireless clients from collection.
+// A client matches a list of rules if it matches any one rule.
+type ClientFilter struct {
+	Include []ClientMatch `yaml:"include,omitempty"`
+	Exclude []ClientMatch `yaml:"exclude,omitempty"`
+}
+
+// ClientMatch defines a rule matching wireless clients by their attributes. A client
+// matches the rule if every configured attribute matches one of its glob patterns.
+type ClientMatch struct {
+	SSID         []string `yaml:"ssid,omitempty"`
+	Band         []string `yaml:"band,omitempty"`
+	Manufacturer []string `yaml:"manufacturer,omitempty"`
+	DeviceName   []string `yaml:"device_name,omitempty"`
+	Guest        *bool    `yaml:"guest,omitempty"`
+}
 
+// LoadConfig loads and processes the YAML configuration with environment variable substitution,
+// and then applies the overrides set by environment variables and flags. If configPath is
+// empty no file is read, and the configuration is built from the defaults and overrides.
+// Unknown fields are rejected, but the configuration is not validated; see Config.Validate.
+func LoadConfig(configPath string, flags Overrides) (*Config, error) {
 	config := newDefaultConfig()
-	if err := yaml.Unmarshal([]byte(configStr), config); err != nil {
-		return nil, fmt.Errorf("error parsing config file: %w", err)
+	if configPath != "" {
+		info, err := os.Stat(configPath)
+		if err != nil {
+			return nil, fmt.Errorf("error reading config file: %w", err)
+		}
+		data, err := os.ReadFile(configPath)
+		if err != nil {
+			return nil, fmt.Errorf("error reading config file: %w", err)
+		}
+
+		// Replace environment variables in the format ${VAR_NAME}
+		configStr := string(data)
+		configStr = os.ExpandEnv(configStr)
+
+		decoder := yaml.NewDecoder(strings.NewReader(configStr))
+		decoder.KnownFields(true)
+		if err := decoder.Decode(config); err != nil && !errors.Is(err, io.EOF) {
+			return nil, fmt.Errorf("error parsing config file: %w", err)
+		}
+		config.restoreDefaults()
+		config.source, config.modTime = configPath, info.ModTime()
+	}
+
+	if err := config.applyOverrides(flags); err != nil {
+		return nil, fmt.Errorf("error applying config overrides: %w", err)
 	}
 
 	return config, nil
 }
 
+// restoreDefaults restores the defaults of any section which was decoded as null, such as
+// a section with all of its settings commented out.
+func (c *Config) restoreDefaults() {
+	defaults := newDefaultConfig()
+	if c.MistClient == nil {
+		c.MistClient = defaults.MistClient
+	}
+	if c.Exporter == nil {
+		c.Exporter = defaults.Exporter
+	}
+	if c.Exporter.Readiness == nil {
+		c.Exporter.Readiness = defaults.Exporter.Readiness
+	}
+	if c.Collector == nil {
+		c.Collector = defaults.Collector
+	}
+	if c.Collector.Clients == nil {
+		c.Collector.Clients = defaults.Collector.Clients
+	}
+	if c.Collector.Clients.TopN == nil {
+		c.Collector.Clients.TopN = defaults.Collector.Clients.TopN
+	}
+	if c.Collector.Clients.Histograms == nil {
+		c.Collector.Clients.Histograms = defaults.Collector.Clients.Histograms
+	}
+	if c.Collector.SeriesLimits == nil {
+		c.Collector.SeriesLimits = defaults.Collector.SeriesLimits
+	}
+	if c.Collector.StreamDebug == nil {
+		c.Collector.StreamDebug = defaults.Collector.StreamDebug
+	}
+}
+
 func newDefaultConfig() *Config {
 	return &Config{
-		MistClient: &mistclient.Config{
-			BaseURL: defaultAPIURL,
+		MistClient: &MistAPI{
+			Config: mistclient.Config{
+				BaseURL: defaultAPIURL,
+			},
+			APIKeyFileRefreshInterval: defaultAPIKeyFileRefreshInterval,
 		},
 		Exporter: &Exporter{
-			Address: defaultExporterAddress,
-			Port:    defaultExporterPort,
+			Address:   defaultExporterAddress,
+			Port:      defaultExporterPort,
+			Namespace: defaultExporterNamespace,
+			Readiness: &Readiness{
+				MinStreamsPercent: defaultReadyMinStreamsPercent,
+				MaxAPISuccessAge:  defaultReadyMaxAPISuccessAge,
+			},
 		},
 		Collector: &Collector{
-			CollectTimeout:            defaultCollectTimeout,
-			DeviceNameRefreshInterval: defaultDeviceNameRefreshInterval,
-			SiteRefreshInterval:       defaultSiteRefreshInterval,
+			CollectTimeout:              defaultCollectTimeout,
+			DeviceNameRefreshInterval:   defaultDeviceNameRefreshInterval,
+			SiteRefreshInterval:         defaultSiteRefreshInterval,
+			SiteVariableRefreshInterval: defaultSiteVariableRefreshInterval,
+			Clients: &Clients{
+				TTL:              defaultClientTTL,
+				PerClientMetrics: true,
+				BreakdownTopN:    defaultClientBreakdownTopN,
+				TopN: &ClientTopN{
+					RankBy:   defaultClientTopNRankBy,
+					Interval: defaultClientTopNInterval,
+				},
+				Histograms: &ClientHistograms{
+					Interval:                    defaultClientHistogramInterval,
+					NativeHistogramBucketFactor: defaultNativeHistogramFactor,
+				},
+			},
+			SeriesLimits: &SeriesLimits{
+				Policy: defaultSeriesLimitPolicy,
+			},
+			StreamDebug: &StreamDebug{
+				RedactClients: true,
+			},
 		},
 	}
 }
@@ -1,8 +1,12 @@
 package config
 
 import (
+	"flag"
+	"fmt"
 	"os"
 	"path/filepath"
+	"slices"
+	"strings"
 	"testing"
 	"time"
 )
@@ -32,7 +36,7 @@ collector:
 	}
 
 	// Test loading the config
-	cfg, err := LoadConfig(configPath)
+	cfg, err := LoadConfig(configPath, nil)
 	if err != nil {
 		t.Fatalf("LoadConfig() returned an unexpected error: %v", err)
 	}
@@ -89,7 +93,7 @@ mist_api:
 	}
 
 	// Test loading the config
-	cfg, err := LoadConfig(configPath)
+	cfg, err := LoadConfig(configPath, nil)
 	if err != nil {
 		t.Fatalf("LoadConfig() returned an unexpected error: %v", err)
 	}
@@ -115,7 +119,7 @@ mist_api:
 	}
 
 	// Test loading the config
-	cfg, err := LoadConfig(configPath)
+	cfg, err := LoadConfig(configPath, nil)
 	if err != nil {
 		t.Fatalf("LoadConfig() returned an unexpected error: %v", err)
 	}
@@ -139,8 +143,382 @@ mist_api:
 }
 
 func TestLoadConfig_FileNotExist(t *testing.T) {
-	_, err := LoadConfig("non-existent-file.yaml")
+	_, err := LoadConfig("non-existent-file.yaml", nil)
 	if err == nil {
 		t.Error("expected an error for non-existent file, but got nil")
 	}
 }
+
+func TestLoadConfig_UnknownField(t *testing.T) {
+	for name, content := range map[string]string{
+		"top-level":      "mist_api:\n  api_key: key\ncollector:\n  site_fliter:\n    include: [\"*\"]\n",
+		"relabel config": "mist_api:\n  api_key: key\nmetric_relabel_configs:\n  - regex: \"client_.*\"\n    acton: labeldrop\n",
+	} {
+		t.Run(name, func(t *testing.T) {
+			configPath := filepath.Join(t.TempDir(), "config.yaml")
+			if err := os.WriteFile(configPath, []byte(content), 0644); err != nil {
+				t.Fatalf("failed to write temp config file: %v", err)
+			}
+
+			if _, err := LoadConfig(configPath, nil); err == nil {
+				t.Error("expected an error for an unknown field, but got nil")
+			}
+		})
+	}
+}
+
+func TestLoadConfig_EmptySections(t *testing.T) {
+	content := `
+mist_api:
+  api_key: "my-api-key"
+exporter:
+collector:
+`
+	configPath := filepath.Join(t.TempDir(), "config.yaml")
+	if err := os.WriteFile(configPath, []byte(content), 0644); err != nil {
+		t.Fatalf("failed to write temp config file: %v", err)
+	}
+
+	cfg, err := LoadConfig(configPath, nil)
+	if err != nil {
+		t.Fatalf("LoadConfig() returned an unexpected error: %v", err)
+	}
+	if cfg.Exporter == nil || cfg.Exporter.Port != defaultExporterPort {
+		t.Errorf("expected empty exporter section to keep defaults, got %+v", cfg.Exporter)
+	}
+	if cfg.Collector == nil || cfg.Collector.SiteRefreshInterval != defaultSiteRefreshInterval {
+		t.Errorf("expected empty collector section to keep defaults, got %+v", cfg.Collector)
+	}
+	if err := cfg.Validate(); err != nil {
+		t.Errorf("Validate() returned an unexpected error: %v", err)
+	}
+}
+
+func TestValidate(t *testing.T) {
+	testCases := []struct {
+		name     string
+		modify   func(cfg *Config)
+		wantErrs []string
+	}{
+		{
+			name:   "valid",
+			modify: func(cfg *Config) {},
+		},
+		{
+			name: "missing api key and invalid url",
+			modify: func(cfg *Config) {
+				cfg.MistClient.APIKey = ""
+				cfg.MistClient.BaseURL = "api.mist.com"
+			},
+			wantErrs: []string{"mist_api.api_key", "mist_api.base_url"},
+		},
+		{
+			name: "api key and api key file",
+			modify: func(cfg *Config) {
+				cfg.MistClient.APIKeyFile = "/run/secrets/mist-api-key"
+			},
+			wantErrs: []string{"mist_api.api_key"},
+		},
+		{
+			name: "api key file only",
+			modify: func(cfg *Config) {
+				cfg.MistClient.APIKey = ""
+				cfg.MistClient.APIKeyFile = "/run/secrets/mist-api-key"
+			},
+		},
+		{
+			name: "invalid port and intervals",
+			modify: func(cfg *Config) {
+				cfg.Exporter.Port = 70000
+				cfg.Collector.SiteRefreshInterval = 0
+				cfg.Collector.Clients.TTL = -time.Second
+			},
+			wantErrs: []string{"exporter.port", "collector.site_refresh_interval", "collector.clients.ttl"},
+		},
+		{
+			name: "invalid admin address",
+			modify: func(cfg *Config) {
+				cfg.Exporter.AdminAddress = "localhost"
+			},
+			wantErrs: []string{"exporter.admin_address"},
+		},
+		{
+			name: "admin address conflicts with exporter address",
+			modify: func(cfg *Config) {
+				cfg.Exporter.AdminAddress = fmt.Sprintf("127.0.0.1:%d", cfg.Exporter.Port)
+			},
+			wantErrs: []string{"exporter.admin_address"},
+		},
+		{
+			name: "admin address",
+			modify: func(cfg *Config) {
+				cfg.Exporter.AdminAddress = "127.0.0.1:10039"
+			},
+		},
+		{
+			name: "negative stream debug messages",
+			modify: func(cfg *Config) {
+				cfg.Collector.StreamDebug = &StreamDebug{Messages: -1}
+			},
+			wantErrs: []string{"collector.stream_debug.messages"},
+		},
+		{
+			name: "invalid readiness thresholds",
+			modify: func(cfg *Config) {
+				cfg.Exporter.Readiness = &Readiness{MinStreamsPercent: 150}
+			},
+			wantErrs: []string{"exporter.readiness.min_streams_percent", "exporter.readiness.max_api_success_age"},
+		},
+		{
+			name: "invalid tls and auth",
+			modify: func(cfg *Config) {
+				cfg.Exporter.TLS = &TLS{CertFile: "tls.crt", ClientAuthType: "Always", MinVersion: "TLS14"}
+				cfg.Exporter.Auth = &Auth{BearerToken: "token", BearerTokenFile: "token-file", Endpoints: []string{"metrics"}}
+			},
+			wantErrs: []string{
+				"exporter.tls",
+				"exporter.tls.client_auth_type",
+				"exporter.tls.min_version",
+				"exporter.auth",
+				"exporter.auth.endpoints",
+			},
+		},
+		{
+			name: "auth without credentials",
+			modify: func(cfg *Config) {
+				cfg.Exporter.Auth = &Auth{Endpoints: []string{"/metrics"}}
+			},
+			wantErrs: []string{"exporter.auth"},
+		},
+		{
+			name: "invalid filters",
+			modify: func(cfg *Config) {
+				cfg.Collector.SiteFilter = &SiteFilter{
+					Include:      []string{"[Main"},
+					ExcludeRules: []SiteMatch{{Match: "some", NameRegex: []string{"("}}},
+				}
+				cfg.Collector.DeviceFilter = &DeviceFilter{Exclude: []DeviceMatch{{Name: []string{"[ap"}}}}
+				cfg.Collector.ClientFilter = &ClientFilter{Include: []ClientMatch{{SSID: []string{"[Corp"}}}}
+			},
+			wantErrs: []string{
+				"collector.site_filter.include",
+				"collector.site_filter.exclude_rules[0].match",
+				"collector.site_filter.exclude_rules[0].name_regex",
+				"collector.device_filter.exclude[0].name",
+				"collector.client_filter.include[0].ssid",
+			},
+		},
+		{
+			name: "invalid series limits",
+			modify: func(cfg *Config) {
+				cfg.Collector.SeriesLimits = &SeriesLimits{Default: -1, Policy: "oldest"}
+			},
+			wantErrs: []string{"collector.series_limits.policy", "collector.series_limits.default"},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			cfg := newDefaultConfig()
+			cfg.MistClient.APIKey = "my-api-key"
+			tc.modify(cfg)
+
+			err := cfg.Validate()
+			if len(tc.wantErrs) == 0 {
+				if err != nil {
+					t.Errorf("Validate() returned an unexpected error: %v", err)
+				}
+				return
+			}
+			if err == nil {
+				t.Fatal("Validate() expected an error, but got nil")
+			}
+			for _, want := range tc.wantErrs {
+				if !strings.Contains(err.Error(), want+":") {
+					t.Errorf("Validate() error does not report %q:\n%v", want, err)
+				}
+			}
+		})
+	}
+}
+
+func TestLoadConfig_Overrides(t *testing.T) {
+	content := `
+org_id: "file-org-id"
+mist_api:
+  api_key: "file-api-key"
+exporter:
+  port: 9999
+collector:
+  site_refresh_interval: 5m
+`
+	configPath := filepath.Join(t.TempDir(), "config.yaml")
+	if err := os.WriteFile(configPath, []byte(content), 0644); err != nil {
+		t.Fatalf("failed to write temp config file: %v", err)
+	}
+
+	t.Setenv("MIST_API_KEY", "env-api-key")
+	t.Setenv("MIST_EXPORTER_PORT", "8888")
+	t.Setenv("MIST_ORG_ID", "")
+	t.Setenv("MIST_COLLECTOR_SITE_FILTER_INCLUDE", "Main Office-*, Branch-*")
+	t.Setenv("MIST_EXPORTER_STATIC_LABELS", "{region: eu}")
+
+	fs := flag.NewFlagSet("test", flag.ContinueOnError)
+	overrides := RegisterFlags(fs)
+	if err := fs.Parse([]string{"-exporter.port", "7777", "-collector.clients.top_n.interval", "30s"}); err != nil {
+		t.Fatalf("failed to parse flags: %v", err)
+	}
+
+	cfg, err := LoadConfig(configPath, overrides)
+	if err != nil {
+		t.Fatalf("LoadConfig() returned an unexpected error: %v", err)
+	}
+
+	if cfg.OrgId != "file-org-id" {
+		t.Errorf("expected empty environment variable to be ignored, got OrgId %q", cfg.OrgId)
+	}
+	if cfg.MistClient.APIKey != "env-api-key" {
+		t.Errorf("expected environment variable to override file, got APIKey %q", cfg.MistClient.APIKey)
+	}
+	if cfg.Exporter.Port != 7777 {
+		t.Errorf("expected flag to override environment variable, got Port %d", cfg.Exporter.Port)
+	}
+	if cfg.Collector.SiteRefreshInterval != 5*time.Minute {
+		t.Errorf("expected file value to be kept, got SiteRefreshInterval %v", cfg.Collector.SiteRefreshInterval)
+	}
+	if cfg.Collector.DeviceNameRefreshInterval != defaultDeviceNameRefreshInterval {
+		t.Errorf("expected default to be kept, got DeviceNameRefreshInterval %v", cfg.Collector.DeviceNameRefreshInterval)
+	}
+	if cfg.Collector.Clients.TopN.Interval != 30*time.Second {
+		t.Errorf("expected flag to override default, got TopN.Interval %v", cfg.Collector.Clients.TopN.Interval)
+	}
+	if cfg.Collector.SiteFilter == nil || !slices.Equal(cfg.Collector.SiteFilter.Include, []string{"Main Office-*", "Branch-*"}) {
+		t.Errorf("unexpected SiteFilter: got %+v", cfg.Collector.SiteFilter)
+	}
+	if cfg.Exporter.StaticLabels["region"] != "eu" {
+		t.Errorf("unexpected StaticLabels: got %v", cfg.Exporter.StaticLabels)
+	}
+
+	// Without a file, the configuration is built from the defaults and overrides alone.
+	cfg, err = LoadConfig("", nil)
+	if err != nil {
+		t.Fatalf("LoadConfig() without a file returned an unexpected error: %v", err)
+	}
+	if cfg.MistClient.APIKey != "env-api-key" || cfg.Exporter.Port != 8888 || cfg.MistClient.BaseURL != defaultAPIURL {
+		t.Errorf("unexpected config without a file: %+v %+v", cfg.MistClient, cfg.Exporter)
+	}
+
+	t.Setenv("MIST_EXPORTER_PORT", "not-a-port")
+	if _, err := LoadConfig("", nil); err == nil {
+		t.Error("expected an error for an invalid environment variable value, but got nil")
+	}
+}
+
+func TestOverrideNames(t *testing.T) {
+	envNames := make(map[string]string)
+	for _, k := range keys() {
+		if other, ok := envNames[k.envName()]; ok {
+			t.Errorf("keys %s and %s share environment variable %s", other, k.flagName(), k.envName())
+		}
+		envNames[k.envName()] = k.flagName()
+	}
+
+	for env, flagName := range map[string]string{
+		"MIST_ORG_ID":                        "org_id",
+		"MIST_API_KEY":                       "mist_api.api_key",
+		"MIST_API_BASE_URL":                  "mist_api.base_url",
+		"MIST_API_KEY_FILE":                  "mist_api.api_key_file",
+		"MIST_EXPORTER_PORT":                 "exporter.port",
+		"MIST_COLLECTOR_SITE_FILTER_INCLUDE": "collector.site_filter.include",
+		"MIST_METRIC_RELABEL_CONFIGS":        "metric_relabel_configs",
+	} {
+		if got := envNames[env]; got != flagName {
+			t.Errorf("environment variable %s overrides %q, want %q", env, got, flagName)
+		}
+	}
+}
+
+func TestRedacted(t *testing.T) {
+	configPath := filepath.Join(t.TempDir(), "config.yaml")
+	configYAML := `
+mist_api:
+  api_key: secret-api-key
+exporter:
+  static_labels:
+    env: prod
+  auth:
+    basic_auth_users:
+      prometheus: secret-password
+collector:
+  clients:
+    privacy:
+      labels:
+        mac: hash
+`
+	if err := os.WriteFile(configPath, []byte(configYAML), 0600); err != nil {
+		t.Fatalf("failed to write config file: %v", err)
+	}
+	cfg, err := LoadConfig(configPath, nil)
+	if err != nil {
+		t.Fatalf("LoadConfig() returned an unexpected error: %v", err)
+	}
+
+	source, modTime := cfg.Source()
+	if source != configPath || modTime.IsZero() {
+		t.Errorf("Source() = %q, %v, want %q and the file modification time", source, modTime, configPath)
+	}
+
+	r := cfg.Redacted()
+	if r.MistClient.APIKey != redacted {
+		t.Errorf("Redacted() APIKey = %q, want %q", r.MistClient.APIKey, redacted)
+	}
+	if got := r.Exporter.Auth.BasicAuthUsers["prometheus"]; got != redacted {
+		t.Errorf("Redacted() basic auth password = %q, want %q", got, redacted)
+	}
+	if r.Exporter.Auth.BearerToken != "" || r.Collector.Clients.Privacy.HashKey != "" {
+		t.Errorf("Redacted() unset secrets = %q, %q, want them left empty", r.Exporter.Auth.BearerToken, r.Collector.Clients.Privacy.HashKey)
+	}
+	if r.MistClient.BaseURL != defaultAPIURL || r.Exporter.StaticLabels["env"] != "prod" {
+		t.Errorf("Redacted() changed settings which are not secret: %+v %+v", r.MistClient, r.Exporter)
+	}
+	if source, _ := r.Source(); source != configPath {
+		t.Errorf("Redacted() Source() = %q, want %q", source, configPath)
+	}
+
+	r.Exporter.StaticLabels["env"] = "dev"
+	r.Collector.Clients.Privacy.Labels["mac"] = "keep"
+	if cfg.MistClient.APIKey != "secret-api-key" || cfg.Exporter.Auth.BasicAuthUsers["prometheus"] != "secret-password" {
+		t.Errorf("Redacted() modified the secrets of the original config: %+v %+v", cfg.MistClient, cfg.Exporter.Auth)
+	}
+	if cfg.Exporter.StaticLabels["env"] != "prod" || cfg.Collector.Clients.Privacy.Labels["mac"] != "hash" {
+		t.Error("Redacted() returned a config sharing maps with the original")
+	}
+}
+
+func TestReload(t *testing.T) {
+	running := newDefaultConfig()
+	running.MistClient.APIKey = "running-api-key"
+	running.Exporter.StaticLabels = map[string]string{}
+
+	next := newDefaultConfig()
+	next.source = "next.yaml"
+	next.Exporter.Port = 7777
+	next.Collector.SiteRefreshInterval = 5 * time.Minute
+	next.Collector.SiteFilter = &SiteFilter{Include: []string{"London"}}
+	next.Collector.Clients.TopN.Limit = 10
+
+	applied, restart := running.Reload(next)
+
+	if want := []string{"mist_api.api_key", "exporter.port", "collector.clients.top_n.limit"}; !slices.Equal(restart, want) {
+		t.Errorf("Reload() restart keys = %v, want %v", restart, want)
+	}
+	if applied.Collector.SiteRefreshInterval != 5*time.Minute || !slices.Equal(applied.Collector.SiteFilter.Include, []string{"London"}) {
+		t.Errorf("Reload() did not apply the reloadable keys: %+v", applied.Collector)
+	}
+	if applied.Exporter.Port != defaultExporterPort || applied.MistClient.APIKey != "running-api-key" || applied.Collector.Clients.TopN.Limit != 0 {
+		t.Error("Reload() applied keys which require a restart")
+	}
+	if source, _ := applied.Source(); source != "next.yaml" {
+		t.Errorf("Reload() Source() = %q, want %q", source, "next.yaml")
+	}
+}
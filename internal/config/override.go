@@ -0,0 +1,201 @@
+package config
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"reflect"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// envPrefix is the prefix of the names of all configuration environment variables.
+const envPrefix = "MIST"
+
+// Overrides holds the configuration values set by command line flags, keyed by the
+// dotted path of the configuration key, e.g. "exporter.port".
+type Overrides map[string]string
+
+// key is a configuration key which can be overridden by a flag or environment variable.
+type key struct {
+	path  []string
+	usage string
+}
+
+// flagName returns the name of the command line flag overriding the key.
+func (k key) flagName() string {
+	return strings.Join(k.path, ".")
+}
+
+// envName returns the name of the environment variable overriding the key. It is the
+// upper-cased path of the key prefixed with MIST, where a word repeated at the boundary
+// between two path elements is only included once, e.g. mist_api.api_key is MIST_API_KEY.
+func (k key) envName() string {
+	words := []string{envPrefix}
+	for _, element := range k.path {
+		parts := strings.Split(strings.ToUpper(element), "_")
+		if parts[0] == words[len(words)-1] {
+			parts = parts[1:]
+		}
+		words = append(words, parts...)
+	}
+	return strings.Join(words, "_")
+}
+
+// keys returns every configuration key, in the order they are declared.
+func keys() []key {
+	return appendKeys(nil, nil, reflect.TypeOf(Config{}))
+}
+
+func appendKeys(keys []key, path []string, t reflect.Type) []key {
+	for i := range t.NumField() {
+		field := t.Field(i)
+		name, opts, _ := strings.Cut(field.Tag.Get("yaml"), ",")
+		if opts == "inline" {
+			keys = appendKeys(keys, path, field.Type)
+			continue
+		}
+		if name == "" || name == "-" {
+			continue
+		}
+
+		fieldPath := append(path[:len(path):len(path)], name)
+		ft := field.Type
+		if ft.Kind() == reflect.Pointer && ft.Elem().Kind() == reflect.Struct {
+			keys = appendKeys(keys, fieldPath, ft.Elem())
+			continue
+		}
+		keys = append(keys, key{path: fieldPath, usage: usage(ft)})
+	}
+	return keys
+}
+
+// usage describes the format of the value of a key of the given type.
+func usage(t reflect.Type) string {
+	switch {
+	case t.Kind() == reflect.Slice && t.Elem().Kind() == reflect.String:
+		return "comma-separated list"
+	case t.Kind() == reflect.Slice || t.Kind() == reflect.Map:
+		return "YAML"
+	case t.String() == "time.Duration":
+		return "duration"
+	case t.Kind() == reflect.Float64:
+		return "float"
+	default:
+		return t.Kind().String()
+	}
+}
+
+// RegisterFlags registers a command line flag for every configuration key on the flag set,
+// returning the overrides which are populated as the flags are parsed.
+func RegisterFlags(fs *flag.FlagSet) Overrides {
+	overrides := make(Overrides)
+	for _, k := range keys() {
+		name := k.flagName()
+		fs.Func(name, fmt.Sprintf("Override the %s configuration key (%s, env %s)", name, k.usage, k.envName()), func(value string) error {
+			overrides[name] = value
+			return nil
+		})
+	}
+	return overrides
+}
+
+// applyOverrides applies the configuration values set by environment variables and then
+// by flags, so that flags take precedence over environment variables. Empty environment
+// variables are ignored.
+func (c *Config) applyOverrides(flags Overrides) error {
+	var errs []error
+	for _, k := range keys() {
+		if value := os.Getenv(k.envName()); value != "" {
+			if err := c.set(k.path, value); err != nil {
+				errs = append(errs, fmt.Errorf("environment variable %s: %w", k.envName(), err))
+			}
+		}
+	}
+	for _, k := range keys() {
+		if value, ok := flags[k.flagName()]; ok {
+			if err := c.set(k.path, value); err != nil {
+				errs = append(errs, fmt.Errorf("flag -%s: %w", k.flagName(), err))
+			}
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// set parses the value and assigns it to the key at the given path, allocating any
+// sections along the path which are not set.
+func (c *Config) set(path []string, value string) error {
+	v := c.field(path)
+	if !v.IsValid() {
+		return fmt.Errorf("unknown configuration key %s", strings.Join(path, "."))
+	}
+	return parseValue(v, value)
+}
+
+// field returns the field holding the key at the given path, allocating any sections along
+// the path which are not set. The returned value is invalid if the key is unknown.
+func (c *Config) field(path []string) reflect.Value {
+	v := reflect.ValueOf(c).Elem()
+	for _, element := range path {
+		if v.Kind() == reflect.Pointer {
+			if v.IsNil() {
+				v.Set(reflect.New(v.Type().Elem()))
+			}
+			v = v.Elem()
+		}
+		v = fieldByTag(v, element)
+		if !v.IsValid() {
+			return v
+		}
+	}
+	return v
+}
+
+// fieldByTag returns the field of the struct value with the given yaml name, including
+// the fields of inlined structs.
+func fieldByTag(v reflect.Value, name string) reflect.Value {
+	for i := range v.NumField() {
+		tag, opts, _ := strings.Cut(v.Type().Field(i).Tag.Get("yaml"), ",")
+		if opts == "inline" {
+			if field := fieldByTag(v.Field(i), name); field.IsValid() {
+				return field
+			}
+			continue
+		}
+		if tag == name {
+			return v.Field(i)
+		}
+	}
+	return reflect.Value{}
+}
+
+// parseValue parses the value into v. Strings are assigned as-is and lists of strings
+// may be given comma-separated, while all other values are decoded as YAML.
+func parseValue(v reflect.Value, value string) error {
+	switch {
+	case v.Kind() == reflect.String:
+		v.SetString(value)
+		return nil
+	case v.Kind() == reflect.Slice && v.Type().Elem().Kind() == reflect.String && !strings.HasPrefix(strings.TrimSpace(value), "["):
+		list := []string{}
+		for item := range strings.SplitSeq(value, ",") {
+			if item = strings.TrimSpace(item); item != "" {
+				list = append(list, item)
+			}
+		}
+		v.Set(reflect.ValueOf(list))
+		return nil
+	}
+
+	parsed := reflect.New(v.Type())
+	decoder := yaml.NewDecoder(strings.NewReader(value))
+	decoder.KnownFields(true)
+	if err := decoder.Decode(parsed.Interface()); err != nil && !errors.Is(err, io.EOF) {
+		return fmt.Errorf("invalid value %q: %w", value, err)
+	}
+	v.Set(parsed.Elem())
+	return nil
+}
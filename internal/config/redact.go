@@ -0,0 +1,97 @@
+package config
+
+import (
+	"reflect"
+	"slices"
+	"strings"
+	"time"
+)
+
+// redacted replaces the values of secret settings.
+const redacted = "*****"
+
+// Source returns the path of the file the configuration was loaded from, which is empty if
+// it was built from the defaults and overrides alone, and the file's modification time.
+func (c *Config) Source() (string, time.Time) {
+	return c.source, c.modTime
+}
+
+// Redacted returns a deep copy of the configuration with the values of secret settings
+// replaced, so that it can be shown without exposing credentials. A setting is secret if
+// its field is tagged `secret:"true"`, or, for a struct embedded from another package, if
+// its YAML name is listed in the tag of the embedded field, e.g. `secret:"api_key"`. Secrets
+// which are not set are left empty.
+func (c *Config) Redacted() *Config {
+	return deepCopy(reflect.ValueOf(c)).Interface().(*Config)
+}
+
+// deepCopy returns a deep copy of v, redacting the secret fields of structs.
+func deepCopy(v reflect.Value) reflect.Value {
+	switch v.Kind() {
+	case reflect.Pointer:
+		if v.IsNil() {
+			return v
+		}
+		c := reflect.New(v.Type().Elem())
+		c.Elem().Set(deepCopy(v.Elem()))
+		return c
+	case reflect.Map:
+		if v.IsNil() {
+			return v
+		}
+		c := reflect.MakeMapWithSize(v.Type(), v.Len())
+		for iter := v.MapRange(); iter.Next(); {
+			c.SetMapIndex(iter.Key(), deepCopy(iter.Value()))
+		}
+		return c
+	case reflect.Slice:
+		if v.IsNil() {
+			return v
+		}
+		c := reflect.MakeSlice(v.Type(), v.Len(), v.Len())
+		for i := range v.Len() {
+			c.Index(i).Set(deepCopy(v.Index(i)))
+		}
+		return c
+	case reflect.Struct:
+		c := reflect.New(v.Type()).Elem()
+		c.Set(v)
+		for i := range v.NumField() {
+			field := v.Type().Field(i)
+			if !field.IsExported() {
+				continue
+			}
+			c.Field(i).Set(deepCopy(v.Field(i)))
+			if secret, ok := field.Tag.Lookup("secret"); ok {
+				redact(c.Field(i), secret)
+			}
+		}
+		return c
+	default:
+		return v
+	}
+}
+
+// redact replaces the secret value of a string, or of every entry of a map of strings, in
+// place. For a struct, the fields named by the secret tag are redacted.
+func redact(v reflect.Value, secret string) {
+	switch v.Kind() {
+	case reflect.String:
+		if v.Len() > 0 {
+			v.SetString(redacted)
+		}
+	case reflect.Map:
+		value := reflect.ValueOf(redacted).Convert(v.Type().Elem())
+		for _, key := range v.MapKeys() {
+			v.SetMapIndex(key, value)
+		}
+	case reflect.Struct:
+		names := strings.Split(secret, ",")
+		for i := range v.NumField() {
+			name, _, _ := strings.Cut(v.Type().Field(i).Tag.Get("yaml"), ",")
+			if slices.Contains(names, name) {
+				redact(v.Field(i), "true")
+			}
+		}
+	}
+}
@@ -0,0 +1,75 @@
+package config
+
+import (
+	"reflect"
+	"slices"
+	"strings"
+)
+
+// reloadableKeys are the configuration keys, or sections of keys, which are applied when
+// the configuration is reloaded. All other keys only take effect on restart.
+var reloadableKeys = []string{
+	"collector.site_filter",
+	"collector.site_refresh_interval",
+	"collector.device_name_refresh_interval",
+}
+
+// isReloadable determines if the key at the given path is applied by a reload.
+func isReloadable(path []string) bool {
+	name := strings.Join(path, ".")
+	return slices.ContainsFunc(reloadableKeys, func(key string) bool {
+		return name == key || strings.HasPrefix(name, key+".")
+	})
+}
+
+// Reload returns the configuration in effect once next has been reloaded over the running
+// configuration c, along with the keys which next changes but which require a restart. The
+// reloadable keys and the source are taken from next and all other keys are kept from c.
+// next is modified in place and returned.
+func (c *Config) Reload(next *Config) (*Config, []string) {
+	var restart []string
+	for _, k := range keys() {
+		if isReloadable(k.path) {
+			continue
+		}
+		running := lookup(reflect.ValueOf(c).Elem(), k.path)
+		if equalValues(running, lookup(reflect.ValueOf(next).Elem(), k.path)) {
+			continue
+		}
+		restart = append(restart, k.flagName())
+		next.field(k.path).Set(running)
+	}
+	return next, restart
+}
+
+// lookup returns the value of the key at the given path, or the zero value of the key if
+// any section along the path is not set.
+func lookup(v reflect.Value, path []string) reflect.Value {
+	for i, element := range path {
+		if v.Kind() == reflect.Pointer {
+			if v.IsNil() {
+				return reflect.Zero(zeroKeyType(v.Type().Elem(), path[i:]))
+			}
+			v = v.Elem()
+		}
+		v = fieldByTag(v, element)
+	}
+	return v
+}
+
+// equalValues determines if two values of a key are equal, treating empty and unset maps
+// and lists as equal.
+func equalValues(a, b reflect.Value) bool {
+	switch a.Kind() {
+	case reflect.Map, reflect.Slice:
+		if a.Len() == 0 && b.Len() == 0 {
+			return true
+		}
+	}
+	return reflect.DeepEqual(a.Interface(), b.Interface())
+}
+
+// zeroKeyType returns the type of the key at the given path below the struct type t.
+func zeroKeyType(t reflect.Type, path []string) reflect.Type {
+	return lookup(reflect.New(t).Elem(), path).Type()
+}
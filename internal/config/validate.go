@@ -0,0 +1,264 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+	"maps"
+	"net"
+	"net/url"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// tlsClientAuthTypes are the valid values of the TLS client_auth_type setting.
+var tlsClientAuthTypes = []string{
+	"NoClientCert",
+	"RequestClientCert",
+	"RequireAnyClientCert",
+	"VerifyClientCertIfGiven",
+	"RequireAndVerifyClientCert",
+}
+
+// tlsVersions are the valid values of the TLS min_version setting.
+var tlsVersions = []string{"TLS10", "TLS11", "TLS12", "TLS13"}
+
+// ruleKinds names the include and exclude rule lists of the filters, by index.
+var ruleKinds = []string{"include", "exclude"}
+
+// Validate checks the configuration for semantic errors, returning all of the
+// problems found joined into a single error.
+func (c *Config) Validate() error {
+	var errs []error
+	if c.MistClient == nil {
+		errs = append(errs, errors.New("mist_api: section is required"))
+	} else {
+		errs = append(errs, c.validateMistClient()...)
+	}
+	if c.Exporter == nil {
+		errs = append(errs, errors.New("exporter: section is required"))
+	} else {
+		errs = append(errs, c.Exporter.validate()...)
+	}
+	if c.Collector == nil {
+		errs = append(errs, errors.New("collector: section is required"))
+	} else {
+		errs = append(errs, c.Collector.validate()...)
+	}
+	for i, rc := range c.MetricRelabelConfigs {
+		if rc == nil {
+			continue
+		}
+		if _, err := regexp.Compile(rc.Regex); err != nil {
+			errs = append(errs, fmt.Errorf("metric_relabel_configs[%d].regex: %w", i, err))
+		}
+	}
+
+	return errors.Join(errs...)
+}
+
+func (e *Exporter) validate() []error {
+	var errs []error
+	if e.Port < 1 || e.Port > 65535 {
+		errs = append(errs, fmt.Errorf("exporter.port: %d is not a valid port", e.Port))
+	}
+	if e.AdminAddress != "" {
+		host, port, err := net.SplitHostPort(e.AdminAddress)
+		if n, perr := strconv.Atoi(port); err != nil || perr != nil || n < 1 || n > 65535 {
+			errs = append(errs, fmt.Errorf("exporter.admin_address: %q is not a valid host:port address", e.AdminAddress))
+		} else if n == e.Port && (host == e.Address || host == "" || e.Address == "" || host == "0.0.0.0" || e.Address == "0.0.0.0") {
+			errs = append(errs, fmt.Errorf("exporter.admin_address: %q conflicts with the exporter address and port", e.AdminAddress))
+		}
+	}
+
+	if e.TLS != nil {
+		if e.TLS.CertFile == "" || e.TLS.KeyFile == "" {
+			errs = append(errs, errors.New("exporter.tls: both cert_file and key_file must be set"))
+		}
+		if e.TLS.ClientAuthType != "" && !slices.Contains(tlsClientAuthTypes, e.TLS.ClientAuthType) {
+			errs = append(errs, fmt.Errorf("exporter.tls.client_auth_type: %q must be one of %s", e.TLS.ClientAuthType, strings.Join(tlsClientAuthTypes, ", ")))
+		}
+		if e.TLS.MinVersion != "" && !slices.Contains(tlsVersions, e.TLS.MinVersion) {
+			errs = append(errs, fmt.Errorf("exporter.tls.min_version: %q must be one of %s", e.TLS.MinVersion, strings.Join(tlsVersions, ", ")))
+		}
+	}
+
+	if e.Readiness != nil {
+		if p := e.Readiness.MinStreamsPercent; p < 0 || p > 100 {
+			errs = append(errs, fmt.Errorf("exporter.readiness.min_streams_percent: %v must be between 0 and 100", p))
+		}
+		errs = append(errs, validatePositive("exporter.readiness.max_api_success_age", e.Readiness.MaxAPISuccessAge)...)
+	}
+
+	if e.Auth != nil {
+		if len(e.Auth.BasicAuthUsers) == 0 && e.Auth.BearerToken == "" && e.Auth.BearerTokenFile == "" {
+			errs = append(errs, errors.New("exporter.auth: at least one of basic_auth_users, bearer_token or bearer_token_file must be set"))
+		}
+		if e.Auth.BearerToken != "" && e.Auth.BearerTokenFile != "" {
+			errs = append(errs, errors.New("exporter.auth: only one of bearer_token or bearer_token_file may be set"))
+		}
+		for _, endpoint := range e.Auth.Endpoints {
+			if !strings.HasPrefix(endpoint, "/") {
+				errs = append(errs, fmt.Errorf("exporter.auth.endpoints: %q must start with a slash", endpoint))
+			}
+		}
+	}
+
+	return errs
+}
+
+func (c *Config) validateMistClient() []error {
+	var errs []error
+
+	u, err := url.Parse(c.MistClient.BaseURL)
+	switch {
+	case err != nil:
+		errs = append(errs, fmt.Errorf("mist_api.base_url: %w", err))
+	case u.Scheme != "http" && u.Scheme != "https":
+		errs = append(errs, fmt.Errorf("mist_api.base_url: %q must use the http or https scheme", c.MistClient.BaseURL))
+	case u.Host == "":
+		errs = append(errs, fmt.Errorf("mist_api.base_url: %q must include a host", c.MistClient.BaseURL))
+	}
+	switch {
+	case c.MistClient.APIKey == "" && c.MistClient.APIKeyFile == "":
+		errs = append(errs, errors.New("mist_api.api_key: one of api_key or api_key_file must be set"))
+	case c.MistClient.APIKey != "" && c.MistClient.APIKeyFile != "":
+		errs = append(errs, errors.New("mist_api.api_key: only one of api_key or api_key_file may be set"))
+	case c.MistClient.APIKeyFile != "":
+		errs = append(errs, validatePositive("mist_api.api_key_file_refresh_interval", c.MistClient.APIKeyFileRefreshInterval)...)
+	}
+	if c.MistClient.Timeout < 0 {
+		errs = append(errs, fmt.Errorf("mist_api.timeout: %v must not be negative", c.MistClient.Timeout))
+	}
+
+	return errs
+}
+
+func (c *Collector) validate() []error {
+	var errs []error
+	for _, d := range []struct {
+		name  string
+		value time.Duration
+	}{
+		{"collector.collect_timeout", c.CollectTimeout},
+		{"collector.device_name_refresh_interval", c.DeviceNameRefreshInterval},
+		{"collector.site_refresh_interval", c.SiteRefreshInterval},
+		{"collector.site_variable_refresh_interval", c.SiteVariableRefreshInterval},
+	} {
+		errs = append(errs, validatePositive(d.name, d.value)...)
+	}
+
+	if c.SiteFilter != nil {
+		errs = append(errs, validateGlobs("collector.site_filter.include", c.SiteFilter.Include)...)
+		errs = append(errs, validateGlobs("collector.site_filter.exclude", c.SiteFilter.Exclude)...)
+		for i, m := range c.SiteFilter.IncludeRules {
+			errs = append(errs, m.validate(fmt.Sprintf("collector.site_filter.include_rules[%d]", i))...)
+		}
+		for i, m := range c.SiteFilter.ExcludeRules {
+			errs = append(errs, m.validate(fmt.Sprintf("collector.site_filter.exclude_rules[%d]", i))...)
+		}
+	}
+	if c.DeviceFilter != nil {
+		for kind, rules := range [][]DeviceMatch{c.DeviceFilter.Include, c.DeviceFilter.Exclude} {
+			for i, m := range rules {
+				field := fmt.Sprintf("collector.device_filter.%s[%d]", ruleKinds[kind], i)
+				errs = append(errs, validateGlobs(field+".name", m.Name)...)
+				errs = append(errs, validateGlobs(field+".mac", m.MAC)...)
+				errs = append(errs, validateGlobs(field+".model", m.Model)...)
+				errs = append(errs, validateGlobs(field+".type", m.Type)...)
+			}
+		}
+	}
+	if c.ClientFilter != nil {
+		for kind, rules := range [][]ClientMatch{c.ClientFilter.Include, c.ClientFilter.Exclude} {
+			for i, m := range rules {
+				field := fmt.Sprintf("collector.client_filter.%s[%d]", ruleKinds[kind], i)
+				errs = append(errs, validateGlobs(field+".ssid", m.SSID)...)
+				errs = append(errs, validateGlobs(field+".band", m.Band)...)
+				errs = append(errs, validateGlobs(field+".manufacturer", m.Manufacturer)...)
+				errs = append(errs, validateGlobs(field+".device_name", m.DeviceName)...)
+			}
+		}
+	}
+
+	if c.Clients != nil {
+		errs = append(errs, validatePositive("collector.clients.ttl", c.Clients.TTL)...)
+		if c.Clients.BreakdownTopN < 0 {
+			errs = append(errs, fmt.Errorf("collector.clients.breakdown_top_n: %d must not be negative", c.Clients.BreakdownTopN))
+		}
+		if c.Clients.TopN != nil {
+			if c.Clients.TopN.Limit < 0 {
+				errs = append(errs, fmt.Errorf("collector.clients.top_n.limit: %d must not be negative", c.Clients.TopN.Limit))
+			} else if c.Clients.TopN.Limit > 0 {
+				errs = append(errs, validatePositive("collector.clients.top_n.interval", c.Clients.TopN.Interval)...)
+			}
+		}
+		if h := c.Clients.Histograms; h != nil && h.Enabled {
+			errs = append(errs, validatePositive("collector.clients.histograms.interval", h.Interval)...)
+			if h.NativeHistograms && h.NativeHistogramBucketFactor <= 1 {
+				errs = append(errs, fmt.Errorf("collector.clients.histograms.native_histogram_bucket_factor: %v must be greater than 1", h.NativeHistogramBucketFactor))
+			}
+		}
+	}
+
+	if c.SeriesLimits != nil {
+		switch c.SeriesLimits.Policy {
+		case "", "reject", "evict":
+		default:
+			errs = append(errs, fmt.Errorf("collector.series_limits.policy: %q must be one of \"reject\" or \"evict\"", c.SeriesLimits.Policy))
+		}
+		if c.SeriesLimits.Default < 0 {
+			errs = append(errs, fmt.Errorf("collector.series_limits.default: %d must not be negative", c.SeriesLimits.Default))
+		}
+		for _, family := range slices.Sorted(maps.Keys(c.SeriesLimits.Families)) {
+			if limit := c.SeriesLimits.Families[family]; limit < 0 {
+				errs = append(errs, fmt.Errorf("collector.series_limits.families.%s: %d must not be negative", family, limit))
+			}
+		}
+	}
+	if c.StreamDebug != nil && c.StreamDebug.Messages < 0 {
+		errs = append(errs, fmt.Errorf("collector.stream_debug.messages: %d must not be negative", c.StreamDebug.Messages))
+	}
+
+	return errs
+}
+
+func (m SiteMatch) validate(field string) []error {
+	var errs []error
+	switch m.Match {
+	case "", "all", "any":
+	default:
+		errs = append(errs, fmt.Errorf("%s.match: %q must be one of \"all\" or \"any\"", field, m.Match))
+	}
+	errs = append(errs, validateGlobs(field+".id", m.ID)...)
+	errs = append(errs, validateGlobs(field+".name", m.Name)...)
+	errs = append(errs, validateGlobs(field+".country_code", m.CountryCode)...)
+	errs = append(errs, validateGlobs(field+".timezone", m.Timezone)...)
+	errs = append(errs, validateGlobs(field+".site_group", m.SiteGroup)...)
+	for _, expr := range m.NameRegex {
+		if _, err := regexp.Compile(expr); err != nil {
+			errs = append(errs, fmt.Errorf("%s.name_regex: %w", field, err))
+		}
+	}
+	return errs
+}
+
+func validatePositive(field string, d time.Duration) []error {
+	if d <= 0 {
+		return []error{fmt.Errorf("%s: %v must be positive", field, d)}
+	}
+	return nil
+}
+
+func validateGlobs(field string, patterns []string) []error {
+	var errs []error
+	for _, p := range patterns {
+		if _, err := filepath.Match(p, ""); err != nil {
+			errs = append(errs, fmt.Errorf("%s: invalid glob pattern %q: %w", field, p, err))
+		}
+	}
+	return errs
+}
@@ -0,0 +1,106 @@
+// Package errorlog records the most recent errors logged by the exporter, so that they
+// can be shown on the status page.
+package errorlog
+
+import (
+	"context"
+	"log/slog"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Entry is an error logged by the exporter.
+type Entry struct {
+	Time    time.Time
+	Message string
+	// Attrs holds the attributes of the record formatted as space separated key=value pairs.
+	Attrs string
+}
+
+// Handler is a slog.Handler which records the most recent records logged at error level
+// or above, passing every record on to the next handler.
+type Handler struct {
+	next   slog.Handler
+	attrs  []slog.Attr
+	prefix string
+	log    *log
+}
+
+// log holds the entries shared by a handler and the handlers derived from it.
+type log struct {
+	mu      sync.Mutex
+	size    int
+	entries []Entry
+}
+
+// NewHandler creates a new Handler recording up to size errors.
+func NewHandler(next slog.Handler, size int) *Handler {
+	return &Handler{
+		next: next,
+		log:  &log{size: size},
+	}
+}
+
+// Enabled implements slog.Handler.
+func (h *Handler) Enabled(ctx context.Context, level slog.Level) bool {
+	return h.next.Enabled(ctx, level)
+}
+
+// Handle implements slog.Handler.
+func (h *Handler) Handle(ctx context.Context, r slog.Record) error {
+	if r.Level >= slog.LevelError && h.log.size > 0 {
+		var attrs []string
+		for _, a := range h.attrs {
+			attrs = append(attrs, a.String())
+		}
+		r.Attrs(func(a slog.Attr) bool {
+			a.Key = h.prefix + a.Key
+			attrs = append(attrs, a.String())
+			return true
+		})
+		h.log.add(Entry{Time: r.Time, Message: r.Message, Attrs: strings.Join(attrs, " ")})
+	}
+	return h.next.Handle(ctx, r)
+}
+
+// WithAttrs implements slog.Handler.
+func (h *Handler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	c := *h
+	c.next = h.next.WithAttrs(attrs)
+	c.attrs = slices.Clone(h.attrs)
+	for _, a := range attrs {
+		a.Key = h.prefix + a.Key
+		c.attrs = append(c.attrs, a)
+	}
+	return &c
+}
+
+// WithGroup implements slog.Handler.
+func (h *Handler) WithGroup(name string) slog.Handler {
+	c := *h
+	c.next = h.next.WithGroup(name)
+	c.prefix = h.prefix + name + "."
+	return &c
+}
+
+// Entries returns the recorded errors, most recent first.
+func (h *Handler) Entries() []Entry {
+	h.log.mu.Lock()
+	defer h.log.mu.Unlock()
+
+	entries := slices.Clone(h.log.entries)
+	slices.Reverse(entries)
+	return entries
+}
+
+func (l *log) add(e Entry) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if len(l.entries) == l.size {
+		l.entries = slices.Delete(l.entries, 0, 1)
+	}
+	l.entries = append(l.entries, e)
+}
@@ -0,0 +1,38 @@
+package errorlog
+
+import (
+	"bytes"
+	"fmt"
+	"log/slog"
+	"strings"
+	"testing"
+)
+
+func TestHandler(t *testing.T) {
+	var out bytes.Buffer
+	h := NewHandler(slog.NewTextHandler(&out, nil), 2)
+	logger := slog.New(h).With(slog.String("component", "metrics"))
+
+	logger.Info("informational message")
+	for i := range 3 {
+		logger.WithGroup("stream").Error(fmt.Sprintf("error %d", i), "site", "Main")
+	}
+
+	entries := h.Entries()
+	if len(entries) != 2 {
+		t.Fatalf("Entries() returned %d entries, want 2: %+v", len(entries), entries)
+	}
+	if entries[0].Message != "error 2" || entries[1].Message != "error 1" {
+		t.Errorf("Entries() = %+v, want the two most recent errors, most recent first", entries)
+	}
+	if want := "component=metrics stream.site=Main"; entries[0].Attrs != want {
+		t.Errorf("Entries()[0].Attrs = %q, want %q", entries[0].Attrs, want)
+	}
+	if entries[0].Time.IsZero() {
+		t.Error("Entries()[0].Time is zero")
+	}
+
+	if got := strings.Count(out.String(), "\n"); got != 4 {
+		t.Errorf("next handler received %d records, want 4:\n%s", got, out.String())
+	}
+}
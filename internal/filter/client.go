@@ -0,0 +1,90 @@
+package filter
+
+import (
+	"fmt"
+
+	"github.com/gregwight/mistclient"
+	"github.com/gregwight/mistexporter/internal/config"
+)
+
+// ClientFilter holds the rules for including and excluding wireless clients.
+type ClientFilter struct {
+	include []config.ClientMatch
+	exclude []config.ClientMatch
+}
+
+// NewClientFilter creates a new client filter from the configuration.
+func NewClientFilter(cfg *config.ClientFilter) (*ClientFilter, error) {
+	if cfg == nil {
+		return &ClientFilter{}, nil
+	}
+
+	// Validate patterns
+	for i, rule := range cfg.Include {
+		if err := validateClientMatch(rule); err != nil {
+			return nil, fmt.Errorf("invalid include rule %d: %w", i, err)
+		}
+	}
+	for i, rule := range cfg.Exclude {
+		if err := validateClientMatch(rule); err != nil {
+			return nil, fmt.Errorf("invalid exclude rule %d: %w", i, err)
+		}
+	}
+
+	return &ClientFilter{
+		include: cfg.Include,
+		exclude: cfg.Exclude,
+	}, nil
+}
+
+// IsFiltered determines if a client should be filtered out based on the rules. The
+// name of the device the client is connected to is matched against device_name rules.
+// Patterns are validated when the filter is created, so matching cannot fail.
+func (f *ClientFilter) IsFiltered(deviceName string, stat mistclient.StreamedClientStat) bool {
+	// Exclusion takes precedence.
+	for _, rule := range f.exclude {
+		if matchesClient(rule, deviceName, stat) {
+			return true
+		}
+	}
+
+	// If the client isn't excluded and there are
+	// no explicit includes we can shortcut
+	if len(f.include) == 0 {
+		return false
+	}
+
+	for _, rule := range f.include {
+		if matchesClient(rule, deviceName, stat) {
+			return false
+		}
+	}
+
+	return true
+}
+
+func validateClientMatch(rule config.ClientMatch) error {
+	return validateAttributes(clientAttributes(rule, "", mistclient.StreamedClientStat{}))
+}
+
+// matchesClient checks if a client matches every attribute configured in the rule.
+func matchesClient(rule config.ClientMatch, deviceName string, stat mistclient.StreamedClientStat) bool {
+	if !matchesAttributes(clientAttributes(rule, deviceName, stat)) {
+		return false
+	}
+
+	if rule.Guest != nil && *rule.Guest != stat.IsGuest {
+		return false
+	}
+
+	return true
+}
+
+func clientAttributes(rule config.ClientMatch, deviceName string, stat mistclient.StreamedClientStat) []attribute {
+	return []attribute{
+		{"ssid", stat.SSID, rule.SSID},
+		{"band", stat.Band.String(), rule.Band},
+		{"manufacturer", stat.Manufacture, rule.Manufacturer},
+		{"device_name", deviceName, rule.DeviceName},
+	}
+}
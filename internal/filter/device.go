@@ -0,0 +1,106 @@
+package filter
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/gregwight/mistclient"
+	"github.com/gregwight/mistexporter/internal/config"
+)
+
+// DeviceFilter holds the rules for including and excluding devices.
+type DeviceFilter struct {
+	include     []config.DeviceMatch
+	exclude     []config.DeviceMatch
+	dropClients bool
+}
+
+// NewDeviceFilter creates a new device filter from the configuration.
+func NewDeviceFilter(cfg *config.DeviceFilter) (*DeviceFilter, error) {
+	if cfg == nil {
+		return &DeviceFilter{}, nil
+	}
+
+	// Validate patterns
+	include := make([]config.DeviceMatch, 0, len(cfg.Include))
+	for i, rule := range cfg.Include {
+		if err := validateAttributes(deviceAttributes(rule, mistclient.Device{})); err != nil {
+			return nil, fmt.Errorf("invalid include rule %d: %w", i, err)
+		}
+		include = append(include, normalizeDeviceMatch(rule))
+	}
+	exclude := make([]config.DeviceMatch, 0, len(cfg.Exclude))
+	for i, rule := range cfg.Exclude {
+		if err := validateAttributes(deviceAttributes(rule, mistclient.Device{})); err != nil {
+			return nil, fmt.Errorf("invalid exclude rule %d: %w", i, err)
+		}
+		exclude = append(exclude, normalizeDeviceMatch(rule))
+	}
+
+	return &DeviceFilter{
+		include:     include,
+		exclude:     exclude,
+		dropClients: cfg.DropClients,
+	}, nil
+}
+
+// IsFiltered determines if a device should be filtered out based on the rules.
+// Patterns are validated when the filter is created, so matching cannot fail.
+func (f *DeviceFilter) IsFiltered(device mistclient.Device) bool {
+	// Exclusion takes precedence.
+	for _, rule := range f.exclude {
+		if matchesAttributes(deviceAttributes(rule, device)) {
+			return true
+		}
+	}
+
+	// If the device isn't excluded and there are
+	// no explicit includes we can shortcut
+	if len(f.include) == 0 {
+		return false
+	}
+
+	for _, rule := range f.include {
+		if matchesAttributes(deviceAttributes(rule, device)) {
+			return false
+		}
+	}
+
+	return true
+}
+
+// DropClients reports whether the clients of filtered devices should also be filtered.
+func (f *DeviceFilter) DropClients() bool {
+	return f.dropClients
+}
+
+// RequiresInventory reports whether any rule matches on device attributes, such as
+// the model or type, which are only available from the site device inventory.
+func (f *DeviceFilter) RequiresInventory() bool {
+	for _, rule := range append(f.include[:len(f.include):len(f.include)], f.exclude...) {
+		if len(rule.Model) > 0 || len(rule.Type) > 0 {
+			return true
+		}
+	}
+	return false
+}
+
+func deviceAttributes(rule config.DeviceMatch, device mistclient.Device) []attribute {
+	return []attribute{
+		{"name", device.Name, rule.Name},
+		{"mac", device.Mac, rule.MAC},
+		{"model", device.Model, rule.Model},
+		{"type", device.Type.String(), rule.Type},
+	}
+}
+
+// normalizeDeviceMatch converts MAC patterns to the lowercase, colon-free
+// format used by the Mist API.
+func normalizeDeviceMatch(rule config.DeviceMatch) config.DeviceMatch {
+	macs := make([]string, 0, len(rule.MAC))
+	for _, mac := range rule.MAC {
+		macs = append(macs, strings.ToLower(strings.ReplaceAll(mac, ":", "")))
+	}
+	rule.MAC = macs
+	return rule
+}
@@ -3,15 +3,21 @@ package filter
 import (
 	"fmt"
 	"path/filepath"
+	"sync"
 
 	"github.com/gregwight/mistclient"
 	"github.com/gregwight/mistexporter/internal/config"
 )
 
-// Filter holds the patterns for including and excluding sites.
+// Filter holds the patterns and rules for including and excluding sites.
 type Filter struct {
-	include []string
-	exclude []string
+	include      []string
+	exclude      []string
+	includeRules []siteRule
+	excludeRules []siteRule
+
+	mu            sync.RWMutex
+	siteGroupName func(id string) string
 }
 
 // New creates a new site filter from the configuration.
@@ -24,49 +30,120 @@ func New(cfg *config.SiteFilter) (*Filter, error) {
 	}
 
 	// Validate patterns
-	for _, p := range cfg.Include {
-		if _, err := filepath.Match(p, ""); err != nil {
-			return nil, fmt.Errorf("invalid include glob pattern %q: %w", p, err)
-		}
+	if err := validatePatterns("include", cfg.Include); err != nil {
+		return nil, err
 	}
-	for _, p := range cfg.Exclude {
-		if _, err := filepath.Match(p, ""); err != nil {
-			return nil, fmt.Errorf("invalid exclude glob pattern %q: %w", p, err)
-		}
+	if err := validatePatterns("exclude", cfg.Exclude); err != nil {
+		return nil, err
 	}
 
-	return &Filter{
+	f := &Filter{
 		include: cfg.Include,
 		exclude: cfg.Exclude,
-	}, nil
+	}
+	for i, match := range cfg.IncludeRules {
+		rule, err := newSiteRule(match)
+		if err != nil {
+			return nil, fmt.Errorf("invalid include rule %d: %w", i, err)
+		}
+		f.includeRules = append(f.includeRules, rule)
+	}
+	for i, match := range cfg.ExcludeRules {
+		rule, err := newSiteRule(match)
+		if err != nil {
+			return nil, fmt.Errorf("invalid exclude rule %d: %w", i, err)
+		}
+		f.excludeRules = append(f.excludeRules, rule)
+	}
+
+	return f, nil
+}
+
+// RequiresSiteGroups reports whether any rule matches on site group membership.
+func (f *Filter) RequiresSiteGroups() bool {
+	for _, rule := range append(f.includeRules[:len(f.includeRules):len(f.includeRules)], f.excludeRules...) {
+		if len(rule.match.SiteGroup) > 0 {
+			return true
+		}
+	}
+	return false
+}
+
+// SetSiteGroupResolver sets the function used to resolve site group IDs to names, allowing
+// site group rules to match on names. Without a resolver only site group IDs are matched.
+func (f *Filter) SetSiteGroupResolver(fn func(id string) string) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	f.siteGroupName = fn
 }
 
 // IsFiltered determines if a site should be filtered out based on the rules.
 func (f *Filter) IsFiltered(site mistclient.Site) (bool, error) {
+	groups := f.siteGroups(site)
+
 	// Exclusion takes precedence.
-	if excluded, err := f.matches(site.Name, f.exclude); err != nil {
+	if excluded, err := matches(site.Name, f.exclude); err != nil {
 		return false, fmt.Errorf("unable to match site name %q against exclude patterns: %w", site.Name, err)
 	} else if excluded {
 		return true, nil
 	}
+	for _, rule := range f.excludeRules {
+		if rule.matches(site, groups) {
+			return true, nil
+		}
+	}
 
 	// If the site isn't excluded and there are
 	// no explicit includes we can shortcut
-	if len(f.include) == 0 {
+	if len(f.include) == 0 && len(f.includeRules) == 0 {
 		return false, nil
 	}
 
-	if included, err := f.matches(site.Name, f.include); err != nil {
+	if included, err := matches(site.Name, f.include); err != nil {
 		return false, fmt.Errorf("unable to match site name %q against include patterns: %w", site.Name, err)
-	} else if !included {
-		return true, nil
+	} else if included {
+		return false, nil
+	}
+	for _, rule := range f.includeRules {
+		if rule.matches(site, groups) {
+			return false, nil
+		}
 	}
 
-	return false, nil
+	return true, nil
+}
+
+// siteGroups returns the IDs and, where known, names of the site groups the site belongs to.
+func (f *Filter) siteGroups(site mistclient.Site) []string {
+	f.mu.RLock()
+	resolve := f.siteGroupName
+	f.mu.RUnlock()
+
+	groups := make([]string, 0, 2*len(site.SiteGroupIDs))
+	for _, id := range site.SiteGroupIDs {
+		groups = append(groups, id)
+		if resolve != nil {
+			if name := resolve(id); name != "" {
+				groups = append(groups, name)
+			}
+		}
+	}
+	return groups
+}
+
+// validatePatterns checks that each of the patterns is a valid glob pattern.
+func validatePatterns(kind string, patterns []string) error {
+	for _, p := range patterns {
+		if _, err := filepath.Match(p, ""); err != nil {
+			return fmt.Errorf("invalid %s glob pattern %q: %w", kind, p, err)
+		}
+	}
+	return nil
 }
 
 // matches checks if a name matches any of the glob patterns.
-func (f *Filter) matches(name string, patterns []string) (bool, error) {
+func matches(name string, patterns []string) (bool, error) {
 	for _, pattern := range patterns {
 		if matched, err := filepath.Match(pattern, name); err != nil {
 			return false, err
@@ -77,3 +154,34 @@ func (f *Filter) matches(name string, patterns []string) (bool, error) {
 
 	return false, nil
 }
+
+// attribute pairs the value of a named attribute with the glob patterns of a rule.
+type attribute struct {
+	name     string
+	value    string
+	patterns []string
+}
+
+// validateAttributes checks the patterns of each attribute are valid glob patterns.
+func validateAttributes(attrs []attribute) error {
+	for _, attr := range attrs {
+		if err := validatePatterns(attr.name, attr.patterns); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// matchesAttributes checks if every attribute with patterns matches one of them.
+// Attributes without patterns are not considered. Patterns must have been validated.
+func matchesAttributes(attrs []attribute) bool {
+	for _, attr := range attrs {
+		if len(attr.patterns) == 0 {
+			continue
+		}
+		if matched, err := matches(attr.value, attr.patterns); err != nil || !matched {
+			return false
+		}
+	}
+	return true
+}
@@ -139,3 +139,348 @@ func TestApplySiteFilter(t *testing.T) {
 		})
 	}
 }
+
+func TestApplySiteFilterRules(t *testing.T) {
+	sites := map[string]mistclient.Site{
+		"LON": {ID: "site-lon", Name: "UK/London", CountryCode: "GB", Timezone: "Europe/London", SiteGroupIDs: []string{"g-emea"}},
+		"MAN": {ID: "site-man", Name: "UK/Manchester", CountryCode: "GB", Timezone: "Europe/London", SiteGroupIDs: []string{"g-emea", "g-lab"}},
+		"NYC": {ID: "site-nyc", Name: "US/New York", CountryCode: "US", Timezone: "America/New_York"},
+	}
+	groupNames := map[string]string{"g-emea": "EMEA", "g-lab": "Lab"}
+
+	testCases := []struct {
+		name           string
+		filterCfg      *config.SiteFilter
+		siteKey        string
+		expectFiltered bool
+		expectErr      bool
+	}{
+		{
+			name: "include by country code",
+			filterCfg: &config.SiteFilter{
+				IncludeRules: []config.SiteMatch{{CountryCode: []string{"GB"}}},
+			},
+			siteKey:        "NYC",
+			expectFiltered: true,
+		},
+		{
+			name: "include by name regex spanning a slash",
+			filterCfg: &config.SiteFilter{
+				IncludeRules: []config.SiteMatch{{NameRegex: []string{"UK/.*"}}},
+			},
+			siteKey:        "LON",
+			expectFiltered: false,
+		},
+		{
+			name: "name regex is anchored",
+			filterCfg: &config.SiteFilter{
+				IncludeRules: []config.SiteMatch{{NameRegex: []string{"London"}}},
+			},
+			siteKey:        "LON",
+			expectFiltered: true,
+		},
+		{
+			name: "exclude by site group name",
+			filterCfg: &config.SiteFilter{
+				ExcludeRules: []config.SiteMatch{{SiteGroup: []string{"Lab"}}},
+			},
+			siteKey:        "MAN",
+			expectFiltered: true,
+		},
+		{
+			name: "include by site group id",
+			filterCfg: &config.SiteFilter{
+				IncludeRules: []config.SiteMatch{{SiteGroup: []string{"g-emea"}}},
+			},
+			siteKey:        "LON",
+			expectFiltered: false,
+		},
+		{
+			name: "all attributes must match by default",
+			filterCfg: &config.SiteFilter{
+				IncludeRules: []config.SiteMatch{{CountryCode: []string{"GB"}, ID: []string{"site-nyc"}}},
+			},
+			siteKey:        "NYC",
+			expectFiltered: true,
+		},
+		{
+			name: "any attribute matches",
+			filterCfg: &config.SiteFilter{
+				IncludeRules: []config.SiteMatch{{Match: "any", CountryCode: []string{"GB"}, ID: []string{"site-nyc"}}},
+			},
+			siteKey:        "NYC",
+			expectFiltered: false,
+		},
+		{
+			name: "timezone glob",
+			filterCfg: &config.SiteFilter{
+				ExcludeRules: []config.SiteMatch{{Timezone: []string{"America/*"}}},
+			},
+			siteKey:        "NYC",
+			expectFiltered: true,
+		},
+		{
+			name: "glob include combined with rules",
+			filterCfg: &config.SiteFilter{
+				Include:      []string{"US/*"},
+				IncludeRules: []config.SiteMatch{{CountryCode: []string{"FR"}}},
+			},
+			siteKey:        "NYC",
+			expectFiltered: false,
+		},
+		{
+			name: "invalid match",
+			filterCfg: &config.SiteFilter{
+				IncludeRules: []config.SiteMatch{{Match: "some", ID: []string{"site-nyc"}}},
+			},
+			expectErr: true,
+		},
+		{
+			name: "invalid name regex",
+			filterCfg: &config.SiteFilter{
+				ExcludeRules: []config.SiteMatch{{NameRegex: []string{"("}}},
+			},
+			expectErr: true,
+		},
+		{
+			name: "empty rule",
+			filterCfg: &config.SiteFilter{
+				ExcludeRules: []config.SiteMatch{{Match: "any"}},
+			},
+			expectErr: true,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			f, err := New(tc.filterCfg)
+
+			if tc.expectErr {
+				if err == nil {
+					t.Fatal("New() expected an error, but got nil")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("New() returned an unexpected error: %v", err)
+			}
+			f.SetSiteGroupResolver(func(id string) string { return groupNames[id] })
+
+			site := sites[tc.siteKey]
+			isFiltered, err := f.IsFiltered(site)
+			if err != nil {
+				t.Fatalf("IsFiltered() returned an unexpected error: %v", err)
+			}
+
+			if isFiltered != tc.expectFiltered {
+				t.Errorf("IsFiltered() for site %q returned %v, want %v", site.Name, isFiltered, tc.expectFiltered)
+			}
+		})
+	}
+}
+
+func TestApplyClientFilter(t *testing.T) {
+	guest := true
+	clients := map[string]struct {
+		deviceName string
+		stat       mistclient.StreamedClientStat
+	}{
+		"corp-laptop": {"AP-Floor1", mistclient.StreamedClientStat{Client: mistclient.Client{SSID: "Corp", Band: mistclient.Band5, Manufacture: "Apple"}}},
+		"corp-phone":  {"AP-Floor2", mistclient.StreamedClientStat{Client: mistclient.Client{SSID: "Corp", Band: mistclient.Band24, Manufacture: "Samsung"}}},
+		"guest-phone": {"AP-Lobby", mistclient.StreamedClientStat{Client: mistclient.Client{SSID: "Guest", Band: mistclient.Band24, Manufacture: "Apple", IsGuest: true}}},
+		"iot-sensor":  {"AP-Lab", mistclient.StreamedClientStat{Client: mistclient.Client{SSID: "IoT", Band: mistclient.Band24, Manufacture: "Espressif"}}},
+	}
+
+	testCases := []struct {
+		name           string
+		filterCfg      *config.ClientFilter
+		clientKey      string
+		expectFiltered bool
+		expectErr      bool
+	}{
+		{
+			name:           "no filter, should not be filtered",
+			filterCfg:      nil,
+			clientKey:      "corp-laptop",
+			expectFiltered: false,
+		},
+		{
+			name: "exclude guests",
+			filterCfg: &config.ClientFilter{
+				Exclude: []config.ClientMatch{{Guest: &guest}},
+			},
+			clientKey:      "guest-phone",
+			expectFiltered: true,
+		},
+		{
+			name: "exclude guests, non-guest kept",
+			filterCfg: &config.ClientFilter{
+				Exclude: []config.ClientMatch{{Guest: &guest}},
+			},
+			clientKey:      "corp-phone",
+			expectFiltered: false,
+		},
+		{
+			name: "include ssid glob, match",
+			filterCfg: &config.ClientFilter{
+				Include: []config.ClientMatch{{SSID: []string{"Co*"}}},
+			},
+			clientKey:      "corp-laptop",
+			expectFiltered: false,
+		},
+		{
+			name: "include ssid glob, no match",
+			filterCfg: &config.ClientFilter{
+				Include: []config.ClientMatch{{SSID: []string{"Co*"}}},
+			},
+			clientKey:      "iot-sensor",
+			expectFiltered: true,
+		},
+		{
+			name: "rule attributes must all match",
+			filterCfg: &config.ClientFilter{
+				Include: []config.ClientMatch{{SSID: []string{"Corp"}, Band: []string{"5"}}},
+			},
+			clientKey:      "corp-phone",
+			expectFiltered: true,
+		},
+		{
+			name: "any include rule matches",
+			filterCfg: &config.ClientFilter{
+				Include: []config.ClientMatch{
+					{SSID: []string{"Corp"}, Band: []string{"5"}},
+					{Manufacturer: []string{"Sams*"}},
+				},
+			},
+			clientKey:      "corp-phone",
+			expectFiltered: false,
+		},
+		{
+			name: "exclude by device name wins over include",
+			filterCfg: &config.ClientFilter{
+				Include: []config.ClientMatch{{SSID: []string{"IoT"}}},
+				Exclude: []config.ClientMatch{{DeviceName: []string{"AP-Lab"}}},
+			},
+			clientKey:      "iot-sensor",
+			expectFiltered: true,
+		},
+		{
+			name: "invalid pattern",
+			filterCfg: &config.ClientFilter{
+				Exclude: []config.ClientMatch{{Manufacturer: []string{"["}}},
+			},
+			expectErr: true,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			f, err := NewClientFilter(tc.filterCfg)
+
+			if tc.expectErr {
+				if err == nil {
+					t.Fatal("NewClientFilter() expected an error, but got nil")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("NewClientFilter() returned an unexpected error: %v", err)
+			}
+
+			client := clients[tc.clientKey]
+			if isFiltered := f.IsFiltered(client.deviceName, client.stat); isFiltered != tc.expectFiltered {
+				t.Errorf("IsFiltered() for client %q returned %v, want %v", tc.clientKey, isFiltered, tc.expectFiltered)
+			}
+		})
+	}
+}
+
+func TestApplyDeviceFilter(t *testing.T) {
+	devices := map[string]mistclient.Device{
+		"ap-floor1":  {Name: "AP-Floor1", Mac: "5c5b35000001", Model: "AP45", Type: mistclient.AP},
+		"ap-lab":     {Name: "AP-Lab", Mac: "5c5b35000002", Model: "AP12", Type: mistclient.AP},
+		"switch-lab": {Name: "SW-Lab", Mac: "d0dd49000001", Model: "EX2300", Type: mistclient.Switch},
+	}
+
+	testCases := []struct {
+		name           string
+		filterCfg      *config.DeviceFilter
+		deviceKey      string
+		expectFiltered bool
+		expectErr      bool
+	}{
+		{
+			name:           "no filter, should not be filtered",
+			filterCfg:      nil,
+			deviceKey:      "ap-lab",
+			expectFiltered: false,
+		},
+		{
+			name: "exclude by name",
+			filterCfg: &config.DeviceFilter{
+				Exclude: []config.DeviceMatch{{Name: []string{"*-Lab"}}},
+			},
+			deviceKey:      "ap-lab",
+			expectFiltered: true,
+		},
+		{
+			name: "exclude by mac, colon separated pattern",
+			filterCfg: &config.DeviceFilter{
+				Exclude: []config.DeviceMatch{{MAC: []string{"5C:5B:35:00:00:02"}}},
+			},
+			deviceKey:      "ap-lab",
+			expectFiltered: true,
+		},
+		{
+			name: "include type, no match",
+			filterCfg: &config.DeviceFilter{
+				Include: []config.DeviceMatch{{Type: []string{"ap"}}},
+			},
+			deviceKey:      "switch-lab",
+			expectFiltered: true,
+		},
+		{
+			name: "include type and model, must all match",
+			filterCfg: &config.DeviceFilter{
+				Include: []config.DeviceMatch{{Type: []string{"ap"}, Model: []string{"AP4*"}}},
+			},
+			deviceKey:      "ap-lab",
+			expectFiltered: true,
+		},
+		{
+			name: "include type and model, match",
+			filterCfg: &config.DeviceFilter{
+				Include: []config.DeviceMatch{{Type: []string{"ap"}, Model: []string{"AP4*"}}},
+			},
+			deviceKey:      "ap-floor1",
+			expectFiltered: false,
+		},
+		{
+			name: "invalid pattern",
+			filterCfg: &config.DeviceFilter{
+				Include: []config.DeviceMatch{{Model: []string{"["}}},
+			},
+			expectErr: true,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			f, err := NewDeviceFilter(tc.filterCfg)
+
+			if tc.expectErr {
+				if err == nil {
+					t.Fatal("NewDeviceFilter() expected an error, but got nil")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("NewDeviceFilter() returned an unexpected error: %v", err)
+			}
+
+			if isFiltered := f.IsFiltered(devices[tc.deviceKey]); isFiltered != tc.expectFiltered {
+				t.Errorf("IsFiltered() for device %q returned %v, want %v", tc.deviceKey, isFiltered, tc.expectFiltered)
+			}
+		})
+	}
+}
@@ -0,0 +1,110 @@
+package filter
+
+import (
+	"fmt"
+	"regexp"
+
+	"github.com/gregwight/mistclient"
+	"github.com/gregwight/mistexporter/internal/config"
+)
+
+const (
+	siteMatchAll = "all"
+	siteMatchAny = "any"
+)
+
+// siteRule is a validated site match rule with its regular expressions compiled.
+type siteRule struct {
+	any     bool
+	match   config.SiteMatch
+	regexes []*regexp.Regexp
+}
+
+func newSiteRule(cfg config.SiteMatch) (siteRule, error) {
+	rule := siteRule{match: cfg}
+
+	switch cfg.Match {
+	case "", siteMatchAll:
+	case siteMatchAny:
+		rule.any = true
+	default:
+		return siteRule{}, fmt.Errorf("invalid match %q: must be one of %q or %q", cfg.Match, siteMatchAll, siteMatchAny)
+	}
+
+	if err := validateAttributes(siteAttributes(cfg, mistclient.Site{})); err != nil {
+		return siteRule{}, err
+	}
+	if err := validatePatterns("site_group", cfg.SiteGroup); err != nil {
+		return siteRule{}, err
+	}
+
+	for _, expr := range cfg.NameRegex {
+		// Expressions are anchored to match the whole site name.
+		re, err := regexp.Compile("^(?:" + expr + ")$")
+		if err != nil {
+			return siteRule{}, fmt.Errorf("invalid name_regex %q: %w", expr, err)
+		}
+		rule.regexes = append(rule.regexes, re)
+	}
+
+	if len(rule.conditions(mistclient.Site{}, nil)) == 0 {
+		return siteRule{}, fmt.Errorf("rule must match on at least one attribute")
+	}
+
+	return rule, nil
+}
+
+// matches checks if the site matches all, or any, of the attributes configured in the rule.
+// groups holds the IDs and names of the site groups the site belongs to.
+func (r siteRule) matches(site mistclient.Site, groups []string) bool {
+	conditions := r.conditions(site, groups)
+	for _, matched := range conditions {
+		if matched == r.any {
+			return r.any
+		}
+	}
+	return !r.any
+}
+
+// conditions returns the result of matching the site against each attribute configured in the rule.
+func (r siteRule) conditions(site mistclient.Site, groups []string) []bool {
+	var conditions []bool
+	for _, attr := range siteAttributes(r.match, site) {
+		if len(attr.patterns) > 0 {
+			conditions = append(conditions, matchesAttributes([]attribute{attr}))
+		}
+	}
+
+	if len(r.regexes) > 0 {
+		matched := false
+		for _, re := range r.regexes {
+			if re.MatchString(site.Name) {
+				matched = true
+				break
+			}
+		}
+		conditions = append(conditions, matched)
+	}
+
+	if len(r.match.SiteGroup) > 0 {
+		matched := false
+		for _, group := range groups {
+			if ok, err := matches(group, r.match.SiteGroup); err == nil && ok {
+				matched = true
+				break
+			}
+		}
+		conditions = append(conditions, matched)
+	}
+
+	return conditions
+}
+
+func siteAttributes(rule config.SiteMatch, site mistclient.Site) []attribute {
+	return []attribute{
+		{"id", site.ID, rule.ID},
+		{"name", site.Name, rule.Name},
+		{"country_code", site.CountryCode, rule.CountryCode},
+		{"timezone", site.Timezone, rule.Timezone},
+	}
+}
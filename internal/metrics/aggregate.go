@@ -0,0 +1,576 @@
+package metrics
+
+import (
+	"cmp"
+	"fmt"
+	"maps"
+	"math"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/gregwight/mistclient"
+	"github.com/gregwight/mistexporter/internal/config"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// SiteClientLabelNames returns the labels attached to site-level aggregated wireless client metrics.
+func SiteClientLabelNames() []string {
+	return append(SiteLabelNames(),
+		"ssid",
+		"radio",
+	)
+}
+
+// SiteSSIDLabelNames returns the labels attached to per-SSID aggregated wireless client metrics.
+func SiteSSIDLabelNames() []string {
+	return append(SiteLabelNames(), "ssid")
+}
+
+// SiteProtocolLabelNames returns the labels attached to per-protocol aggregated wireless client metrics.
+func SiteProtocolLabelNames() []string {
+	return append(SiteLabelNames(), "proto", "radio")
+}
+
+// SiteOSLabelNames returns the labels attached to per-OS aggregated wireless client metrics.
+func SiteOSLabelNames() []string {
+	return append(SiteLabelNames(), "os_family")
+}
+
+// SiteManufacturerLabelNames returns the labels attached to per-manufacturer aggregated wireless client metrics.
+func SiteManufacturerLabelNames() []string {
+	return append(SiteLabelNames(), "manufacturer")
+}
+
+// DeviceClientLabelNames returns the labels attached to per-device aggregated wireless client metrics.
+func DeviceClientLabelNames() []string {
+	return append(StreamedDeviceIdentityLabelNames(),
+		"ssid",
+		"radio",
+		"proto",
+	)
+}
+
+// SiteClientLabelValues generates label values for site-level aggregated wireless client metrics.
+func SiteClientLabelValues(s mistclient.Site, c mistclient.StreamedClientStat) []string {
+	return append(SiteLabelValues(s),
+		c.SSID,
+		c.Band.String(),
+	)
+}
+
+var clientHistograms *ClientHistograms
+
+// ClientHistograms holds histograms of wireless client signal quality, aggregated per site, SSID and band.
+type ClientHistograms struct {
+	rssiDbm          *histogramVec
+	snrDb            *histogramVec
+	receiveRateMbps  *histogramVec
+	transmitRateMbps *histogramVec
+}
+
+// Classic bucket layouts for the client histograms. These are always exposed, with native
+// buckets being exposed in addition when enabled.
+var (
+	rssiBuckets = prometheus.LinearBuckets(-90, 5, 13)
+	snrBuckets  = prometheus.LinearBuckets(5, 5, 10)
+	rateBuckets = []float64{1, 6, 12, 24, 54, 100, 200, 400, 600, 866, 1200, 2400}
+)
+
+func newClientHistograms(reg *prometheus.Registry, cfg *config.ClientHistograms) *ClientHistograms {
+	opts := func(name, help string, buckets []float64) prometheus.HistogramOpts {
+		o := prometheus.HistogramOpts{
+			Namespace:   Namespace(),
+			Subsystem:   "site",
+			Name:        name,
+			Help:        help,
+			ConstLabels: ConstLabels(),
+			Buckets:     buckets,
+		}
+		if cfg.NativeHistograms {
+			o.NativeHistogramBucketFactor = cfg.NativeHistogramBucketFactor
+			o.NativeHistogramMaxBucketNumber = 160
+			o.NativeHistogramMinResetDuration = time.Hour
+		}
+		return o
+	}
+
+	m := &ClientHistograms{
+		rssiDbm: newHistogramVec(
+			opts("client_rssi_dbm", "Distribution of wireless client Received Signal Strength Indicator in dBm.", rssiBuckets),
+			SiteClientLabelNames(),
+		),
+		snrDb: newHistogramVec(
+			opts("client_snr_db", "Distribution of wireless client Signal-to-Noise Ratio in dB.", snrBuckets),
+			SiteClientLabelNames(),
+		),
+		receiveRateMbps: newHistogramVec(
+			opts("client_receive_rate_mbps", "Distribution of wireless client receive data rate in Mbps.", rateBuckets),
+			SiteClientLabelNames(),
+		),
+		transmitRateMbps: newHistogramVec(
+			opts("client_transmit_rate_mbps", "Distribution of wireless client transmit data rate in Mbps.", rateBuckets),
+			SiteClientLabelNames(),
+		),
+	}
+
+	reg.MustRegister(
+		m.rssiDbm,
+		m.snrDb,
+		m.receiveRateMbps,
+		m.transmitRateMbps,
+	)
+
+	return m
+}
+
+// observe records a single client stat in the histograms. Zero values indicate the
+// field was absent from the update and are not recorded. Clients are observed from the
+// tracked state of each site at a fixed interval, so that each client is weighted equally
+// however often it is streamed.
+func (m *ClientHistograms) observe(site mistclient.Site, stat mistclient.StreamedClientStat) {
+	labels := SiteClientLabelValues(site, stat)
+
+	if stat.RSSI != 0 {
+		m.rssiDbm.WithLabelValues(labels...).Observe(float64(stat.RSSI))
+	}
+	if stat.SNR != 0 {
+		m.snrDb.WithLabelValues(labels...).Observe(float64(stat.SNR))
+	}
+	if stat.RxRate != 0 {
+		m.receiveRateMbps.WithLabelValues(labels...).Observe(float64(stat.RxRate))
+	}
+	if stat.TxRate != 0 {
+		m.transmitRateMbps.WithLabelValues(labels...).Observe(float64(stat.TxRate))
+	}
+}
+
+// trackedClient holds the most recent stat received for a wireless client.
+type trackedClient struct {
+	deviceName string
+	stat       mistclient.StreamedClientStat
+	updated    time.Time
+}
+
+// clientRanker scores a client for ranking, with higher scores ranked first.
+type clientRanker func(stat mistclient.StreamedClientStat) float64
+
+// clientRankers defines the supported client ranking metrics.
+var clientRankers = map[string]clientRanker{
+	"throughput": func(stat mistclient.StreamedClientStat) float64 {
+		return float64(stat.TxBps + stat.RxBps)
+	},
+	"retries": func(stat mistclient.StreamedClientStat) float64 {
+		return float64(stat.TxRetries + stat.RxRetries)
+	},
+	"rssi": func(stat mistclient.StreamedClientStat) float64 {
+		// A zero RSSI indicates the value is unknown rather than a strong signal.
+		if stat.RSSI == 0 {
+			return math.Inf(-1)
+		}
+		return -float64(stat.RSSI)
+	},
+}
+
+// clientTracker holds the current state of the wireless clients at a site, from
+// which aggregated metrics are derived at scrape time. If a rank limit is set it
+// also holds the set of top ranked clients for which per-client series are exported.
+type clientTracker struct {
+	ttl       time.Duration
+	rankLimit int
+	ranker    clientRanker
+
+	mu      sync.Mutex
+	clients map[string]trackedClient
+	ranked  map[string]struct{}
+}
+
+func newClientTracker(cfg *config.Clients) (*clientTracker, error) {
+	t := &clientTracker{
+		ttl:     cfg.TTL,
+		clients: make(map[string]trackedClient),
+	}
+
+	if cfg.TopN != nil && cfg.TopN.Limit > 0 {
+		ranker, ok := clientRankers[cfg.TopN.RankBy]
+		if !ok {
+			return nil, fmt.Errorf("invalid client rank metric %q, must be one of throughput, retries or rssi", cfg.TopN.RankBy)
+		}
+		t.rankLimit = cfg.TopN.Limit
+		t.ranker = ranker
+		t.ranked = make(map[string]struct{})
+	}
+
+	return t, nil
+}
+
+// update records the latest stat for a client, keyed by the client MAC.
+func (t *clientTracker) update(deviceName string, stat mistclient.StreamedClientStat) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	t.clients[stat.Mac] = trackedClient{
+		deviceName: deviceName,
+		stat:       stat,
+		updated:    time.Now(),
+	}
+}
+
+// snapshot returns the clients which have been updated within the TTL, forgetting any which have not.
+func (t *clientTracker) snapshot() []trackedClient {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	return t.prune()
+}
+
+// prune forgets any clients which have not been updated within the TTL and returns
+// the remainder. The caller must hold t.mu.
+func (t *clientTracker) prune() []trackedClient {
+	cutoff := time.Now().Add(-t.ttl)
+	clients := make([]trackedClient, 0, len(t.clients))
+	for mac, c := range t.clients {
+		if c.updated.Before(cutoff) {
+			delete(t.clients, mac)
+			continue
+		}
+		clients = append(clients, c)
+	}
+
+	return clients
+}
+
+// forget stops tracking a client, removing it from the top ranked clients.
+func (t *clientTracker) forget(mac string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	delete(t.clients, mac)
+	delete(t.ranked, mac)
+}
+
+// reset forgets all clients, returning the MACs of the clients which were tracked or ranked.
+func (t *clientTracker) reset() []string {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	macs := slices.Collect(maps.Keys(t.clients))
+	for mac := range t.ranked {
+		if _, ok := t.clients[mac]; !ok {
+			macs = append(macs, mac)
+		}
+	}
+	clear(t.clients)
+	clear(t.ranked)
+	return macs
+}
+
+// ifRanked calls fn if the client is within the top ranked clients, or if ranking is disabled.
+// Free places are filled immediately rather than waiting for the next ranking. fn is called
+// with t.mu held so that it cannot race with a concurrent ranking.
+func (t *clientTracker) ifRanked(mac string, fn func()) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if t.ranked != nil {
+		if _, ok := t.ranked[mac]; !ok {
+			if len(t.ranked) >= t.rankLimit {
+				return
+			}
+			t.ranked[mac] = struct{}{}
+		}
+	}
+
+	fn()
+}
+
+// rank recomputes the top ranked clients, calling export for each client which has
+// entered the top N and forget for each client which has left it.
+func (t *clientTracker) rank(export func(trackedClient), forget func(mac string)) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if t.ranked == nil {
+		return
+	}
+
+	clients := t.prune()
+	slices.SortFunc(clients, func(a, b trackedClient) int {
+		if c := cmp.Compare(t.ranker(b.stat), t.ranker(a.stat)); c != 0 {
+			return c
+		}
+		return cmp.Compare(a.stat.Mac, b.stat.Mac)
+	})
+
+	ranked := make(map[string]struct{}, t.rankLimit)
+	for _, c := range clients[:min(t.rankLimit, len(clients))] {
+		ranked[c.stat.Mac] = struct{}{}
+		if _, ok := t.ranked[c.stat.Mac]; !ok {
+			export(c)
+		}
+	}
+
+	for mac := range t.ranked {
+		if _, ok := ranked[mac]; !ok {
+			forget(mac)
+		}
+	}
+
+	t.ranked = ranked
+}
+
+// clientAggregate accumulates the client count and throughput of a group of wireless clients.
+type clientAggregate struct {
+	clients     int
+	receiveBps  int
+	transmitBps int
+}
+
+func (a *clientAggregate) add(stat mistclient.StreamedClientStat) {
+	a.clients++
+	a.receiveBps += stat.RxBps
+	a.transmitBps += stat.TxBps
+}
+
+// deviceClientKey identifies the group of wireless clients sharing a device, SSID, band and protocol.
+type deviceClientKey struct {
+	deviceName string
+	deviceMac  string
+	ssid       string
+	radio      string
+	proto      string
+}
+
+// Label values used to bound the cardinality of the client population breakdowns. Clients
+// which Mist reports with these values are deliberately counted in the same buckets, as the
+// values have the same meaning.
+const (
+	breakdownUnknown = "unknown"
+	breakdownOther   = "other"
+)
+
+// topN returns the n largest counts, with the remainder summed under the "other" key.
+// Ties are broken by name so the selection is stable between scrapes. If n is not
+// positive the counts are returned unchanged.
+func topN(counts map[string]int, n int) map[string]int {
+	if n <= 0 || len(counts) <= n {
+		return counts
+	}
+
+	names := make([]string, 0, len(counts))
+	for name := range counts {
+		names = append(names, name)
+	}
+	slices.SortFunc(names, func(a, b string) int {
+		if c := cmp.Compare(counts[b], counts[a]); c != 0 {
+			return c
+		}
+		return cmp.Compare(a, b)
+	})
+
+	top := make(map[string]int, n+1)
+	for i, name := range names {
+		if i < n {
+			top[name] += counts[name]
+		} else {
+			top[breakdownOther] += counts[name]
+		}
+	}
+
+	return top
+}
+
+// breakdownKey returns the value used to group clients in a population breakdown.
+func breakdownKey(value string) string {
+	if value == "" {
+		return breakdownUnknown
+	}
+	return value
+}
+
+// aggregateCollector derives site-level client metrics from the tracked state of each site stream at scrape time.
+type aggregateCollector struct {
+	metrics       *MistMetrics
+	breakdownTopN int
+
+	siteClientsDesc             *prometheus.Desc
+	siteSSIDClientsDesc         *prometheus.Desc
+	siteSSIDReceiveBpsDesc      *prometheus.Desc
+	siteSSIDTransmitBpsDesc     *prometheus.Desc
+	siteProtocolClientsDesc     *prometheus.Desc
+	siteOSClientsDesc           *prometheus.Desc
+	siteManufacturerClientsDesc *prometheus.Desc
+	deviceClientsDesc           *prometheus.Desc
+	deviceClientReceiveBpsDesc  *prometheus.Desc
+	deviceClientTransmitBpsDesc *prometheus.Desc
+}
+
+func newAggregateCollector(metrics *MistMetrics, breakdownTopN int) *aggregateCollector {
+	return &aggregateCollector{
+		metrics:       metrics,
+		breakdownTopN: breakdownTopN,
+		siteClientsDesc: prometheus.NewDesc(
+			FQName("site_clients"),
+			"Number of wireless clients currently connected to the site, by SSID and band.",
+			SiteClientLabelNames(),
+			ConstLabels(),
+		),
+		siteSSIDClientsDesc: prometheus.NewDesc(
+			FQName("site_clients_by_ssid"),
+			"Number of wireless clients currently connected to the SSID at the site.",
+			SiteSSIDLabelNames(),
+			ConstLabels(),
+		),
+		siteSSIDReceiveBpsDesc: prometheus.NewDesc(
+			FQName("site_ssid_receive_bits_per_second"),
+			"Sum of bits per second received from the wireless clients connected to the SSID at the site.",
+			SiteSSIDLabelNames(),
+			ConstLabels(),
+		),
+		siteSSIDTransmitBpsDesc: prometheus.NewDesc(
+			FQName("site_ssid_transmit_bits_per_second"),
+			"Sum of bits per second transmitted to the wireless clients connected to the SSID at the site.",
+			SiteSSIDLabelNames(),
+			ConstLabels(),
+		),
+		siteProtocolClientsDesc: prometheus.NewDesc(
+			FQName("site_clients_by_protocol"),
+			"Number of wireless clients currently connected to the site, by 802.11 protocol and band.",
+			SiteProtocolLabelNames(),
+			ConstLabels(),
+		),
+		siteOSClientsDesc: prometheus.NewDesc(
+			FQName("site_clients_by_os"),
+			"Number of wireless clients currently connected to the site, by operating system family.",
+			SiteOSLabelNames(),
+			ConstLabels(),
+		),
+		siteManufacturerClientsDesc: prometheus.NewDesc(
+			FQName("site_clients_by_manufacturer"),
+			"Number of wireless clients currently connected to the site, by manufacturer.",
+			SiteManufacturerLabelNames(),
+			ConstLabels(),
+		),
+		deviceClientsDesc: prometheus.NewDesc(
+			FQName("device_clients"),
+			"Number of wireless clients currently connected to the device, by SSID, band and protocol.",
+			DeviceClientLabelNames(),
+			ConstLabels(),
+		),
+		deviceClientReceiveBpsDesc: prometheus.NewDesc(
+			FQName("device_client_receive_bits_per_second"),
+			"Sum of bits per second received from the wireless clients connected to the device.",
+			DeviceClientLabelNames(),
+			ConstLabels(),
+		),
+		deviceClientTransmitBpsDesc: prometheus.NewDesc(
+			FQName("device_client_transmit_bits_per_second"),
+			"Sum of bits per second transmitted to the wireless clients connected to the device.",
+			DeviceClientLabelNames(),
+			ConstLabels(),
+		),
+	}
+}
+
+// Describe implements the prometheus.Collector interface.
+func (c *aggregateCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- c.siteClientsDesc
+	ch <- c.siteSSIDClientsDesc
+	ch <- c.siteSSIDReceiveBpsDesc
+	ch <- c.siteSSIDTransmitBpsDesc
+	ch <- c.siteProtocolClientsDesc
+	ch <- c.siteOSClientsDesc
+	ch <- c.siteManufacturerClientsDesc
+	ch <- c.deviceClientsDesc
+	ch <- c.deviceClientReceiveBpsDesc
+	ch <- c.deviceClientTransmitBpsDesc
+}
+
+// Collect implements the prometheus.Collector interface.
+func (c *aggregateCollector) Collect(ch chan<- prometheus.Metric) {
+	c.metrics.mu.RLock()
+	streamers := make([]*StreamCollector, 0, len(c.metrics.sites))
+	for _, streamer := range c.metrics.sites {
+		streamers = append(streamers, streamer)
+	}
+	c.metrics.mu.RUnlock()
+
+	for _, streamer := range streamers {
+		bands := make(map[[2]string]int)
+		ssids := make(map[string]*clientAggregate)
+		devices := make(map[deviceClientKey]*clientAggregate)
+		protocols := make(map[[2]string]int)
+		oses := make(map[string]int)
+		manufacturers := make(map[string]int)
+
+		for _, client := range streamer.clients.snapshot() {
+			bands[[2]string{client.stat.SSID, client.stat.Band.String()}]++
+			protocols[[2]string{client.stat.Proto.String(), client.stat.Band.String()}]++
+			oses[breakdownKey(client.stat.Family)]++
+			manufacturers[breakdownKey(client.stat.Manufacture)]++
+
+			ssid, ok := ssids[client.stat.SSID]
+			if !ok {
+				ssid = &clientAggregate{}
+				ssids[client.stat.SSID] = ssid
+			}
+			ssid.add(client.stat)
+
+			// Clients are still counted at the site level when the clients
+			// of filtered devices are not dropped.
+			if streamer.isDeviceFiltered(client.stat.APMac) {
+				continue
+			}
+
+			key := deviceClientKey{
+				deviceName: client.deviceName,
+				deviceMac:  client.stat.APMac,
+				ssid:       client.stat.SSID,
+				radio:      client.stat.Band.String(),
+				proto:      client.stat.Proto.String(),
+			}
+			device, ok := devices[key]
+			if !ok {
+				device = &clientAggregate{}
+				devices[key] = device
+			}
+			device.add(client.stat)
+		}
+
+		siteLabels := SiteLabelValues(streamer.site)
+
+		for key, count := range bands {
+			labels := append(siteLabels[:len(siteLabels):len(siteLabels)], key[0], key[1])
+			ch <- prometheus.MustNewConstMetric(c.siteClientsDesc, prometheus.GaugeValue, float64(count), labels...)
+		}
+
+		for name, agg := range ssids {
+			labels := append(siteLabels[:len(siteLabels):len(siteLabels)], name)
+			ch <- prometheus.MustNewConstMetric(c.siteSSIDClientsDesc, prometheus.GaugeValue, float64(agg.clients), labels...)
+			ch <- prometheus.MustNewConstMetric(c.siteSSIDReceiveBpsDesc, prometheus.GaugeValue, float64(agg.receiveBps), labels...)
+			ch <- prometheus.MustNewConstMetric(c.siteSSIDTransmitBpsDesc, prometheus.GaugeValue, float64(agg.transmitBps), labels...)
+		}
+
+		for key, count := range protocols {
+			labels := append(siteLabels[:len(siteLabels):len(siteLabels)], key[0], key[1])
+			ch <- prometheus.MustNewConstMetric(c.siteProtocolClientsDesc, prometheus.GaugeValue, float64(count), labels...)
+		}
+
+		for name, count := range topN(oses, c.breakdownTopN) {
+			labels := append(siteLabels[:len(siteLabels):len(siteLabels)], name)
+			ch <- prometheus.MustNewConstMetric(c.siteOSClientsDesc, prometheus.GaugeValue, float64(count), labels...)
+		}
+
+		for name, count := range topN(manufacturers, c.breakdownTopN) {
+			labels := append(siteLabels[:len(siteLabels):len(siteLabels)], name)
+			ch <- prometheus.MustNewConstMetric(c.siteManufacturerClientsDesc, prometheus.GaugeValue, float64(count), labels...)
+		}
+
+		for key, agg := range devices {
+			labels := append(siteLabels[:len(siteLabels):len(siteLabels)], key.deviceName, key.deviceMac, key.ssid, key.radio, key.proto)
+			ch <- prometheus.MustNewConstMetric(c.deviceClientsDesc, prometheus.GaugeValue, float64(agg.clients), labels...)
+			ch <- prometheus.MustNewConstMetric(c.deviceClientReceiveBpsDesc, prometheus.GaugeValue, float64(agg.receiveBps), labels...)
+			ch <- prometheus.MustNewConstMetric(c.deviceClientTransmitBpsDesc, prometheus.GaugeValue, float64(agg.transmitBps), labels...)
+		}
+	}
+}
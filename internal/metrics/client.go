@@ -1,25 +1,32 @@
 package metrics
 
 import (
+	"fmt"
+	"slices"
+	"sync"
+
 	"github.com/gregwight/mistclient"
+	"github.com/gregwight/mistexporter/internal/filter"
 	"github.com/prometheus/client_golang/prometheus"
 )
 
-// StreamedClientLabelNames defines the labels attached to streamed wireless client metrics.
-var StreamedClientLabelNames = append(SiteLabelNames,
-	"device_name",
-	"device_mac",
-	"client_mac",
-	"client_username",
-	"client_hostname",
-	"client_os",
-	"client_manufacturer",
-	"client_family",
-	"client_model",
-	"proto",
-	"radio",
-	"ssid",
-)
+// StreamedClientLabelNames returns the labels attached to streamed wireless client metrics.
+func StreamedClientLabelNames() []string {
+	return append(SiteLabelNames(),
+		"device_name",
+		"device_mac",
+		"client_mac",
+		"client_username",
+		"client_hostname",
+		"client_os",
+		"client_manufacturer",
+		"client_family",
+		"client_model",
+		"proto",
+		"radio",
+		"ssid",
+	)
+}
 
 // StreamedClientLabelValues generates label values for streamed wireless client metrics.
 func StreamedClientLabelValues(s mistclient.Site, deviceName string, c mistclient.StreamedClientStat) []string {
@@ -39,193 +46,303 @@ func StreamedClientLabelValues(s mistclient.Site, deviceName string, c mistclien
 	)
 }
 
-var clientMetrics *ClientMetrics
+// clientLabels selects which of the StreamedClientLabelNames are attached to client
+// value series. Site labels and the client MAC are always retained, and any client
+// labels which are not selected are moved to the client info metric instead.
+type clientLabels struct {
+	names       []string
+	indexes     []int
+	infoNames   []string
+	infoIndexes []int
+}
+
+// newClientLabels creates a clientLabels retaining the given client labels. If no
+// labels are given all labels are retained and no info metric is required.
+func newClientLabels(keep []string) (*clientLabels, error) {
+	if len(keep) == 0 {
+		keep = StreamedClientLabelNames()[len(SiteLabelNames()):]
+	}
+
+	for _, name := range keep {
+		if !slices.Contains(StreamedClientLabelNames()[len(SiteLabelNames()):], name) {
+			return nil, fmt.Errorf("unknown client label %q", name)
+		}
+	}
+	if !slices.Contains(keep, "client_mac") {
+		return nil, fmt.Errorf("client labels must include %q", "client_mac")
+	}
+
+	l := &clientLabels{}
+	for i, name := range StreamedClientLabelNames() {
+		isSiteLabel := i < len(SiteLabelNames())
+		if isSiteLabel || slices.Contains(keep, name) {
+			l.names = append(l.names, name)
+			l.indexes = append(l.indexes, i)
+		}
+		if isSiteLabel || name == "client_mac" || !slices.Contains(keep, name) {
+			l.infoNames = append(l.infoNames, name)
+			l.infoIndexes = append(l.infoIndexes, i)
+		}
+	}
+
+	// The info metric is only required if labels have been moved to it.
+	if len(l.infoNames) == len(SiteLabelNames())+1 {
+		l.infoNames = nil
+		l.infoIndexes = nil
+	}
+
+	return l, nil
+}
+
+// values selects the value series label values from the full set of client label values.
+func (l *clientLabels) values(all []string) []string {
+	return selectLabelValues(all, l.indexes)
+}
+
+// infoValues selects the info metric label values from the full set of client label values.
+func (l *clientLabels) infoValues(all []string) []string {
+	return selectLabelValues(all, l.infoIndexes)
+}
+
+func selectLabelValues(all []string, indexes []int) []string {
+	values := make([]string, len(indexes))
+	for i, idx := range indexes {
+		values[i] = all[idx]
+	}
+	return values
+}
+
+var (
+	clientMetrics *ClientMetrics
+	clientFilter  *filter.ClientFilter
+)
 
 // ClientMetrics holds metrics related to wireless clients.
 type ClientMetrics struct {
-	channel               *prometheus.GaugeVec
-	dualBandCapable       *prometheus.GaugeVec
-	idleSeconds           *prometheus.GaugeVec
-	isGuest               *prometheus.GaugeVec
-	lastSeenTimestamp     *prometheus.GaugeVec
-	locatingAps           *prometheus.GaugeVec
-	powerSavingModeActive *prometheus.GaugeVec
-	rssiDbm               *prometheus.GaugeVec
-	receiveBps            *prometheus.GaugeVec
-	receiveBytesTotal     *prometheus.GaugeVec
-	receivePacketsTotal   *prometheus.GaugeVec
-	receiveRateMbps       *prometheus.GaugeVec
-	receiveRetriesTotal   *prometheus.GaugeVec
-	snrDb                 *prometheus.GaugeVec
-	transmitBps           *prometheus.GaugeVec
-	transmitBytesTotal    *prometheus.GaugeVec
-	transmitPacketsTotal  *prometheus.GaugeVec
-	transmitRateMbps      *prometheus.GaugeVec
-	transmitRetriesTotal  *prometheus.GaugeVec
-	uptimeSeconds         *prometheus.GaugeVec
+	labels  *clientLabels
+	privacy *privacyPolicy
+
+	// info is only created if client labels have been moved off the value series.
+	info *gaugeVec
+
+	// mu guards series, which holds the label values of the current series
+	// of each client, keyed by client MAC.
+	mu     sync.Mutex
+	series map[string]clientSeries
+
+	channel               *gaugeVec
+	dualBandCapable       *gaugeVec
+	idleSeconds           *gaugeVec
+	isGuest               *gaugeVec
+	lastSeenTimestamp     *gaugeVec
+	locatingAps           *gaugeVec
+	powerSavingModeActive *gaugeVec
+	rssiDbm               *gaugeVec
+	receiveBps            *gaugeVec
+	receiveBytesTotal     *gaugeVec
+	receivePacketsTotal   *gaugeVec
+	receiveRateMbps       *gaugeVec
+	receiveRetriesTotal   *gaugeVec
+	snrDb                 *gaugeVec
+	transmitBps           *gaugeVec
+	transmitBytesTotal    *gaugeVec
+	transmitPacketsTotal  *gaugeVec
+	transmitRateMbps      *gaugeVec
+	transmitRetriesTotal  *gaugeVec
+	uptimeSeconds         *gaugeVec
+}
+
+// clientSeries holds the label values of the value and info series of a client.
+type clientSeries struct {
+	labels []string
+	info   []string
 }
 
-func newClientMetrics(reg *prometheus.Registry) *ClientMetrics {
+func newClientMetrics(reg *prometheus.Registry, labels *clientLabels, privacy *privacyPolicy) *ClientMetrics {
 	m := &ClientMetrics{
-		channel: prometheus.NewGaugeVec(
+		labels:  labels,
+		privacy: privacy,
+		series:  make(map[string]clientSeries),
+
+		channel: newGaugeVec(
 			prometheus.GaugeOpts{
-				Namespace: "mist",
-				Subsystem: "client",
-				Name:      "channel",
-				Help:      "The channel the client is connected on.",
-			}, StreamedClientLabelNames,
+				Namespace:   Namespace(),
+				Subsystem:   "client",
+				Name:        "channel",
+				Help:        "The channel the client is connected on.",
+				ConstLabels: ConstLabels(),
+			}, labels.names,
 		),
-		dualBandCapable: prometheus.NewGaugeVec(
+		dualBandCapable: newGaugeVec(
 			prometheus.GaugeOpts{
-				Namespace: "mist",
-				Subsystem: "client",
-				Name:      "dual_band_capable",
-				Help:      "Whether the client is dual-band capable (1 for true, 0 for false).",
-			}, StreamedClientLabelNames,
+				Namespace:   Namespace(),
+				Subsystem:   "client",
+				Name:        "dual_band_capable",
+				Help:        "Whether the client is dual-band capable (1 for true, 0 for false).",
+				ConstLabels: ConstLabels(),
+			}, labels.names,
 		),
-		idleSeconds: prometheus.NewGaugeVec(
+		idleSeconds: newGaugeVec(
 			prometheus.GaugeOpts{
-				Namespace: "mist",
-				Subsystem: "client",
-				Name:      "idle_seconds",
-				Help:      "Time in seconds since the client was last active.",
-			}, StreamedClientLabelNames,
+				Namespace:   Namespace(),
+				Subsystem:   "client",
+				Name:        "idle_seconds",
+				Help:        "Time in seconds since the client was last active.",
+				ConstLabels: ConstLabels(),
+			}, labels.names,
 		),
-		isGuest: prometheus.NewGaugeVec(
+		isGuest: newGaugeVec(
 			prometheus.GaugeOpts{
-				Namespace: "mist",
-				Subsystem: "client",
-				Name:      "is_guest_status",
-				Help:      "Whether the client is a guest user (1 for true, 0 for false).",
-			}, StreamedClientLabelNames,
+				Namespace:   Namespace(),
+				Subsystem:   "client",
+				Name:        "is_guest_status",
+				Help:        "Whether the client is a guest user (1 for true, 0 for false).",
+				ConstLabels: ConstLabels(),
+			}, labels.names,
 		),
-		lastSeenTimestamp: prometheus.NewGaugeVec(
+		lastSeenTimestamp: newGaugeVec(
 			prometheus.GaugeOpts{
-				Namespace: "mist",
-				Subsystem: "client",
-				Name:      "last_seen_timestamp_seconds",
-				Help:      "The last time the client was seen, as a Unix timestamp.",
-			}, StreamedClientLabelNames,
+				Namespace:   Namespace(),
+				Subsystem:   "client",
+				Name:        "last_seen_timestamp_seconds",
+				Help:        "The last time the client was seen, as a Unix timestamp.",
+				ConstLabels: ConstLabels(),
+			}, labels.names,
 		),
-		locatingAps: prometheus.NewGaugeVec(
+		locatingAps: newGaugeVec(
 			prometheus.GaugeOpts{
-				Namespace: "mist",
-				Subsystem: "client",
-				Name:      "locating_aps",
-				Help:      "The number of APs that can hear the client.",
-			}, StreamedClientLabelNames,
+				Namespace:   Namespace(),
+				Subsystem:   "client",
+				Name:        "locating_aps",
+				Help:        "The number of APs that can hear the client.",
+				ConstLabels: ConstLabels(),
+			}, labels.names,
 		),
-		powerSavingModeActive: prometheus.NewGaugeVec(
+		powerSavingModeActive: newGaugeVec(
 			prometheus.GaugeOpts{
-				Namespace: "mist",
-				Subsystem: "client",
-				Name:      "power_saving_mode_active",
-				Help:      "Whether the client is in power-saving mode (1 for true, 0 for false).",
-			}, StreamedClientLabelNames,
+				Namespace:   Namespace(),
+				Subsystem:   "client",
+				Name:        "power_saving_mode_active",
+				Help:        "Whether the client is in power-saving mode (1 for true, 0 for false).",
+				ConstLabels: ConstLabels(),
+			}, labels.names,
 		),
-		rssiDbm: prometheus.NewGaugeVec(
+		rssiDbm: newGaugeVec(
 			prometheus.GaugeOpts{
-				Namespace: "mist",
-				Subsystem: "client",
-				Name:      "rssi_dbm",
-				Help:      "The client's Received Signal Strength Indicator in dBm.",
-			}, StreamedClientLabelNames,
+				Namespace:   Namespace(),
+				Subsystem:   "client",
+				Name:        "rssi_dbm",
+				Help:        "The client's Received Signal Strength Indicator in dBm.",
+				ConstLabels: ConstLabels(),
+			}, labels.names,
 		),
-		receiveBps: prometheus.NewGaugeVec(
+		receiveBps: newGaugeVec(
 			prometheus.GaugeOpts{
-				Namespace: "mist",
-				Subsystem: "client",
-				Name:      "receive_bits_per_second",
-				Help:      "Bits per second received from the client.",
-			}, StreamedClientLabelNames,
+				Namespace:   Namespace(),
+				Subsystem:   "client",
+				Name:        "receive_bits_per_second",
+				Help:        "Bits per second received from the client.",
+				ConstLabels: ConstLabels(),
+			}, labels.names,
 		),
-		receiveBytesTotal: prometheus.NewGaugeVec(
+		receiveBytesTotal: newGaugeVec(
 			prometheus.GaugeOpts{
-				Namespace: "mist",
-				Subsystem: "client",
-				Name:      "receive_bytes",
-				Help:      "Total bytes received from the client.",
-			}, StreamedClientLabelNames,
+				Namespace:   Namespace(),
+				Subsystem:   "client",
+				Name:        "receive_bytes",
+				Help:        "Total bytes received from the client.",
+				ConstLabels: ConstLabels(),
+			}, labels.names,
 		),
-		receivePacketsTotal: prometheus.NewGaugeVec(
+		receivePacketsTotal: newGaugeVec(
 			prometheus.GaugeOpts{
-				Namespace: "mist",
-				Subsystem: "client",
-				Name:      "receive_packets",
-				Help:      "Total packets received from the client.",
-			}, StreamedClientLabelNames,
+				Namespace:   Namespace(),
+				Subsystem:   "client",
+				Name:        "receive_packets",
+				Help:        "Total packets received from the client.",
+				ConstLabels: ConstLabels(),
+			}, labels.names,
 		),
-		receiveRateMbps: prometheus.NewGaugeVec(
+		receiveRateMbps: newGaugeVec(
 			prometheus.GaugeOpts{
-				Namespace: "mist",
-				Subsystem: "client",
-				Name:      "receive_rate_mbps",
-				Help:      "The receive data rate in Mbps.",
-			}, StreamedClientLabelNames,
+				Namespace:   Namespace(),
+				Subsystem:   "client",
+				Name:        "receive_rate_mbps",
+				Help:        "The receive data rate in Mbps.",
+				ConstLabels: ConstLabels(),
+			}, labels.names,
 		),
-		receiveRetriesTotal: prometheus.NewGaugeVec(
+		receiveRetriesTotal: newGaugeVec(
 			prometheus.GaugeOpts{
-				Namespace: "mist",
-				Subsystem: "client",
-				Name:      "receive_retries",
-				Help:      "Total number of receive retries.",
-			}, StreamedClientLabelNames,
+				Namespace:   Namespace(),
+				Subsystem:   "client",
+				Name:        "receive_retries",
+				Help:        "Total number of receive retries.",
+				ConstLabels: ConstLabels(),
+			}, labels.names,
 		),
-		snrDb: prometheus.NewGaugeVec(
+		snrDb: newGaugeVec(
 			prometheus.GaugeOpts{
-				Namespace: "mist",
-				Subsystem: "client",
-				Name:      "snr_db",
-				Help:      "The client's Signal-to-Noise Ratio in dB.",
-			}, StreamedClientLabelNames,
+				Namespace:   Namespace(),
+				Subsystem:   "client",
+				Name:        "snr_db",
+				Help:        "The client's Signal-to-Noise Ratio in dB.",
+				ConstLabels: ConstLabels(),
+			}, labels.names,
 		),
-		transmitBps: prometheus.NewGaugeVec(
+		transmitBps: newGaugeVec(
 			prometheus.GaugeOpts{
-				Namespace: "mist",
-				Subsystem: "client",
-				Name:      "transmit_bits_per_second",
-				Help:      "Bits per second transmitted to the client.",
-			}, StreamedClientLabelNames,
+				Namespace:   Namespace(),
+				Subsystem:   "client",
+				Name:        "transmit_bits_per_second",
+				Help:        "Bits per second transmitted to the client.",
+				ConstLabels: ConstLabels(),
+			}, labels.names,
 		),
-		transmitBytesTotal: prometheus.NewGaugeVec(
+		transmitBytesTotal: newGaugeVec(
 			prometheus.GaugeOpts{
-				Namespace: "mist",
-				Subsystem: "client",
-				Name:      "transmit_bytes",
-				Help:      "Total bytes transmitted to the client.",
-			}, StreamedClientLabelNames,
+				Namespace:   Namespace(),
+				Subsystem:   "client",
+				Name:        "transmit_bytes",
+				Help:        "Total bytes transmitted to the client.",
+				ConstLabels: ConstLabels(),
+			}, labels.names,
 		),
-		transmitPacketsTotal: prometheus.NewGaugeVec(
+		transmitPacketsTotal: newGaugeVec(
 			prometheus.GaugeOpts{
-				Namespace: "mist",
-				Subsystem: "client",
-				Name:      "transmit_packets",
-				Help:      "Total packets transmitted to the client.",
-			}, StreamedClientLabelNames,
+				Namespace:   Namespace(),
+				Subsystem:   "client",
+				Name:        "transmit_packets",
+				Help:        "Total packets transmitted to the client.",
+				ConstLabels: ConstLabels(),
+			}, labels.names,
 		),
-		transmitRateMbps: prometheus.NewGaugeVec(
+		transmitRateMbps: newGaugeVec(
 			prometheus.GaugeOpts{
-				Namespace: "mist",
-				Subsystem: "client",
-				Name:      "transmit_rate_mbps",
-				Help:      "The transmit data rate in Mbps.",
-			}, StreamedClientLabelNames,
+				Namespace:   Namespace(),
+				Subsystem:   "client",
+				Name:        "transmit_rate_mbps",
+				Help:        "The transmit data rate in Mbps.",
+				ConstLabels: ConstLabels(),
+			}, labels.names,
 		),
-		transmitRetriesTotal: prometheus.NewGaugeVec(
+		transmitRetriesTotal: newGaugeVec(
 			prometheus.GaugeOpts{
-				Namespace: "mist",
-				Subsystem: "client",
-				Name:      "transmit_retries",
-				Help:      "Total number of transmit retries.",
-			}, StreamedClientLabelNames,
+				Namespace:   Namespace(),
+				Subsystem:   "client",
+				Name:        "transmit_retries",
+				Help:        "Total number of transmit retries.",
+				ConstLabels: ConstLabels(),
+			}, labels.names,
 		),
-		uptimeSeconds: prometheus.NewGaugeVec(
+		uptimeSeconds: newGaugeVec(
 			prometheus.GaugeOpts{
-				Namespace: "mist",
-				Subsystem: "client",
-				Name:      "uptime_seconds",
-				Help:      "The client's session uptime in seconds.",
-			}, StreamedClientLabelNames,
+				Namespace:   Namespace(),
+				Subsystem:   "client",
+				Name:        "uptime_seconds",
+				Help:        "The client's session uptime in seconds.",
+				ConstLabels: ConstLabels(),
+			}, labels.names,
 		),
 	}
 
@@ -252,30 +369,138 @@ func newClientMetrics(reg *prometheus.Registry) *ClientMetrics {
 		m.uptimeSeconds,
 	)
 
+	if labels.infoNames != nil {
+		m.info = newGaugeVec(
+			prometheus.GaugeOpts{
+				Namespace:   Namespace(),
+				Subsystem:   "client",
+				Name:        "info",
+				Help:        "Descriptive labels of the client which are not attached to other client metrics. The value is always 1.",
+				ConstLabels: ConstLabels(),
+			}, labels.infoNames,
+		)
+		reg.MustRegister(m.info)
+	}
+
 	return m
 }
 
-func handleSiteClientStat(site mistclient.Site, deviceName string, stat mistclient.StreamedClientStat) {
-	labels := StreamedClientLabelValues(site, deviceName, stat)
-
-	clientMetrics.channel.WithLabelValues(labels...).Set(float64(stat.Channel))
-	clientMetrics.dualBandCapable.WithLabelValues(labels...).Set(boolToFloat64(stat.DualBand))
-	clientMetrics.idleSeconds.WithLabelValues(labels...).Set(stat.Idletime.Seconds())
-	clientMetrics.isGuest.WithLabelValues(labels...).Set(boolToFloat64(stat.IsGuest))
-	clientMetrics.lastSeenTimestamp.WithLabelValues(labels...).Set(float64(stat.LastSeen.Unix()))
-	clientMetrics.locatingAps.WithLabelValues(labels...).Set(float64(stat.NumLocatingAPs))
-	clientMetrics.powerSavingModeActive.WithLabelValues(labels...).Set(boolToFloat64(stat.PowerSaving))
-	clientMetrics.rssiDbm.WithLabelValues(labels...).Set(float64(stat.RSSI))
-	clientMetrics.receiveBps.WithLabelValues(labels...).Set(float64(stat.RxBps))
-	clientMetrics.receiveBytesTotal.WithLabelValues(labels...).Set(float64(stat.RxBytes))
-	clientMetrics.receivePacketsTotal.WithLabelValues(labels...).Set(float64(stat.RxPackets))
-	clientMetrics.receiveRateMbps.WithLabelValues(labels...).Set(float64(stat.RxRate))
-	clientMetrics.receiveRetriesTotal.WithLabelValues(labels...).Set(float64(stat.RxRetries))
-	clientMetrics.snrDb.WithLabelValues(labels...).Set(float64(stat.SNR))
-	clientMetrics.transmitBps.WithLabelValues(labels...).Set(float64(stat.TxBps))
-	clientMetrics.transmitBytesTotal.WithLabelValues(labels...).Set(float64(stat.TxBytes))
-	clientMetrics.transmitPacketsTotal.WithLabelValues(labels...).Set(float64(stat.TxPackets))
-	clientMetrics.transmitRateMbps.WithLabelValues(labels...).Set(float64(stat.TxRate))
-	clientMetrics.transmitRetriesTotal.WithLabelValues(labels...).Set(float64(stat.TxRetries))
-	clientMetrics.uptimeSeconds.WithLabelValues(labels...).Set(stat.Uptime.Seconds())
+func handleSiteClientStat(site mistclient.Site, deviceName string, stat mistclient.StreamedClientStat, clients *clientTracker) {
+	// Filtered clients are dropped before any state or series are created. A client
+	// may become filtered, e.g. by moving to a filtered SSID, so any existing state
+	// and series are removed.
+	if clientFilter != nil && clientFilter.IsFiltered(deviceName, stat) {
+		clients.forget(stat.Mac)
+		if clientMetrics != nil {
+			clientMetrics.forget(stat.Mac)
+		}
+		return
+	}
+
+	clients.update(deviceName, stat)
+
+	// Per-client metrics may be disabled in favour of the aggregated metrics.
+	if clientMetrics == nil {
+		return
+	}
+
+	// When only the top ranked clients are exported, the series of any
+	// other client are created by the ranking if it enters the top N.
+	clients.ifRanked(stat.Mac, func() {
+		clientMetrics.observe(site, deviceName, stat)
+	})
+}
+
+// observe sets the series of a client, removing its previous series if any of
+// the labels have changed so that stale series do not accumulate.
+func (m *ClientMetrics) observe(site mistclient.Site, deviceName string, stat mistclient.StreamedClientStat) {
+	allLabels := StreamedClientLabelValues(site, deviceName, stat)
+	m.privacy.apply(allLabels)
+	labels := m.labels.values(allLabels)
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	prev, ok := m.series[stat.Mac]
+	if ok && !slices.Equal(prev.labels, labels) {
+		for _, vec := range m.valueVecs() {
+			vec.DeleteLabelValues(prev.labels...)
+		}
+	}
+
+	m.channel.WithLabelValues(labels...).Set(float64(stat.Channel))
+	m.dualBandCapable.WithLabelValues(labels...).Set(boolToFloat64(stat.DualBand))
+	m.idleSeconds.WithLabelValues(labels...).Set(stat.Idletime.Seconds())
+	m.isGuest.WithLabelValues(labels...).Set(boolToFloat64(stat.IsGuest))
+	m.lastSeenTimestamp.WithLabelValues(labels...).Set(float64(stat.LastSeen.Unix()))
+	m.locatingAps.WithLabelValues(labels...).Set(float64(stat.NumLocatingAPs))
+	m.powerSavingModeActive.WithLabelValues(labels...).Set(boolToFloat64(stat.PowerSaving))
+	m.rssiDbm.WithLabelValues(labels...).Set(float64(stat.RSSI))
+	m.receiveBps.WithLabelValues(labels...).Set(float64(stat.RxBps))
+	m.receiveBytesTotal.WithLabelValues(labels...).Set(float64(stat.RxBytes))
+	m.receivePacketsTotal.WithLabelValues(labels...).Set(float64(stat.RxPackets))
+	m.receiveRateMbps.WithLabelValues(labels...).Set(float64(stat.RxRate))
+	m.receiveRetriesTotal.WithLabelValues(labels...).Set(float64(stat.RxRetries))
+	m.snrDb.WithLabelValues(labels...).Set(float64(stat.SNR))
+	m.transmitBps.WithLabelValues(labels...).Set(float64(stat.TxBps))
+	m.transmitBytesTotal.WithLabelValues(labels...).Set(float64(stat.TxBytes))
+	m.transmitPacketsTotal.WithLabelValues(labels...).Set(float64(stat.TxPackets))
+	m.transmitRateMbps.WithLabelValues(labels...).Set(float64(stat.TxRate))
+	m.transmitRetriesTotal.WithLabelValues(labels...).Set(float64(stat.TxRetries))
+	m.uptimeSeconds.WithLabelValues(labels...).Set(stat.Uptime.Seconds())
+
+	series := clientSeries{labels: labels}
+	if m.info != nil {
+		series.info = m.labels.infoValues(allLabels)
+		if ok && !slices.Equal(prev.info, series.info) {
+			m.info.DeleteLabelValues(prev.info...)
+		}
+		m.info.WithLabelValues(series.info...).Set(1)
+	}
+	m.series[stat.Mac] = series
+}
+
+// forget removes all series of a client.
+func (m *ClientMetrics) forget(mac string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	series, ok := m.series[mac]
+	if !ok {
+		return
+	}
+
+	for _, vec := range m.valueVecs() {
+		vec.DeleteLabelValues(series.labels...)
+	}
+	if m.info != nil {
+		m.info.DeleteLabelValues(series.info...)
+	}
+	delete(m.series, mac)
+}
+
+// valueVecs returns all client metrics which share the client value series labels.
+func (m *ClientMetrics) valueVecs() []*gaugeVec {
+	return []*gaugeVec{
+		m.channel,
+		m.dualBandCapable,
+		m.idleSeconds,
+		m.isGuest,
+		m.lastSeenTimestamp,
+		m.locatingAps,
+		m.powerSavingModeActive,
+		m.rssiDbm,
+		m.receiveBps,
+		m.receiveBytesTotal,
+		m.receivePacketsTotal,
+		m.receiveRateMbps,
+		m.receiveRetriesTotal,
+		m.snrDb,
+		m.transmitBps,
+		m.transmitBytesTotal,
+		m.transmitPacketsTotal,
+		m.transmitRateMbps,
+		m.transmitRetriesTotal,
+		m.uptimeSeconds,
+	}
 }
@@ -0,0 +1,162 @@
+package metrics
+
+import (
+	"bytes"
+	"encoding/json"
+	"slices"
+	"sync"
+	"time"
+)
+
+// redacted replaces the client identifiers of recorded client messages.
+const redacted = "*****"
+
+// redactedClientFields are the fields of a client stat message which identify the client
+// and its user, the network and PSK it was assigned and its location. Nested fields are
+// given by the path of their keys.
+var redactedClientFields = [][]string{
+	{"mac"},
+	{"username"},
+	{"hostname"},
+	{"ip"},
+	{"ip6"},
+	{"wlan_id"},
+	{"psk_id"},
+	{"vlan_id"},
+	{"map_id"},
+	{"x"},
+	{"y"},
+	{"x_m"},
+	{"y_m"},
+	{"guest", "name"},
+	{"guest", "email"},
+	{"guest", "company"},
+	{"guest", "field1"},
+}
+
+// StreamMessage is a raw message received from the stream of a site, recorded for debugging.
+type StreamMessage struct {
+	Received time.Time       `json:"received"`
+	Message  json.RawMessage `json:"message"`
+}
+
+// StreamMessages holds the last raw messages received from the stream of a site, most recent first.
+type StreamMessages struct {
+	SiteID      string          `json:"site_id"`
+	SiteName    string          `json:"site_name"`
+	DeviceStats []StreamMessage `json:"device_stats"`
+	ClientStats []StreamMessage `json:"client_stats"`
+}
+
+// StreamMessages returns the last raw messages received from the stream of the site with
+// the given ID or name, reporting whether the site has a stream. No messages are recorded
+// unless collector.stream_debug.messages is set.
+func (c *MistMetrics) StreamMessages(site string) (StreamMessages, bool) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	streamer, ok := c.sites[site]
+	if !ok {
+		for _, s := range c.sites {
+			if s.site.Name == site {
+				streamer, ok = s, true
+				break
+			}
+		}
+	}
+	if !ok {
+		return StreamMessages{}, false
+	}
+
+	return StreamMessages{
+		SiteID:      streamer.site.ID,
+		SiteName:    streamer.site.Name,
+		DeviceStats: streamer.deviceMessages.list(),
+		ClientStats: streamer.clientMessages.list(),
+	}, true
+}
+
+// messageLog holds the last raw messages received from a stream, up to its size, with
+// client identifiers removed if redactClients is set. A nil log records nothing.
+type messageLog struct {
+	mu            sync.Mutex
+	size          int
+	redactClients bool
+	messages      []StreamMessage
+}
+
+func newMessageLog(size int, redactClients bool) *messageLog {
+	if size <= 0 {
+		return nil
+	}
+	return &messageLog{size: size, redactClients: redactClients}
+}
+
+// add records the raw data of a message received from the stream.
+func (l *messageLog) add(data string) {
+	if l == nil {
+		return
+	}
+
+	message := json.RawMessage(data)
+	switch {
+	case l.redactClients:
+		message = redactClientMessage(data)
+	case !json.Valid(message):
+		// Data which is not JSON is recorded as a string so that it can still be served.
+		message, _ = json.Marshal(data)
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if len(l.messages) == l.size {
+		l.messages = slices.Delete(l.messages, 0, 1)
+	}
+	l.messages = append(l.messages, StreamMessage{Received: time.Now(), Message: message})
+}
+
+// list returns the recorded messages, most recent first.
+func (l *messageLog) list() []StreamMessage {
+	if l == nil {
+		return []StreamMessage{}
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	messages := append(make([]StreamMessage, 0, len(l.messages)), l.messages...)
+	slices.Reverse(messages)
+	return messages
+}
+
+// redactClientMessage returns the raw data of a client stat message with the fields listed
+// in redactedClientFields replaced. All other fields, including those the exporter does not
+// decode, are kept as received. Data which cannot be parsed is replaced entirely, as its
+// identifiers cannot be found.
+func redactClientMessage(data string) json.RawMessage {
+	d := json.NewDecoder(bytes.NewReader([]byte(data)))
+	d.UseNumber()
+	var stat map[string]any
+	if err := d.Decode(&stat); err != nil {
+		message, _ := json.Marshal(redacted)
+		return message
+	}
+
+	for _, path := range redactedClientFields {
+		fields := stat
+		for _, key := range path[:len(path)-1] {
+			fields, _ = fields[key].(map[string]any)
+		}
+		key := path[len(path)-1]
+		if value, ok := fields[key]; ok && value != nil && value != "" {
+			fields[key] = redacted
+		}
+	}
+
+	message, err := json.Marshal(stat)
+	if err != nil {
+		message, _ = json.Marshal(redacted)
+	}
+	return message
+}
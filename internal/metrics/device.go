@@ -1,27 +1,52 @@
 package metrics
 
 import (
+	"sync"
+	"time"
+
 	"github.com/gregwight/mistclient"
+	"github.com/gregwight/mistexporter/internal/filter"
 	"github.com/prometheus/client_golang/prometheus"
 )
 
-// DeviceLabelNames defines the labels attached to device metrics.
-var DeviceLabelNames = append(SiteLabelNames,
-	"device_name",
-	"device_mac",
-	"device_model",
-	"device_hw_rev",
-)
+// DeviceLabelNames returns the labels attached to device metrics.
+func DeviceLabelNames() []string {
+	return append(SiteLabelNames(),
+		"device_name",
+		"device_mac",
+		"device_model",
+		"device_hw_rev",
+	)
+}
 
-// StreamedDeviceLabelNames defines the labels attached to streamed device metrics.
-var StreamedDeviceLabelNames = append(SiteLabelNames,
-	"device_name",
-	"device_mac",
-	"device_version",
-)
+// StreamedDeviceLabelNames returns the labels attached to streamed device metrics.
+func StreamedDeviceLabelNames() []string {
+	return append(SiteLabelNames(),
+		"device_name",
+		"device_mac",
+		"device_version",
+	)
+}
 
-// StreamedDeviceWithRadioLabelNames defines the labels attached to radio-specific device metrics.
-var StreamedDeviceWithRadioLabelNames = append(StreamedDeviceLabelNames, "radio")
+// StreamedDeviceIdentityLabelNames returns the labels attached to streamed device metrics
+// which must remain stable across firmware upgrades, such as counters.
+func StreamedDeviceIdentityLabelNames() []string {
+	return append(SiteLabelNames(),
+		"device_name",
+		"device_mac",
+	)
+}
+
+// StreamedDeviceWithRadioLabelNames returns the labels attached to radio-specific device metrics.
+func StreamedDeviceWithRadioLabelNames() []string {
+	return append(StreamedDeviceLabelNames(), "radio")
+}
+
+// StreamedDeviceIdentityWithRadioLabelNames returns the labels attached to radio-specific
+// device metrics which must remain stable across firmware upgrades.
+func StreamedDeviceIdentityWithRadioLabelNames() []string {
+	return append(StreamedDeviceIdentityLabelNames(), "radio")
+}
 
 // DeviceLabelValues generates label values for device metrics.
 func DeviceLabelValues(s mistclient.Site, d mistclient.Device) []string {
@@ -38,203 +63,310 @@ func StreamedDeviceLabelValues(s mistclient.Site, deviceName string, ds mistclie
 	return append(SiteLabelValues(s), deviceName, ds.Mac, ds.Version)
 }
 
+// StreamedDeviceIdentityLabelValues generates label values for streamed device metrics which
+// must remain stable across firmware upgrades.
+func StreamedDeviceIdentityLabelValues(s mistclient.Site, deviceName string, ds mistclient.StreamedDeviceStat) []string {
+	return append(SiteLabelValues(s), deviceName, ds.Mac)
+}
+
 // DeviceWithRadioLabelValues generates label values for radio-specific device metrics.
 func DeviceWithRadioLabelValues(s mistclient.Site, deviceName string, ds mistclient.StreamedDeviceStat, radio string) []string {
 	return append(StreamedDeviceLabelValues(s, deviceName, ds), radio)
 }
 
-var deviceMetrics *DeviceMetrics
+// DeviceIdentityWithRadioLabelValues generates label values for radio-specific device metrics
+// which must remain stable across firmware upgrades.
+func DeviceIdentityWithRadioLabelValues(s mistclient.Site, deviceName string, ds mistclient.StreamedDeviceStat, radio string) []string {
+	return append(StreamedDeviceIdentityLabelValues(s, deviceName, ds), radio)
+}
+
+var (
+	deviceMetrics *DeviceMetrics
+	deviceFilter  *filter.DeviceFilter
+)
 
 // DeviceMetrics holds metrics related to devices.
 type DeviceMetrics struct {
-	cpuUtilizationSystem    *prometheus.GaugeVec
-	cpuUtilizationIdle      *prometheus.GaugeVec
-	cpuUtilizationInterrupt *prometheus.GaugeVec
-	cpuUtilizationUser      *prometheus.GaugeVec
-	lastSeenTimestamp       *prometheus.GaugeVec
-	loadAverage1m           *prometheus.GaugeVec
-	loadAverage5m           *prometheus.GaugeVec
-	loadAverage15m          *prometheus.GaugeVec
-	memoryUtilization       *prometheus.GaugeVec
-	receiveBps              *prometheus.GaugeVec
-	transmitBps             *prometheus.GaugeVec
-	uptimeSeconds           *prometheus.GaugeVec
+	cpuUtilizationSystem    *gaugeVec
+	cpuUtilizationIdle      *gaugeVec
+	cpuUtilizationInterrupt *gaugeVec
+	cpuUtilizationUser      *gaugeVec
+	lastSeenTimestamp       *gaugeVec
+	loadAverage1m           *gaugeVec
+	loadAverage5m           *gaugeVec
+	loadAverage15m          *gaugeVec
+	memoryUtilization       *gaugeVec
+	receiveBps              *gaugeVec
+	transmitBps             *gaugeVec
+	uptimeSeconds           *gaugeVec
+
+	// Derived metrics
+	lastRebootTimestamp *gaugeVec
+	rebootsTotal        *counterVec
 
 	// Radio metrics
-	radioBandwidthMhz     *prometheus.GaugeVec
-	radioChannel          *prometheus.GaugeVec
-	radioClients          *prometheus.GaugeVec
-	radioTransmitPowerDbm *prometheus.GaugeVec
-	radioReceiveBytes     *prometheus.GaugeVec
-	radioReceivePackets   *prometheus.GaugeVec
-	radioTransmitBytes    *prometheus.GaugeVec
-	radioTransmitPackets  *prometheus.GaugeVec
+	radioBandwidthMhz     *gaugeVec
+	radioChannel          *gaugeVec
+	radioClients          *gaugeVec
+	radioTransmitPowerDbm *gaugeVec
+	radioReceiveBytes     *gaugeVec
+	radioReceivePackets   *gaugeVec
+	radioTransmitBytes    *gaugeVec
+	radioTransmitPackets  *gaugeVec
+
+	// Derived radio metrics
+	radioChannelChangesTotal *counterVec
+	radioPowerChangesTotal   *counterVec
+
+	// mu guards state, which holds the previously observed stat values
+	// of each device, keyed by device MAC, and the time it was last pruned.
+	mu     sync.Mutex
+	state  map[string]*deviceState
+	pruned time.Time
+}
+
+// The state of devices which have not been updated within deviceStateTTL is forgotten,
+// checking at most once every deviceStatePruneInterval. The TTL is long enough that a
+// reboot is still detected for a device which has been offline for some hours.
+const (
+	deviceStateTTL           = 24 * time.Hour
+	deviceStatePruneInterval = time.Hour
+)
+
+// deviceState holds the values from a device's previous stat required to
+// detect changes between updates.
+type deviceState struct {
+	uptime time.Duration
+	radios map[mistclient.RadioConfig]mistclient.StreamedRadioStat
+	seen   time.Time
 }
 
 func newDeviceMetrics(reg *prometheus.Registry) *DeviceMetrics {
 	m := &DeviceMetrics{
-		cpuUtilizationSystem: prometheus.NewGaugeVec(
+		cpuUtilizationSystem: newGaugeVec(
 			prometheus.GaugeOpts{
-				Namespace: "mist",
-				Subsystem: "device",
-				Name:      "cpu_utilization_system_percent",
-				Help:      "Current system CPU utilization of the device.",
-			}, StreamedDeviceLabelNames,
+				Namespace:   Namespace(),
+				Subsystem:   "device",
+				Name:        "cpu_utilization_system_percent",
+				Help:        "Current system CPU utilization of the device.",
+				ConstLabels: ConstLabels(),
+			}, StreamedDeviceLabelNames(),
 		),
-		cpuUtilizationIdle: prometheus.NewGaugeVec(
+		cpuUtilizationIdle: newGaugeVec(
 			prometheus.GaugeOpts{
-				Namespace: "mist",
-				Subsystem: "device",
-				Name:      "cpu_utilization_idle_percent",
-				Help:      "Current idle CPU utilization of the device.",
-			}, StreamedDeviceLabelNames,
+				Namespace:   Namespace(),
+				Subsystem:   "device",
+				Name:        "cpu_utilization_idle_percent",
+				Help:        "Current idle CPU utilization of the device.",
+				ConstLabels: ConstLabels(),
+			}, StreamedDeviceLabelNames(),
 		),
-		cpuUtilizationInterrupt: prometheus.NewGaugeVec(
+		cpuUtilizationInterrupt: newGaugeVec(
 			prometheus.GaugeOpts{
-				Namespace: "mist",
-				Subsystem: "device",
-				Name:      "cpu_utilization_interrupt_percent",
-				Help:      "Current interrupt CPU utilization of the device.",
-			}, StreamedDeviceLabelNames,
+				Namespace:   Namespace(),
+				Subsystem:   "device",
+				Name:        "cpu_utilization_interrupt_percent",
+				Help:        "Current interrupt CPU utilization of the device.",
+				ConstLabels: ConstLabels(),
+			}, StreamedDeviceLabelNames(),
 		),
-		cpuUtilizationUser: prometheus.NewGaugeVec(
+		cpuUtilizationUser: newGaugeVec(
 			prometheus.GaugeOpts{
-				Namespace: "mist",
-				Subsystem: "device",
-				Name:      "cpu_utilization_user_percent",
-				Help:      "Current user CPU utilization of the device.",
-			}, StreamedDeviceLabelNames,
+				Namespace:   Namespace(),
+				Subsystem:   "device",
+				Name:        "cpu_utilization_user_percent",
+				Help:        "Current user CPU utilization of the device.",
+				ConstLabels: ConstLabels(),
+			}, StreamedDeviceLabelNames(),
 		),
-		lastSeenTimestamp: prometheus.NewGaugeVec(
+		lastSeenTimestamp: newGaugeVec(
 			prometheus.GaugeOpts{
-				Namespace: "mist",
-				Subsystem: "device",
-				Name:      "last_seen_timestamp_seconds",
-				Help:      "The last time the device was seen, as a Unix timestamp.",
-			}, StreamedDeviceLabelNames,
+				Namespace:   Namespace(),
+				Subsystem:   "device",
+				Name:        "last_seen_timestamp_seconds",
+				Help:        "The last time the device was seen, as a Unix timestamp.",
+				ConstLabels: ConstLabels(),
+			}, StreamedDeviceLabelNames(),
 		),
-		loadAverage1m: prometheus.NewGaugeVec(
+		loadAverage1m: newGaugeVec(
 			prometheus.GaugeOpts{
-				Namespace: "mist",
-				Subsystem: "device",
-				Name:      "load_average_1m",
-				Help:      "Current 1m load average of the device.",
-			}, StreamedDeviceLabelNames,
+				Namespace:   Namespace(),
+				Subsystem:   "device",
+				Name:        "load_average_1m",
+				Help:        "Current 1m load average of the device.",
+				ConstLabels: ConstLabels(),
+			}, StreamedDeviceLabelNames(),
 		),
-		loadAverage5m: prometheus.NewGaugeVec(
+		loadAverage5m: newGaugeVec(
 			prometheus.GaugeOpts{
-				Namespace: "mist",
-				Subsystem: "device",
-				Name:      "load_average_5m",
-				Help:      "Current 5m load average of the device.",
-			}, StreamedDeviceLabelNames,
+				Namespace:   Namespace(),
+				Subsystem:   "device",
+				Name:        "load_average_5m",
+				Help:        "Current 5m load average of the device.",
+				ConstLabels: ConstLabels(),
+			}, StreamedDeviceLabelNames(),
 		),
-		loadAverage15m: prometheus.NewGaugeVec(
+		loadAverage15m: newGaugeVec(
 			prometheus.GaugeOpts{
-				Namespace: "mist",
-				Subsystem: "device",
-				Name:      "load_average_15m",
-				Help:      "Current 15m load average of the device.",
-			}, StreamedDeviceLabelNames,
+				Namespace:   Namespace(),
+				Subsystem:   "device",
+				Name:        "load_average_15m",
+				Help:        "Current 15m load average of the device.",
+				ConstLabels: ConstLabels(),
+			}, StreamedDeviceLabelNames(),
 		),
-		memoryUtilization: prometheus.NewGaugeVec(
+		memoryUtilization: newGaugeVec(
 			prometheus.GaugeOpts{
-				Namespace: "mist",
-				Subsystem: "device",
-				Name:      "memory_utilization_percent",
-				Help:      "Current memory utilization of the device.",
-			}, StreamedDeviceLabelNames,
+				Namespace:   Namespace(),
+				Subsystem:   "device",
+				Name:        "memory_utilization_percent",
+				Help:        "Current memory utilization of the device.",
+				ConstLabels: ConstLabels(),
+			}, StreamedDeviceLabelNames(),
 		),
-		receiveBps: prometheus.NewGaugeVec(
+		receiveBps: newGaugeVec(
 			prometheus.GaugeOpts{
-				Namespace: "mist",
-				Subsystem: "device",
-				Name:      "receive_bits_per_second",
-				Help:      "Bits per second received by the device.",
-			}, StreamedDeviceLabelNames,
+				Namespace:   Namespace(),
+				Subsystem:   "device",
+				Name:        "receive_bits_per_second",
+				Help:        "Bits per second received by the device.",
+				ConstLabels: ConstLabels(),
+			}, StreamedDeviceLabelNames(),
 		),
-		transmitBps: prometheus.NewGaugeVec(
+		transmitBps: newGaugeVec(
 			prometheus.GaugeOpts{
-				Namespace: "mist",
-				Subsystem: "device",
-				Name:      "transmit_bits_per_second",
-				Help:      "Bits per second transmitted by the device.",
-			}, StreamedDeviceLabelNames,
+				Namespace:   Namespace(),
+				Subsystem:   "device",
+				Name:        "transmit_bits_per_second",
+				Help:        "Bits per second transmitted by the device.",
+				ConstLabels: ConstLabels(),
+			}, StreamedDeviceLabelNames(),
 		),
-		uptimeSeconds: prometheus.NewGaugeVec(
+		uptimeSeconds: newGaugeVec(
 			prometheus.GaugeOpts{
-				Namespace: "mist",
-				Subsystem: "device",
-				Name:      "uptime_seconds",
-				Help:      "Device uptime in seconds.",
-			}, StreamedDeviceLabelNames,
+				Namespace:   Namespace(),
+				Subsystem:   "device",
+				Name:        "uptime_seconds",
+				Help:        "Device uptime in seconds.",
+				ConstLabels: ConstLabels(),
+			}, StreamedDeviceLabelNames(),
+		),
+
+		// Derived metrics
+		lastRebootTimestamp: newGaugeVec(
+			prometheus.GaugeOpts{
+				Namespace:   Namespace(),
+				Subsystem:   "device",
+				Name:        "last_reboot_timestamp_seconds",
+				Help:        "The time the device last booted, derived from its uptime, as a Unix timestamp.",
+				ConstLabels: ConstLabels(),
+			}, StreamedDeviceIdentityLabelNames(),
+		),
+		rebootsTotal: newCounterVec(
+			prometheus.CounterOpts{
+				Namespace:   Namespace(),
+				Subsystem:   "device",
+				Name:        "reboots_total",
+				Help:        "Number of device reboots detected from a decrease in uptime between updates.",
+				ConstLabels: ConstLabels(),
+			}, StreamedDeviceIdentityLabelNames(),
 		),
 
 		// Radio metrics
-		radioBandwidthMhz: prometheus.NewGaugeVec(
+		radioBandwidthMhz: newGaugeVec(
 			prometheus.GaugeOpts{
-				Namespace: "mist",
-				Subsystem: "device",
-				Name:      "radio_bandwidth_mhz",
-				Help:      "Radio channel bandwidth in MHz.",
-			}, StreamedDeviceWithRadioLabelNames,
+				Namespace:   Namespace(),
+				Subsystem:   "device",
+				Name:        "radio_bandwidth_mhz",
+				Help:        "Radio channel bandwidth in MHz.",
+				ConstLabels: ConstLabels(),
+			}, StreamedDeviceWithRadioLabelNames(),
 		),
-		radioChannel: prometheus.NewGaugeVec(
+		radioChannel: newGaugeVec(
 			prometheus.GaugeOpts{
-				Namespace: "mist",
-				Subsystem: "device",
-				Name:      "radio_channel",
-				Help:      "The current radio channel.",
-			}, StreamedDeviceWithRadioLabelNames,
+				Namespace:   Namespace(),
+				Subsystem:   "device",
+				Name:        "radio_channel",
+				Help:        "The current radio channel.",
+				ConstLabels: ConstLabels(),
+			}, StreamedDeviceWithRadioLabelNames(),
 		),
-		radioClients: prometheus.NewGaugeVec(
+		radioClients: newGaugeVec(
 			prometheus.GaugeOpts{
-				Namespace: "mist",
-				Subsystem: "device",
-				Name:      "radio_clients",
-				Help:      "Number of clients connected to this radio.",
-			}, StreamedDeviceWithRadioLabelNames,
+				Namespace:   Namespace(),
+				Subsystem:   "device",
+				Name:        "radio_clients",
+				Help:        "Number of clients connected to this radio.",
+				ConstLabels: ConstLabels(),
+			}, StreamedDeviceWithRadioLabelNames(),
 		),
-		radioTransmitPowerDbm: prometheus.NewGaugeVec(
+		radioTransmitPowerDbm: newGaugeVec(
 			prometheus.GaugeOpts{
-				Namespace: "mist",
-				Subsystem: "device",
-				Name:      "radio_transmit_power_dbm",
-				Help:      "The radio's transmit power in dBm.",
-			}, StreamedDeviceWithRadioLabelNames,
+				Namespace:   Namespace(),
+				Subsystem:   "device",
+				Name:        "radio_transmit_power_dbm",
+				Help:        "The radio's transmit power in dBm.",
+				ConstLabels: ConstLabels(),
+			}, StreamedDeviceWithRadioLabelNames(),
 		),
-		radioReceiveBytes: prometheus.NewGaugeVec(
+		radioReceiveBytes: newGaugeVec(
 			prometheus.GaugeOpts{
-				Namespace: "mist",
-				Subsystem: "device",
-				Name:      "radio_receive_bytes",
-				Help:      " bytes received by the radio.",
-			}, StreamedDeviceWithRadioLabelNames,
+				Namespace:   Namespace(),
+				Subsystem:   "device",
+				Name:        "radio_receive_bytes",
+				Help:        " bytes received by the radio.",
+				ConstLabels: ConstLabels(),
+			}, StreamedDeviceWithRadioLabelNames(),
 		),
-		radioReceivePackets: prometheus.NewGaugeVec(
+		radioReceivePackets: newGaugeVec(
 			prometheus.GaugeOpts{
-				Namespace: "mist",
-				Subsystem: "device",
-				Name:      "radio_receive_packets",
-				Help:      " packets received by the radio.",
-			}, StreamedDeviceWithRadioLabelNames,
+				Namespace:   Namespace(),
+				Subsystem:   "device",
+				Name:        "radio_receive_packets",
+				Help:        " packets received by the radio.",
+				ConstLabels: ConstLabels(),
+			}, StreamedDeviceWithRadioLabelNames(),
 		),
-		radioTransmitBytes: prometheus.NewGaugeVec(
+		radioTransmitBytes: newGaugeVec(
 			prometheus.GaugeOpts{
-				Namespace: "mist",
-				Subsystem: "device",
-				Name:      "radio_transmit_bytes",
-				Help:      " bytes transmitted by the radio.",
-			}, StreamedDeviceWithRadioLabelNames,
+				Namespace:   Namespace(),
+				Subsystem:   "device",
+				Name:        "radio_transmit_bytes",
+				Help:        " bytes transmitted by the radio.",
+				ConstLabels: ConstLabels(),
+			}, StreamedDeviceWithRadioLabelNames(),
 		),
-		radioTransmitPackets: prometheus.NewGaugeVec(
+		radioTransmitPackets: newGaugeVec(
 			prometheus.GaugeOpts{
-				Namespace: "mist",
-				Subsystem: "device",
-				Name:      "radio_transmit_packets",
-				Help:      " packets transmitted by the radio.",
-			}, StreamedDeviceWithRadioLabelNames,
+				Namespace:   Namespace(),
+				Subsystem:   "device",
+				Name:        "radio_transmit_packets",
+				Help:        " packets transmitted by the radio.",
+				ConstLabels: ConstLabels(),
+			}, StreamedDeviceWithRadioLabelNames(),
 		),
+
+		// Derived radio metrics
+		radioChannelChangesTotal: newCounterVec(
+			prometheus.CounterOpts{
+				Namespace:   Namespace(),
+				Subsystem:   "device",
+				Name:        "radio_channel_changes_total",
+				Help:        "Number of radio channel changes detected between updates.",
+				ConstLabels: ConstLabels(),
+			}, StreamedDeviceIdentityWithRadioLabelNames(),
+		),
+		radioPowerChangesTotal: newCounterVec(
+			prometheus.CounterOpts{
+				Namespace:   Namespace(),
+				Subsystem:   "device",
+				Name:        "radio_power_changes_total",
+				Help:        "Number of radio transmit power changes detected between updates.",
+				ConstLabels: ConstLabels(),
+			}, StreamedDeviceIdentityWithRadioLabelNames(),
+		),
+
+		state: make(map[string]*deviceState),
 	}
 
 	reg.MustRegister(
@@ -258,6 +390,10 @@ func newDeviceMetrics(reg *prometheus.Registry) *DeviceMetrics {
 		m.radioTransmitBytes,
 		m.radioTransmitPackets,
 		m.uptimeSeconds,
+		m.lastRebootTimestamp,
+		m.rebootsTotal,
+		m.radioChannelChangesTotal,
+		m.radioPowerChangesTotal,
 	)
 
 	return m
@@ -284,6 +420,8 @@ func handleSiteDeviceStat(site mistclient.Site, deviceName string, stat mistclie
 	deviceMetrics.transmitBps.WithLabelValues(labels...).Set(float64(stat.TxBps))
 	deviceMetrics.uptimeSeconds.WithLabelValues(labels...).Set(stat.Uptime.Seconds())
 
+	deviceMetrics.observeUptime(StreamedDeviceIdentityLabelValues(site, deviceName, stat), stat)
+
 	// Radio metrics
 	for radioConfig, radioStat := range stat.RadioStats {
 		labels := DeviceWithRadioLabelValues(site, deviceName, stat, radioConfig.String())
@@ -296,5 +434,94 @@ func handleSiteDeviceStat(site mistclient.Site, deviceName string, stat mistclie
 		deviceMetrics.radioReceivePackets.WithLabelValues(labels...).Set(float64(radioStat.RxPkts))
 		deviceMetrics.radioTransmitBytes.WithLabelValues(labels...).Set(float64(radioStat.TxBytes))
 		deviceMetrics.radioTransmitPackets.WithLabelValues(labels...).Set(float64(radioStat.TxPkts))
+
+		deviceMetrics.observeRadio(DeviceIdentityWithRadioLabelValues(site, deviceName, stat, radioConfig.String()), stat.Mac, radioConfig, radioStat)
+	}
+}
+
+// observeUptime compares a device's uptime against the previously observed value
+// and records a reboot if it has decreased.
+func (m *DeviceMetrics) observeUptime(labels []string, stat mistclient.StreamedDeviceStat) {
+	uptime := time.Duration(stat.Uptime)
+
+	// Not every update carries an uptime, and a missing value must not be
+	// mistaken for a reboot.
+	if uptime <= 0 {
+		return
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	state := m.stateOf(stat.Mac)
+
+	switch {
+	case state.uptime == 0:
+		// Initialise the counter so the first detected reboot is visible to increase().
+		m.rebootsTotal.WithLabelValues(labels...)
+		m.lastRebootTimestamp.WithLabelValues(labels...).Set(float64(time.Now().Add(-uptime).Unix()))
+	case uptime < state.uptime:
+		m.rebootsTotal.WithLabelValues(labels...).Inc()
+		m.lastRebootTimestamp.WithLabelValues(labels...).Set(float64(time.Now().Add(-uptime).Unix()))
+	}
+
+	state.uptime = uptime
+}
+
+// observeRadio compares a radio's channel and transmit power against the previously
+// observed values and records any changes, such as those made by RRM.
+func (m *DeviceMetrics) observeRadio(labels []string, mac string, radioConfig mistclient.RadioConfig, radioStat mistclient.StreamedRadioStat) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	state := m.stateOf(mac)
+
+	prev, ok := state.radios[radioConfig]
+	if !ok {
+		// Initialise the counters so the first detected change is visible to increase().
+		m.radioChannelChangesTotal.WithLabelValues(labels...)
+		m.radioPowerChangesTotal.WithLabelValues(labels...)
+	} else {
+		// Zero values indicate the field was absent from the update rather than a change.
+		if radioStat.Channel != 0 && prev.Channel != 0 && radioStat.Channel != prev.Channel {
+			m.radioChannelChangesTotal.WithLabelValues(labels...).Inc()
+		}
+		if radioStat.Power != 0 && prev.Power != 0 && radioStat.Power != prev.Power {
+			m.radioPowerChangesTotal.WithLabelValues(labels...).Inc()
+		}
+	}
+
+	// Retain the last known values if absent from this update.
+	if radioStat.Channel == 0 {
+		radioStat.Channel = prev.Channel
+	}
+	if radioStat.Power == 0 {
+		radioStat.Power = prev.Power
+	}
+	state.radios[radioConfig] = radioStat
+}
+
+// stateOf returns the state of the device with the given MAC, creating it if
+// required, and marks the device as seen. The state of devices which have not been
+// seen within the TTL is forgotten. The caller must hold m.mu.
+func (m *DeviceMetrics) stateOf(mac string) *deviceState {
+	now := time.Now()
+	if now.Sub(m.pruned) >= deviceStatePruneInterval {
+		for mac, state := range m.state {
+			if now.Sub(state.seen) > deviceStateTTL {
+				delete(m.state, mac)
+			}
+		}
+		m.pruned = now
+	}
+
+	state, ok := m.state[mac]
+	if !ok {
+		state = &deviceState{
+			radios: make(map[mistclient.RadioConfig]mistclient.StreamedRadioStat),
+		}
+		m.state[mac] = state
 	}
+	state.seen = now
+	return state
 }
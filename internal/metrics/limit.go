@@ -0,0 +1,340 @@
+package metrics
+
+import (
+	"container/list"
+	"fmt"
+	"slices"
+	"strings"
+	"sync"
+
+	"github.com/gregwight/mistexporter/internal/config"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// Series limit policies, applied once a metric family reaches its limit.
+const (
+	// seriesLimitReject drops updates to new series, leaving existing series untouched.
+	seriesLimitReject = "reject"
+	// seriesLimitEvict deletes the least recently updated series to make room for the new one.
+	seriesLimitEvict = "evict"
+)
+
+// seriesLimits enforces the configured series limits on all Mist metric families.
+// When nil, no limits are enforced and no series are tracked.
+var seriesLimits *seriesLimiter
+
+// seriesLimiter tracks the series of each limited metric family, enforcing per-family
+// limits on their number and accounting for the series which are dropped as a result.
+// Families without a limit are not tracked. The aggregated families emitted by the
+// aggregateCollector are computed at scrape time and are not covered by the limits.
+type seriesLimiter struct {
+	defaultLimit int
+	limits       map[string]int
+	evict        bool
+
+	seriesDesc  *prometheus.Desc
+	droppedDesc *prometheus.Desc
+
+	mu       sync.RWMutex
+	families map[string]*seriesFamily
+}
+
+// seriesFamily holds the series of a single metric family, least recently updated first.
+// Under the reject policy, the label values of rejected series are remembered, up to the
+// limit, so that each rejected series is only counted as dropped once however often it
+// is updated. They are forgotten when a series is deleted, freeing space in the family.
+type seriesFamily struct {
+	limit  int
+	delete func(lvs ...string) bool
+
+	mu       sync.Mutex
+	order    *list.List
+	series   map[string]*list.Element
+	rejected map[string]struct{}
+	dropped  float64
+}
+
+func newSeriesLimiter(cfg *config.SeriesLimits) (*seriesLimiter, error) {
+	l := &seriesLimiter{
+		limits:   make(map[string]int),
+		families: make(map[string]*seriesFamily),
+		seriesDesc: prometheus.NewDesc(
+			FQName("exporter_series"),
+			"Number of series currently exported for each Mist metric family with a series limit.",
+			[]string{"family"},
+			ConstLabels(),
+		),
+		droppedDesc: prometheus.NewDesc(
+			FQName("exporter_series_dropped_total"),
+			"Number of series dropped because their metric family reached its series limit.",
+			[]string{"family"},
+			ConstLabels(),
+		),
+	}
+	if cfg == nil {
+		return l, nil
+	}
+
+	switch cfg.Policy {
+	case "", seriesLimitReject:
+	case seriesLimitEvict:
+		l.evict = true
+	default:
+		return nil, fmt.Errorf("invalid series limit policy %q, must be one of %q or %q", cfg.Policy, seriesLimitReject, seriesLimitEvict)
+	}
+	if cfg.Default < 0 {
+		return nil, fmt.Errorf("invalid default series limit %d, must not be negative", cfg.Default)
+	}
+	l.defaultLimit = cfg.Default
+	for family, limit := range cfg.Families {
+		if limit < 0 {
+			return nil, fmt.Errorf("invalid series limit %d for %q, must not be negative", limit, family)
+		}
+		l.limits[family] = limit
+	}
+
+	return l, nil
+}
+
+// register adds a metric family to the limiter. The delete function is used to remove
+// evicted series from the family.
+func (l *seriesLimiter) register(family string, delete func(lvs ...string) bool) {
+	if l == nil {
+		return
+	}
+
+	limit, ok := l.limits[family]
+	if !ok {
+		limit = l.defaultLimit
+	}
+	if limit == 0 {
+		return
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.families[family] = &seriesFamily{
+		limit:    limit,
+		delete:   delete,
+		order:    list.New(),
+		series:   make(map[string]*list.Element),
+		rejected: make(map[string]struct{}),
+	}
+}
+
+// family returns the tracked metric family, or nil if the family has no limit.
+func (l *seriesLimiter) family(family string) *seriesFamily {
+	if l == nil {
+		return nil
+	}
+
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+
+	return l.families[family]
+}
+
+// admit reports whether the series with the given label values may be updated,
+// evicting the least recently updated series of the family if required.
+func (l *seriesLimiter) admit(family string, lvs []string) bool {
+	f := l.family(family)
+	if f == nil {
+		return true
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	key := strings.Join(lvs, "\xff")
+	if e, ok := f.series[key]; ok {
+		f.order.MoveToBack(e)
+		return true
+	}
+
+	if len(f.series) >= f.limit {
+		if !l.evict {
+			if _, ok := f.rejected[key]; !ok {
+				if len(f.rejected) >= f.limit {
+					clear(f.rejected)
+				}
+				f.rejected[key] = struct{}{}
+				f.dropped++
+			}
+			return false
+		}
+		f.dropped++
+		oldest := f.order.Front()
+		evicted := f.order.Remove(oldest).([]string)
+		delete(f.series, strings.Join(evicted, "\xff"))
+		f.delete(evicted...)
+	}
+
+	f.series[key] = f.order.PushBack(slices.Clone(lvs))
+	return true
+}
+
+// forgetMatching stops tracking every series of the family whose label values match.
+func (l *seriesLimiter) forgetMatching(family string, match func(lvs []string) bool) {
+	f := l.family(family)
+	if f == nil {
+		return
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	for e := f.order.Front(); e != nil; {
+		next := e.Next()
+		if lvs := e.Value.([]string); match(lvs) {
+			f.order.Remove(e)
+			delete(f.series, strings.Join(lvs, "\xff"))
+			clear(f.rejected)
+		}
+		e = next
+	}
+}
+
+// forget stops tracking the series with the given label values.
+func (l *seriesLimiter) forget(family string, lvs []string) {
+	f := l.family(family)
+	if f == nil {
+		return
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	key := strings.Join(lvs, "\xff")
+	if e, ok := f.series[key]; ok {
+		f.order.Remove(e)
+		delete(f.series, key)
+		clear(f.rejected)
+	}
+}
+
+// Describe implements the prometheus.Collector interface.
+func (l *seriesLimiter) Describe(ch chan<- *prometheus.Desc) {
+	ch <- l.seriesDesc
+	ch <- l.droppedDesc
+}
+
+// Collect implements the prometheus.Collector interface.
+func (l *seriesLimiter) Collect(ch chan<- prometheus.Metric) {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+
+	for family, f := range l.families {
+		f.mu.Lock()
+		series, dropped := len(f.series), f.dropped
+		f.mu.Unlock()
+
+		ch <- prometheus.MustNewConstMetric(l.seriesDesc, prometheus.GaugeValue, float64(series), family)
+		ch <- prometheus.MustNewConstMetric(l.droppedDesc, prometheus.CounterValue, dropped, family)
+	}
+}
+
+// metricVec is the subset of the prometheus metric vector methods used by Mist metrics.
+type metricVec[T any] interface {
+	prometheus.Collector
+	WithLabelValues(lvs ...string) T
+	DeleteLabelValues(lvs ...string) bool
+	DeletePartialMatch(labels prometheus.Labels) int
+}
+
+// limitedVec wraps a prometheus metric vector, enforcing the series limit of its family.
+// Updates to series rejected by the limit are applied to an unregistered metric and
+// so are never exported.
+type limitedVec[T any] struct {
+	metricVec[T]
+	family     string
+	labelNames []string
+	limiter    *seriesLimiter
+	discard    T
+}
+
+type (
+	gaugeVec     = limitedVec[prometheus.Gauge]
+	counterVec   = limitedVec[prometheus.Counter]
+	histogramVec = limitedVec[prometheus.Observer]
+)
+
+func newLimitedVec[T any](family string, labelNames []string, vec metricVec[T], discard T) *limitedVec[T] {
+	seriesLimits.register(family, vec.DeleteLabelValues)
+
+	v := &limitedVec[T]{
+		metricVec:  vec,
+		family:     family,
+		labelNames: labelNames,
+		limiter:    seriesLimits,
+		discard:    discard,
+	}
+	registerSiteSeries(v)
+
+	return v
+}
+
+func newGaugeVec(opts prometheus.GaugeOpts, labelNames []string) *gaugeVec {
+	return newLimitedVec(
+		prometheus.BuildFQName(opts.Namespace, opts.Subsystem, opts.Name),
+		labelNames,
+		prometheus.NewGaugeVec(opts, labelNames),
+		prometheus.NewGauge(opts),
+	)
+}
+
+func newCounterVec(opts prometheus.CounterOpts, labelNames []string) *counterVec {
+	return newLimitedVec(
+		prometheus.BuildFQName(opts.Namespace, opts.Subsystem, opts.Name),
+		labelNames,
+		prometheus.NewCounterVec(opts, labelNames),
+		prometheus.NewCounter(opts),
+	)
+}
+
+func newHistogramVec(opts prometheus.HistogramOpts, labelNames []string) *histogramVec {
+	return newLimitedVec[prometheus.Observer](
+		prometheus.BuildFQName(opts.Namespace, opts.Subsystem, opts.Name),
+		labelNames,
+		prometheus.NewHistogramVec(opts, labelNames),
+		prometheus.NewHistogram(opts),
+	)
+}
+
+// WithLabelValues returns the metric for the given label values, or a discarded
+// metric if the series limit of the family has been reached.
+func (v *limitedVec[T]) WithLabelValues(lvs ...string) T {
+	if !v.limiter.admit(v.family, lvs) {
+		return v.discard
+	}
+	return v.metricVec.WithLabelValues(lvs...)
+}
+
+// DeleteLabelValues deletes the metric for the given label values.
+func (v *limitedVec[T]) DeleteLabelValues(lvs ...string) bool {
+	v.limiter.forget(v.family, lvs)
+	return v.metricVec.DeleteLabelValues(lvs...)
+}
+
+// DeletePartialMatch deletes the metrics whose labels include all of the given labels,
+// returning the number deleted.
+func (v *limitedVec[T]) DeletePartialMatch(labels prometheus.Labels) int {
+	indexes := make(map[int]string, len(labels))
+	for name, value := range labels {
+		i := slices.Index(v.labelNames, name)
+		if i < 0 {
+			return 0
+		}
+		indexes[i] = value
+	}
+
+	v.limiter.forgetMatching(v.family, func(lvs []string) bool {
+		for i, value := range indexes {
+			if lvs[i] != value {
+				return false
+			}
+		}
+		return true
+	})
+	return v.metricVec.DeletePartialMatch(labels)
+}
@@ -1,13 +1,18 @@
 package metrics
 
 import (
+	"cmp"
 	"context"
+	"encoding/json"
 	"fmt"
 	"log/slog"
+	"slices"
+	"strings"
 	"sync"
 	"time"
 
 	"github.com/gregwight/mistclient"
+	"github.com/gregwight/mistexporter/internal/config"
 	"github.com/gregwight/mistexporter/internal/filter"
 	"github.com/prometheus/client_golang/prometheus"
 )
@@ -19,36 +24,120 @@ type MistMetrics struct {
 	filter                   *filter.Filter
 	siteRefreshInterval      time.Duration
 	deviceNameRefreshnterval time.Duration
+	clientsCfg               *config.Clients
+	streamDebug              *config.StreamDebug
 	ready                    chan struct{}
 	reg                      *prometheus.Registry
 	logger                   *slog.Logger
 
-	mu          sync.RWMutex
-	sites       map[string]*StreamCollector
-	deviceNames map[string]string
+	mu                 sync.RWMutex
+	sites              map[string]*StreamCollector
+	deviceNames        map[string]string
+	deviceNamesUpdated time.Time
+	lastAPISuccess     time.Time
+	discovered         []SiteStatus
+	onStreamedSites    func([]mistclient.Site)
+	reloaded           chan struct{}
+}
+
+// Status is a snapshot of the state of the metrics streamer.
+type Status struct {
+	OrgID string
+	// DeviceNames is the number of entries in the device name map, and DeviceNamesUpdated
+	// the time it was last loaded, which is zero if it has never been loaded.
+	DeviceNames        int
+	DeviceNamesUpdated time.Time
+	// LastAPISuccess is the time of the last successful Mist API request.
+	LastAPISuccess time.Time
+	// Started reports whether the site streams have been started, which happens once the
+	// device name map and the site list have first been loaded.
+	Started bool
+	// Sites holds the sites discovered in the organization, ordered by name.
+	Sites []SiteStatus
+	// Streams holds the state of the stream of each site, ordered by site name.
+	Streams []StreamStatus
+}
+
+// SiteStatus describes a site discovered in the organization, and whether its metrics
+// are collected by the site filter.
+type SiteStatus struct {
+	ID       string
+	Name     string
+	Included bool
+}
+
+// StreamStatus is a snapshot of the state of the stream of a site.
+type StreamStatus struct {
+	SiteID      string
+	SiteName    string
+	Connected   bool
+	LastMessage time.Time
 }
 
 // New creates a new MistMetrics.
-func New(client *mistclient.APIClient, orgID string, siteFilter *filter.Filter, siteRefreshInterval time.Duration, deviceNameRefreshnterval time.Duration, reg *prometheus.Registry, logger *slog.Logger) (*MistMetrics, error) {
+func New(client *mistclient.APIClient, orgID string, siteFilter *filter.Filter, cfg *config.Collector, reg *prometheus.Registry, logger *slog.Logger) (*MistMetrics, error) {
 	if client == nil {
 		return nil, fmt.Errorf("client cannot be nil")
 	}
+	if cfg == nil {
+		return nil, fmt.Errorf("collector config cannot be nil")
+	}
 
+	clientLabels, err := newClientLabels(cfg.Clients.Labels)
+	if err != nil {
+		return nil, fmt.Errorf("invalid client labels: %w", err)
+	}
+	privacy, err := newPrivacyPolicy(cfg.Clients.Privacy)
+	if err != nil {
+		return nil, fmt.Errorf("invalid client privacy policy: %w", err)
+	}
+	if _, err := newClientTracker(cfg.Clients); err != nil {
+		return nil, fmt.Errorf("invalid client top N: %w", err)
+	}
+	deviceFilter, err = filter.NewDeviceFilter(cfg.DeviceFilter)
+	if err != nil {
+		return nil, fmt.Errorf("unable to initialize device filter: %w", err)
+	}
+	clientFilter, err = filter.NewClientFilter(cfg.ClientFilter)
+	if err != nil {
+		return nil, fmt.Errorf("unable to initialize client filter: %w", err)
+	}
+	seriesLimits, err = newSeriesLimiter(cfg.SeriesLimits)
+	if err != nil {
+		return nil, fmt.Errorf("invalid series limits: %w", err)
+	}
+	reg.MustRegister(seriesLimits)
+
+	resetSiteSeries()
 	deviceMetrics = newDeviceMetrics(reg)
-	clientMetrics = newClientMetrics(reg)
+	clientMetrics = nil
+	if cfg.Clients.PerClientMetrics {
+		clientMetrics = newClientMetrics(reg, clientLabels, privacy)
+	}
+
+	clientHistograms = nil
+	if cfg.Clients.Histograms.Enabled {
+		clientHistograms = newClientHistograms(reg, cfg.Clients.Histograms)
+	}
 
-	return &MistMetrics{
+	m := &MistMetrics{
 		client:                   client,
 		orgID:                    orgID,
 		filter:                   siteFilter,
-		siteRefreshInterval:      siteRefreshInterval,
-		deviceNameRefreshnterval: deviceNameRefreshnterval,
+		siteRefreshInterval:      cfg.SiteRefreshInterval,
+		deviceNameRefreshnterval: cfg.DeviceNameRefreshInterval,
+		clientsCfg:               cfg.Clients,
+		streamDebug:              cfg.StreamDebug,
 		ready:                    make(chan struct{}),
 		reg:                      reg,
 		logger:                   logger.With(slog.String("component", "metrics")),
 		sites:                    make(map[string]*StreamCollector),
 		deviceNames:              make(map[string]string),
-	}, nil
+		reloaded:                 make(chan struct{}),
+	}
+	reg.MustRegister(newAggregateCollector(m, cfg.Clients.BreakdownTopN))
+
+	return m, nil
 }
 
 func (c *MistMetrics) Run(ctx context.Context) error {
@@ -65,13 +154,17 @@ func (c *MistMetrics) Run(ctx context.Context) error {
 	go func() {
 		defer wg.Done()
 
-		ticker := time.NewTicker(c.deviceNameRefreshnterval)
+		interval, _, reloaded := c.intervals()
+		ticker := time.NewTicker(interval)
 		defer ticker.Stop()
 
 		for {
 			select {
 			case <-ctx.Done():
 				return
+			case <-reloaded:
+				interval, _, reloaded = c.intervals()
+				ticker.Reset(interval)
 			case <-ticker.C:
 				if err := c.updateDeviceNameMap(); err != nil {
 					c.logger.Error("unable to refresh org device names", "error", err)
@@ -84,13 +177,20 @@ func (c *MistMetrics) Run(ctx context.Context) error {
 	go func() {
 		defer wg.Done()
 
-		ticker := time.NewTicker(c.siteRefreshInterval)
+		_, interval, reloaded := c.intervals()
+		ticker := time.NewTicker(interval)
 		defer ticker.Stop()
 
 		for {
 			select {
 			case <-ctx.Done():
 				return
+			case <-reloaded:
+				_, interval, reloaded = c.intervals()
+				ticker.Reset(interval)
+				if err := c.manageSiteStreams(ctx, wg); err != nil {
+					c.logger.Error("unable to refresh site metric streams", "error", err)
+				}
 			case <-ticker.C:
 				if err := c.manageSiteStreams(ctx, wg); err != nil {
 					c.logger.Error("unable to refresh site metric streams", "error", err)
@@ -104,17 +204,75 @@ func (c *MistMetrics) Run(ctx context.Context) error {
 	return nil
 }
 
+// ApplyConfig applies a reloaded site filter and refresh intervals in place. Site streams
+// are started and stopped to match the new filter, leaving the streams of sites which
+// remain unfiltered connected.
+func (c *MistMetrics) ApplyConfig(siteFilter *filter.Filter, cfg *config.Collector) error {
+	if siteFilter == nil {
+		return fmt.Errorf("site filter cannot be nil")
+	}
+	if cfg == nil {
+		return fmt.Errorf("collector config cannot be nil")
+	}
+	if cfg.SiteRefreshInterval <= 0 {
+		return fmt.Errorf("invalid site refresh interval %v, must be positive", cfg.SiteRefreshInterval)
+	}
+	if cfg.DeviceNameRefreshInterval <= 0 {
+		return fmt.Errorf("invalid device name refresh interval %v, must be positive", cfg.DeviceNameRefreshInterval)
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.filter = siteFilter
+	c.siteRefreshInterval = cfg.SiteRefreshInterval
+	c.deviceNameRefreshnterval = cfg.DeviceNameRefreshInterval
+
+	// Wake the refresh loops so the new configuration takes effect immediately.
+	close(c.reloaded)
+	c.reloaded = make(chan struct{})
+
+	return nil
+}
+
+// intervals returns the current device name and site refresh intervals, along with a
+// channel which is closed when they are next changed by ApplyConfig.
+func (c *MistMetrics) intervals() (time.Duration, time.Duration, <-chan struct{}) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	return c.deviceNameRefreshnterval, c.siteRefreshInterval, c.reloaded
+}
+
+// SetClient replaces the Mist API client, e.g. after the API key has been rotated. The
+// streams of all sites are restarted so that they reconnect with the new client.
+func (c *MistMetrics) SetClient(client *mistclient.APIClient) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.client = client
+	for _, streamer := range c.sites {
+		streamer.setClient(client)
+	}
+}
+
 func (c *MistMetrics) updateDeviceNameMap() error {
 	c.logger.Debug("running org device name map updater...")
 	defer c.logger.Debug("org device name map updater finished")
 
-	devices, err := c.client.ListOrgDevices(c.orgID)
+	c.mu.RLock()
+	client := c.client
+	c.mu.RUnlock()
+
+	devices, err := client.ListOrgDevices(c.orgID)
 	if err != nil {
 		return fmt.Errorf("unable to fetch device list: %w", err)
 	}
 
 	c.mu.Lock()
 	c.deviceNames = devices
+	c.deviceNamesUpdated = time.Now()
+	c.lastAPISuccess = c.deviceNamesUpdated
 	c.mu.Unlock()
 
 	return nil
@@ -124,27 +282,52 @@ func (c *MistMetrics) manageSiteStreams(ctx context.Context, wg *sync.WaitGroup)
 	c.logger.Debug("running site metric stream manager...")
 	defer c.logger.Debug("site metric stream manager finished")
 
-	c.mu.Lock()
-	defer c.mu.Unlock()
+	// The site list is fetched without holding the lock so that scrapes and the
+	// status page are not blocked by the latency of the Mist API.
+	c.mu.RLock()
+	client := c.client
+	siteFilter := c.filter
+	onStreamedSites := c.onStreamedSites
+	c.mu.RUnlock()
 
-	sites, err := c.client.GetOrgSites(c.orgID)
+	sites, err := client.GetOrgSites(c.orgID)
 	if err != nil {
 		return fmt.Errorf("unable to fetch site list: %w", err)
 	}
+	fetched := time.Now()
 
-	activeSites := make(map[string]struct{})
+	discovered := make([]SiteStatus, 0, len(sites))
+	included := make([]mistclient.Site, 0, len(sites))
 	for _, site := range sites {
-		if isFiltered, err := c.filter.IsFiltered(site); err != nil {
+		isFiltered, err := siteFilter.IsFiltered(site)
+		discovered = append(discovered, SiteStatus{ID: site.ID, Name: site.Name, Included: err == nil && !isFiltered})
+		if err != nil {
 			c.logger.Error("unable to apply site filter to site", "site", site.Name, "error", err)
 			continue
 		} else if isFiltered {
 			continue
 		}
+		included = append(included, site)
+	}
+
+	// Site attributes fetched from the API, such as site variables, are loaded
+	// before the streams of newly included sites are started.
+	if onStreamedSites != nil {
+		onStreamedSites(included)
+	}
 
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.lastAPISuccess = fetched
+	c.discovered = discovered
+
+	activeSites := make(map[string]struct{})
+	for _, site := range included {
 		activeSites[site.ID] = struct{}{}
 		streamer, ok := c.sites[site.ID]
 		if !ok {
-			streamer = newStreamCollector(
+			streamer, err = newStreamCollector(
 				c.client,
 				site,
 				func(mac string) string {
@@ -154,8 +337,15 @@ func (c *MistMetrics) manageSiteStreams(ctx context.Context, wg *sync.WaitGroup)
 					// check if the key exists - missing macs will get an empty label.
 					return c.deviceNames[mac]
 				},
+				c.deviceNameRefreshnterval,
+				c.clientsCfg,
+				c.streamDebug,
 				c.logger,
 			)
+			if err != nil {
+				c.logger.Error("unable to create site metrics stream", "site", site.Name, "error", err)
+				continue
+			}
 			c.sites[site.ID] = streamer
 		}
 
@@ -177,74 +367,249 @@ func (c *MistMetrics) manageSiteStreams(ctx context.Context, wg *sync.WaitGroup)
 	return nil
 }
 
+// OnStreamedSites registers a function which is called with the sites whose metrics are
+// streamed each time the site list is refreshed, before the streams of any newly included
+// sites are started. It must be called before Run.
+func (c *MistMetrics) OnStreamedSites(fn func([]mistclient.Site)) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.onStreamedSites = fn
+}
+
 func (c *MistMetrics) Ready() <-chan struct{} {
 	return c.ready
 }
 
+// Status returns a snapshot of the state of the metrics streamer.
+func (c *MistMetrics) Status() Status {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	status := Status{
+		OrgID:              c.orgID,
+		DeviceNames:        len(c.deviceNames),
+		DeviceNamesUpdated: c.deviceNamesUpdated,
+		LastAPISuccess:     c.lastAPISuccess,
+		Sites:              slices.Clone(c.discovered),
+		Streams:            make([]StreamStatus, 0, len(c.sites)),
+	}
+	select {
+	case <-c.ready:
+		status.Started = true
+	default:
+	}
+	slices.SortFunc(status.Sites, func(a, b SiteStatus) int {
+		return cmp.Or(strings.Compare(a.Name, b.Name), strings.Compare(a.ID, b.ID))
+	})
+	for _, streamer := range c.sites {
+		status.Streams = append(status.Streams, streamer.status())
+	}
+	slices.SortFunc(status.Streams, func(a, b StreamStatus) int {
+		return cmp.Or(strings.Compare(a.SiteName, b.SiteName), strings.Compare(a.SiteID, b.SiteID))
+	})
+
+	return status
+}
+
 // StreamCollector coordinates the collection of metrics from a set of websocket streams.
 type StreamCollector struct {
-	client       *mistclient.APIClient
-	site         mistclient.Site
-	nameResolver func(string) string
-	logger       *slog.Logger
-
-	mu      sync.RWMutex
-	running bool
-	cancel  context.CancelFunc
+	site              mistclient.Site
+	nameResolver      func(string) string
+	inventoryInterval time.Duration
+	clients           *clientTracker
+	rankInterval      time.Duration
+	histogramInterval time.Duration
+	logger            *slog.Logger
+
+	// deviceMessages and clientMessages record the last raw messages received for debugging.
+	deviceMessages *messageLog
+	clientMessages *messageLog
+
+	mu          sync.RWMutex
+	client      *mistclient.APIClient
+	running     bool
+	connected   bool
+	restart     bool
+	stopped     bool
+	cancel      context.CancelFunc
+	lastMessage time.Time
+
+	// inventoryMu guards inventory, which holds the site's devices keyed by MAC.
+	// It is only populated when the device filter matches on inventory attributes.
+	inventoryMu sync.RWMutex
+	inventory   map[string]mistclient.Device
 }
 
+// stop stops the stream permanently. A stream which is yet to start, including one
+// being restarted by setClient, exits without connecting.
 func (c *StreamCollector) stop() {
-	c.mu.RLock()
-	defer c.mu.RUnlock()
+	c.mu.Lock()
+	defer c.mu.Unlock()
 
+	c.stopped = true
+	c.restart = false
 	if c.cancel != nil {
 		c.cancel()
 	}
+	// A running stream deletes its series once it has exited, so that none are
+	// recreated by stats which are still being handled.
+	if !c.running {
+		c.deleteSeries()
+	}
+}
+
+// deleteSeries deletes the series of the site, including those of its clients, so that
+// a stopped stream neither exports stale metrics nor holds places within series limits.
+func (c *StreamCollector) deleteSeries() {
+	for _, mac := range c.clients.reset() {
+		if clientMetrics != nil {
+			clientMetrics.forget(mac)
+		}
+	}
+	deleteSiteSeries(c.site)
+}
+
+// setClient replaces the Mist API client, restarting the stream if it is running so
+// that it reconnects with the new client.
+func (c *StreamCollector) setClient(client *mistclient.APIClient) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.client = client
+	if c.running && c.cancel != nil {
+		c.restart = true
+		c.cancel()
+	}
 }
 
-func newStreamCollector(client *mistclient.APIClient, site mistclient.Site, nameResolver func(string) string, logger *slog.Logger) *StreamCollector {
-	return &StreamCollector{
-		client:       client,
-		site:         site,
-		nameResolver: nameResolver,
-		logger:       logger.With(slog.String("site", site.Name)),
+// status returns a snapshot of the state of the stream.
+func (c *StreamCollector) status() StreamStatus {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	return StreamStatus{
+		SiteID:      c.site.ID,
+		SiteName:    c.site.Name,
+		Connected:   c.connected,
+		LastMessage: c.lastMessage,
 	}
 }
 
+// received records the time a message was received from the stream.
+func (c *StreamCollector) received() {
+	c.mu.Lock()
+	c.lastMessage = time.Now()
+	c.mu.Unlock()
+}
+
+// apiClient returns the current Mist API client.
+func (c *StreamCollector) apiClient() *mistclient.APIClient {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	return c.client
+}
+
+func newStreamCollector(client *mistclient.APIClient, site mistclient.Site, nameResolver func(string) string, inventoryInterval time.Duration, clientsCfg *config.Clients, debugCfg *config.StreamDebug, logger *slog.Logger) (*StreamCollector, error) {
+	clients, err := newClientTracker(clientsCfg)
+	if err != nil {
+		return nil, err
+	}
+
+	c := &StreamCollector{
+		client:            client,
+		site:              site,
+		nameResolver:      nameResolver,
+		inventoryInterval: inventoryInterval,
+		clients:           clients,
+		logger:            logger.With(slog.String("site", site.Name)),
+	}
+	if clientsCfg.TopN != nil {
+		c.rankInterval = clientsCfg.TopN.Interval
+	}
+	if clientsCfg.Histograms != nil {
+		c.histogramInterval = clientsCfg.Histograms.Interval
+	}
+	if debugCfg != nil {
+		c.deviceMessages = newMessageLog(debugCfg.Messages, false)
+		c.clientMessages = newMessageLog(debugCfg.Messages, debugCfg.RedactClients)
+	}
+
+	return c, nil
+}
+
 func (c *StreamCollector) run(ctx context.Context, wg *sync.WaitGroup) {
 	runCtx, cancel := context.WithCancel(ctx)
 
 	c.mu.Lock()
+	if c.stopped {
+		c.running = false
+		c.mu.Unlock()
+		cancel()
+		c.deleteSeries()
+		wg.Done()
+		return
+	}
 	c.running = true
 	c.cancel = cancel
+	client := c.client
 	c.mu.Unlock()
 
 	c.logger.Info("starting site metrics stream...")
 	defer func() {
 		cancel()
 		c.mu.Lock()
-		c.running = false
+		// A stream stopped by setClient is restarted immediately rather than waiting
+		// for the stream manager, remaining marked as running throughout.
+		restart := c.restart && !c.stopped && ctx.Err() == nil
+		stopped := c.stopped
+		c.restart = false
+		c.running = restart
+		c.connected = false
 		c.cancel = nil
 		c.mu.Unlock()
 
-		c.logger.Info("site metrics stream stopped")
+		if stopped {
+			c.deleteSeries()
+		}
+		if restart {
+			c.logger.Info("restarting site metrics stream with new API client...")
+			wg.Add(1)
+			go c.run(ctx, wg)
+		} else {
+			c.logger.Info("site metrics stream stopped")
+		}
 		wg.Done()
 	}()
 
-	deviceStats, err := c.client.StreamSiteDeviceStats(runCtx, c.site.ID)
+	// Device inventory is loaded before the streams are started so that
+	// the first stats received are filtered correctly.
+	requiresInventory := deviceFilter != nil && deviceFilter.RequiresInventory()
+	if requiresInventory {
+		if err := c.updateInventory(); err != nil {
+			c.logger.Error("unable to fetch site device inventory", "error", err)
+		}
+	}
+
+	deviceStats, err := streamStats[mistclient.StreamedDeviceStat](runCtx, client, fmt.Sprintf(siteDeviceStatsChannel, c.site.ID), c.deviceMessages, c.logger)
 	if err != nil {
 		c.logger.Error("unable to start site device stats stream", "error", err)
 		return
 	}
 	c.logger.Debug("site device stats stream started")
 
-	clientStats, err := c.client.StreamSiteClientStats(runCtx, c.site.ID)
+	clientStats, err := streamStats[mistclient.StreamedClientStat](runCtx, client, fmt.Sprintf(siteClientStatsChannel, c.site.ID), c.clientMessages, c.logger)
 	if err != nil {
 		c.logger.Error("unable to start site client stats stream", "error", err)
 		return
 	}
 	c.logger.Debug("site client stats stream started")
 
+	c.mu.Lock()
+	c.connected = true
+	c.mu.Unlock()
+
 	// WaitGroup to ensure all subscriptions are closed before we exit.
 	// If we get a failure on one channel we cancel the context to
 	// force the other channels to disconnect. We will be restarted by
@@ -256,6 +621,10 @@ func (c *StreamCollector) run(ctx context.Context, wg *sync.WaitGroup) {
 		defer cancel()
 
 		for stat := range deviceStats {
+			c.received()
+			if c.isDeviceFiltered(stat.Mac) {
+				continue
+			}
 			handleSiteDeviceStat(c.site, c.nameResolver(stat.Mac), stat)
 		}
 	}()
@@ -266,13 +635,174 @@ func (c *StreamCollector) run(ctx context.Context, wg *sync.WaitGroup) {
 		defer cancel()
 
 		for stat := range clientStats {
-			handleSiteClientStat(c.site, c.nameResolver(stat.APMac), stat)
+			c.received()
+			if deviceFilter != nil && deviceFilter.DropClients() && c.isDeviceFiltered(stat.APMac) {
+				continue
+			}
+			handleSiteClientStat(c.site, c.nameResolver(stat.APMac), stat, c.clients)
 		}
 	}()
 
+	if requiresInventory {
+		hwg.Add(1)
+		go func() {
+			defer hwg.Done()
+			c.refreshInventory(runCtx)
+		}()
+	}
+
+	if c.clients.ranked != nil && clientMetrics != nil {
+		hwg.Add(1)
+		go func() {
+			defer hwg.Done()
+			c.rankClients(runCtx)
+		}()
+	}
+
+	if clientHistograms != nil && c.histogramInterval > 0 {
+		hwg.Add(1)
+		go func() {
+			defer hwg.Done()
+			c.sampleClients(runCtx)
+		}()
+	}
+
 	hwg.Wait()
 }
 
+// Channels of the Mist streaming API carrying the device and client stats of a site.
+const (
+	siteDeviceStatsChannel = "/sites/%s/stats/devices"
+	siteClientStatsChannel = "/sites/%s/stats/clients"
+)
+
+// streamStats subscribes to a channel of the Mist streaming API, decoding the stat carried
+// by each message as T. Stats are decoded here rather than by the API client so that the
+// raw messages can be recorded in the message log, which may be nil.
+func streamStats[T any](ctx context.Context, client *mistclient.APIClient, channel string, messages *messageLog, logger *slog.Logger) (<-chan T, error) {
+	msgs, err := client.Subscribe(ctx, channel)
+	if err != nil {
+		return nil, fmt.Errorf("unable to subscribe to stream channel %s: %w", channel, err)
+	}
+
+	stats := make(chan T)
+	go func() {
+		defer close(stats)
+
+		for msg := range msgs {
+			messages.add(msg.Data)
+
+			var stat T
+			if err := json.Unmarshal([]byte(msg.Data), &stat); err != nil {
+				logger.Error("unable to decode stream message", "channel", channel, "error", err)
+				continue
+			}
+			stats <- stat
+		}
+	}()
+
+	return stats, nil
+}
+
+// refreshInventory periodically updates the site device inventory until the context is done.
+func (c *StreamCollector) refreshInventory(ctx context.Context) {
+	ticker := time.NewTicker(c.inventoryInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if err := c.updateInventory(); err != nil {
+				c.logger.Error("unable to refresh site device inventory", "error", err)
+			}
+		}
+	}
+}
+
+func (c *StreamCollector) updateInventory() error {
+	devices, err := c.apiClient().GetSiteDevices(c.site.ID)
+	if err != nil {
+		return fmt.Errorf("unable to fetch device list: %w", err)
+	}
+
+	inventory := make(map[string]mistclient.Device, len(devices))
+	for _, device := range devices {
+		inventory[device.Mac] = device
+	}
+
+	c.inventoryMu.Lock()
+	c.inventory = inventory
+	c.inventoryMu.Unlock()
+
+	return nil
+}
+
+// isDeviceFiltered determines if the device with the given MAC is excluded by the device
+// filter. Devices missing from the inventory are matched on their MAC and name only.
+func (c *StreamCollector) isDeviceFiltered(mac string) bool {
+	if deviceFilter == nil {
+		return false
+	}
+
+	c.inventoryMu.RLock()
+	device, ok := c.inventory[mac]
+	c.inventoryMu.RUnlock()
+	if !ok {
+		device = mistclient.Device{Mac: mac}
+	}
+	if device.Name == "" {
+		device.Name = c.nameResolver(mac)
+	}
+
+	return deviceFilter.IsFiltered(device)
+}
+
+// rankClients periodically recomputes the top ranked clients at the site, exporting
+// per-client series only for those clients, until the context is done.
+func (c *StreamCollector) rankClients(ctx context.Context) {
+	ticker := time.NewTicker(c.rankInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			c.clients.rank(
+				func(tc trackedClient) {
+					clientMetrics.observe(c.site, tc.deviceName, tc.stat)
+				},
+				clientMetrics.forget,
+			)
+		}
+	}
+}
+
+// sampleClients periodically observes the tracked clients at the site in the client
+// histograms until the context is done.
+func (c *StreamCollector) sampleClients(ctx context.Context) {
+	ticker := time.NewTicker(c.histogramInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			c.observeClients()
+		}
+	}
+}
+
+// observeClients records each client currently tracked at the site in the client histograms.
+func (c *StreamCollector) observeClients() {
+	for _, tc := range c.clients.snapshot() {
+		clientHistograms.observe(c.site, tc.stat)
+	}
+}
+
 func boolToFloat64(b bool) float64 {
 	if b {
 		return 1
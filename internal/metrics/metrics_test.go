@@ -1,10 +1,26 @@
 package metrics
 
 import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
 	"reflect"
+	"slices"
+	"strings"
+	"sync"
 	"testing"
+	"time"
 
 	"github.com/gregwight/mistclient"
+	"github.com/gregwight/mistexporter/internal/config"
+	"github.com/gregwight/mistexporter/internal/filter"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	dto "github.com/prometheus/client_model/go"
 )
 
 func TestSiteLabelNames(t *testing.T) {
@@ -14,8 +30,8 @@ func TestSiteLabelNames(t *testing.T) {
 		"timezone",
 	}
 
-	if !reflect.DeepEqual(SiteLabelNames, expected) {
-		t.Errorf("SiteLabelNames = %v, want %v", SiteLabelNames, expected)
+	if !reflect.DeepEqual(SiteLabelNames(), expected) {
+		t.Errorf("SiteLabelNames() = %v, want %v", SiteLabelNames(), expected)
 	}
 }
 
@@ -38,3 +54,1095 @@ func TestSiteLabelValues(t *testing.T) {
 		t.Errorf("SiteLabelValues() = %v, want %v", actual, expected)
 	}
 }
+
+func TestConfigureSiteLabels(t *testing.T) {
+	t.Cleanup(func() { ConfigureSiteLabels(nil, SiteLabelResolvers{}) })
+
+	resolvers := SiteLabelResolvers{
+		SiteGroupName: func(id string) string { return map[string]string{"g1": "EMEA", "g2": "Retail"}[id] },
+		SiteVariable:  func(siteID, name string) string { return map[string]string{"s1/store_number": "0042"}[siteID+"/"+name] },
+	}
+	err := ConfigureSiteLabels([]config.SiteLabel{
+		{Name: "site_id", Source: "site_id"},
+		{Name: "site_group", Source: "site_group"},
+		{Name: "store_number", Source: "variable", Variable: "store_number"},
+	}, resolvers)
+	if err != nil {
+		t.Fatalf("ConfigureSiteLabels() returned an unexpected error: %v", err)
+	}
+
+	wantNames := []string{"site_name", "country_code", "timezone", "site_id", "site_group", "store_number"}
+	if got := SiteLabelNames(); !reflect.DeepEqual(got, wantNames) {
+		t.Errorf("SiteLabelNames() = %v, want %v", got, wantNames)
+	}
+	if got := StreamedDeviceIdentityLabelNames(); !reflect.DeepEqual(got, append(wantNames, "device_name", "device_mac")) {
+		t.Errorf("StreamedDeviceIdentityLabelNames() = %v, want site labels followed by device labels", got)
+	}
+
+	site := mistclient.Site{ID: "s1", Name: "Store 42", CountryCode: "GB", Timezone: "Europe/London", SiteGroupIDs: []string{"g2", "g1", "g3"}}
+	wantValues := []string{"Store 42", "GB", "Europe/London", "s1", "EMEA,Retail,g3", "0042"}
+	if got := SiteLabelValues(site); !reflect.DeepEqual(got, wantValues) {
+		t.Errorf("SiteLabelValues() = %v, want %v", got, wantValues)
+	}
+
+	for _, tc := range []struct {
+		name  string
+		label config.SiteLabel
+	}{
+		{"duplicate site label", config.SiteLabel{Name: "timezone", Source: "site_id"}},
+		{"duplicate scoped label", config.SiteLabel{Name: "ssid", Source: "site_id"}},
+		{"invalid name", config.SiteLabel{Name: "site-id", Source: "site_id"}},
+		{"invalid source", config.SiteLabel{Name: "region", Source: "region"}},
+		{"missing variable", config.SiteLabel{Name: "region", Source: "variable"}},
+	} {
+		if err := ConfigureSiteLabels([]config.SiteLabel{tc.label}, resolvers); err == nil {
+			t.Errorf("ConfigureSiteLabels() for %s expected an error, but got nil", tc.name)
+		}
+	}
+}
+
+func TestSiteLabelChange(t *testing.T) {
+	t.Cleanup(func() {
+		ConfigureSiteLabels(nil, SiteLabelResolvers{})
+		seriesLimits = nil
+	})
+
+	storeNumber := "0042"
+	err := ConfigureSiteLabels([]config.SiteLabel{{Name: "store_number", Source: "variable", Variable: "store_number"}}, SiteLabelResolvers{
+		SiteVariable: func(siteID, name string) string { return storeNumber },
+	})
+	if err != nil {
+		t.Fatalf("ConfigureSiteLabels() returned an unexpected error: %v", err)
+	}
+	seriesLimits, err = newSeriesLimiter(&config.SeriesLimits{Default: 10})
+	if err != nil {
+		t.Fatalf("newSeriesLimiter() returned an unexpected error: %v", err)
+	}
+	resetSiteSeries()
+	deviceMetrics = newDeviceMetrics(prometheus.NewRegistry())
+
+	site := mistclient.Site{ID: "s1", Name: "Store 42"}
+	stat := mistclient.StreamedDeviceStat{Mac: "aabbccddeeff", Uptime: mistclient.Seconds(time.Hour)}
+	handleSiteDeviceStat(site, "ap-1", stat)
+
+	storeNumber = "0043"
+	handleSiteDeviceStat(site, "ap-1", stat)
+
+	expected := `
+# HELP mist_device_uptime_seconds Device uptime in seconds.
+# TYPE mist_device_uptime_seconds gauge
+mist_device_uptime_seconds{country_code="",device_mac="aabbccddeeff",device_name="ap-1",device_version="",site_name="Store 42",store_number="0043",timezone=""} 3600
+`
+	if err := testutil.CollectAndCompare(deviceMetrics.uptimeSeconds, strings.NewReader(expected)); err != nil {
+		t.Errorf("series with the previous site variable value were not deleted:\n%v", err)
+	}
+	if f := seriesLimits.family("mist_device_uptime_seconds"); len(f.series) != 1 {
+		t.Errorf("mist_device_uptime_seconds tracked series = %d, want 1", len(f.series))
+	}
+}
+
+func TestConfigureNamespace(t *testing.T) {
+	t.Cleanup(func() { ConfigureNamespace("", nil) })
+
+	if err := ConfigureNamespace("wifi", map[string]string{"env": "prod"}); err != nil {
+		t.Fatalf("ConfigureNamespace() returned an unexpected error: %v", err)
+	}
+
+	site := mistclient.Site{Name: "Test Site"}
+	streamer := newTestStreamCollector(t, site, &config.Clients{TTL: time.Minute})
+	streamer.clients.update("ap-1", mistclient.StreamedClientStat{Client: mistclient.Client{Mac: "c1", SSID: "Corp", Band: mistclient.Band5}})
+	m := &MistMetrics{sites: map[string]*StreamCollector{"test-site-id": streamer}}
+
+	expected := `
+# HELP wifi_site_clients Number of wireless clients currently connected to the site, by SSID and band.
+# TYPE wifi_site_clients gauge
+wifi_site_clients{country_code="",env="prod",radio="5",site_name="Test Site",ssid="Corp",timezone=""} 1
+`
+	if err := testutil.CollectAndCompare(newAggregateCollector(m, 0), strings.NewReader(expected), "wifi_site_clients"); err != nil {
+		t.Errorf("unexpected metrics collected:\n%v", err)
+	}
+
+	for _, tc := range []struct {
+		name   string
+		ns     string
+		labels map[string]string
+	}{
+		{"invalid namespace", "mist-exporter", nil},
+		{"invalid label name", "mist", map[string]string{"static-env": "prod"}},
+		{"site label", "mist", map[string]string{"site_name": "prod"}},
+		{"client label", "mist", map[string]string{"client_mac": "prod"}},
+	} {
+		if err := ConfigureNamespace(tc.ns, tc.labels); err == nil {
+			t.Errorf("ConfigureNamespace() for %s expected an error, but got nil", tc.name)
+		}
+	}
+}
+
+func TestDeviceRebootDetection(t *testing.T) {
+	deviceMetrics = newDeviceMetrics(prometheus.NewRegistry())
+
+	site := mistclient.Site{Name: "Test Site"}
+	stat := mistclient.StreamedDeviceStat{Mac: "aabbccddeeff", Version: "1.0"}
+	labels := StreamedDeviceIdentityLabelValues(site, "ap-1", stat)
+
+	for _, tc := range []struct {
+		uptime  time.Duration
+		version string
+		want    float64
+	}{
+		{uptime: 1 * time.Hour, version: "1.0", want: 0},
+		{uptime: 2 * time.Hour, version: "1.0", want: 0},
+		{uptime: 0, version: "1.0", want: 0},
+		{uptime: 5 * time.Minute, version: "1.1", want: 1},
+		{uptime: 10 * time.Minute, version: "1.1", want: 1},
+		{uptime: 1 * time.Minute, version: "1.1", want: 2},
+	} {
+		stat.Uptime = mistclient.Seconds(tc.uptime)
+		stat.Version = tc.version
+		handleSiteDeviceStat(site, "ap-1", stat)
+
+		if got := testutil.ToFloat64(deviceMetrics.rebootsTotal.WithLabelValues(labels...)); got != tc.want {
+			t.Errorf("after uptime %v, mist_device_reboots_total = %v, want %v", tc.uptime, got, tc.want)
+		}
+	}
+
+	lastReboot := testutil.ToFloat64(deviceMetrics.lastRebootTimestamp.WithLabelValues(labels...))
+	if want := float64(time.Now().Add(-1 * time.Minute).Unix()); lastReboot < want-5 || lastReboot > want+5 {
+		t.Errorf("mist_device_last_reboot_timestamp_seconds = %v, want approximately %v", lastReboot, want)
+	}
+}
+
+func TestDeviceStatePruning(t *testing.T) {
+	deviceMetrics = newDeviceMetrics(prometheus.NewRegistry())
+
+	site := mistclient.Site{Name: "Test Site"}
+	for _, mac := range []string{"ap-1", "ap-2"} {
+		handleSiteDeviceStat(site, mac, mistclient.StreamedDeviceStat{Mac: mac, Uptime: mistclient.Seconds(time.Hour)})
+	}
+
+	// ap-1 was last seen beyond the TTL, and the state is due to be pruned.
+	deviceMetrics.state["ap-1"].seen = time.Now().Add(-deviceStateTTL - time.Minute)
+	deviceMetrics.pruned = time.Now().Add(-deviceStatePruneInterval)
+	handleSiteDeviceStat(site, "ap-2", mistclient.StreamedDeviceStat{Mac: "ap-2", Uptime: mistclient.Seconds(time.Hour)})
+
+	if _, ok := deviceMetrics.state["ap-1"]; ok {
+		t.Error("state of a device not seen within the TTL was not pruned")
+	}
+	if _, ok := deviceMetrics.state["ap-2"]; !ok {
+		t.Error("state of a recently seen device was pruned")
+	}
+}
+
+func TestDeviceRadioChangeDetection(t *testing.T) {
+	deviceMetrics = newDeviceMetrics(prometheus.NewRegistry())
+
+	site := mistclient.Site{Name: "Test Site"}
+	stat := mistclient.StreamedDeviceStat{Mac: "aabbccddeeff", Version: "1.0"}
+	labels := DeviceIdentityWithRadioLabelValues(site, "ap-1", stat, mistclient.Band5Config.String())
+
+	for _, tc := range []struct {
+		channel     int
+		power       int
+		wantChannel float64
+		wantPower   float64
+	}{
+		{channel: 36, power: 12, wantChannel: 0, wantPower: 0},
+		{channel: 36, power: 12, wantChannel: 0, wantPower: 0},
+		{channel: 44, power: 12, wantChannel: 1, wantPower: 0},
+		{channel: 44, power: 15, wantChannel: 1, wantPower: 1},
+		{channel: 0, power: 0, wantChannel: 1, wantPower: 1},
+		{channel: 44, power: 15, wantChannel: 1, wantPower: 1},
+		{channel: 149, power: 9, wantChannel: 2, wantPower: 2},
+	} {
+		stat.RadioStats = map[mistclient.RadioConfig]mistclient.StreamedRadioStat{
+			mistclient.Band5Config: {Channel: tc.channel, Power: tc.power},
+		}
+		handleSiteDeviceStat(site, "ap-1", stat)
+
+		if got := testutil.ToFloat64(deviceMetrics.radioChannelChangesTotal.WithLabelValues(labels...)); got != tc.wantChannel {
+			t.Errorf("after channel %d, mist_device_radio_channel_changes_total = %v, want %v", tc.channel, got, tc.wantChannel)
+		}
+		if got := testutil.ToFloat64(deviceMetrics.radioPowerChangesTotal.WithLabelValues(labels...)); got != tc.wantPower {
+			t.Errorf("after power %d, mist_device_radio_power_changes_total = %v, want %v", tc.power, got, tc.wantPower)
+		}
+	}
+}
+
+func newTestStreamCollector(t *testing.T, site mistclient.Site, cfg *config.Clients) *StreamCollector {
+	t.Helper()
+
+	streamer, err := newStreamCollector(nil, site, func(string) string { return "" }, time.Minute, cfg, nil, slog.New(slog.NewTextHandler(io.Discard, nil)))
+	if err != nil {
+		t.Fatalf("newStreamCollector() returned an unexpected error: %v", err)
+	}
+	return streamer
+}
+
+func TestSiteClientAggregates(t *testing.T) {
+	site := mistclient.Site{Name: "Test Site", CountryCode: "GB", Timezone: "Europe/London"}
+	streamer := newTestStreamCollector(t, site, &config.Clients{TTL: time.Minute})
+	m := &MistMetrics{sites: map[string]*StreamCollector{"test-site-id": streamer}}
+
+	for _, stat := range []mistclient.StreamedClientStat{
+		{Client: mistclient.Client{Mac: "c1", SSID: "Corp", Band: mistclient.Band5}},
+		{Client: mistclient.Client{Mac: "c2", SSID: "Corp", Band: mistclient.Band5}},
+		{Client: mistclient.Client{Mac: "c2", SSID: "Corp", Band: mistclient.Band5}},
+		{Client: mistclient.Client{Mac: "c3", SSID: "Corp", Band: mistclient.Band24}},
+		{Client: mistclient.Client{Mac: "c4", SSID: "Guest", Band: mistclient.Band5}},
+	} {
+		streamer.clients.update("ap-1", stat)
+	}
+
+	// Expired clients must not be counted.
+	streamer.clients.clients["c5"] = trackedClient{
+		stat:    mistclient.StreamedClientStat{Client: mistclient.Client{Mac: "c5", SSID: "Guest", Band: mistclient.Band5}},
+		updated: time.Now().Add(-2 * time.Minute),
+	}
+
+	expected := `
+# HELP mist_site_clients Number of wireless clients currently connected to the site, by SSID and band.
+# TYPE mist_site_clients gauge
+mist_site_clients{country_code="GB",radio="2.4",site_name="Test Site",ssid="Corp",timezone="Europe/London"} 1
+mist_site_clients{country_code="GB",radio="5",site_name="Test Site",ssid="Corp",timezone="Europe/London"} 2
+mist_site_clients{country_code="GB",radio="5",site_name="Test Site",ssid="Guest",timezone="Europe/London"} 1
+`
+	if err := testutil.CollectAndCompare(newAggregateCollector(m, 0), strings.NewReader(expected), "mist_site_clients"); err != nil {
+		t.Errorf("unexpected metrics collected:\n%v", err)
+	}
+	if _, ok := streamer.clients.clients["c5"]; ok {
+		t.Error("expired client was not removed from the tracker")
+	}
+}
+
+func TestDeviceAndSSIDClientAggregates(t *testing.T) {
+	site := mistclient.Site{Name: "Test Site"}
+	streamer := newTestStreamCollector(t, site, &config.Clients{TTL: time.Minute})
+	m := &MistMetrics{sites: map[string]*StreamCollector{"test-site-id": streamer}}
+
+	for _, c := range []struct {
+		deviceName string
+		stat       mistclient.Client
+	}{
+		{"ap-1", mistclient.Client{Mac: "c1", APMac: "a1", SSID: "Corp", Band: mistclient.Band5, Proto: mistclient.AX, RxBps: 100, TxBps: 1000}},
+		{"ap-1", mistclient.Client{Mac: "c2", APMac: "a1", SSID: "Corp", Band: mistclient.Band5, Proto: mistclient.AX, RxBps: 200, TxBps: 2000}},
+		{"ap-2", mistclient.Client{Mac: "c3", APMac: "a2", SSID: "Corp", Band: mistclient.Band24, Proto: mistclient.N, RxBps: 50, TxBps: 500}},
+	} {
+		streamer.clients.update(c.deviceName, mistclient.StreamedClientStat{Client: c.stat})
+	}
+
+	expected := `
+# HELP mist_device_clients Number of wireless clients currently connected to the device, by SSID, band and protocol.
+# TYPE mist_device_clients gauge
+mist_device_clients{country_code="",device_mac="a1",device_name="ap-1",proto="ax",radio="5",site_name="Test Site",ssid="Corp",timezone=""} 2
+mist_device_clients{country_code="",device_mac="a2",device_name="ap-2",proto="n",radio="2.4",site_name="Test Site",ssid="Corp",timezone=""} 1
+# HELP mist_device_client_transmit_bits_per_second Sum of bits per second transmitted to the wireless clients connected to the device.
+# TYPE mist_device_client_transmit_bits_per_second gauge
+mist_device_client_transmit_bits_per_second{country_code="",device_mac="a1",device_name="ap-1",proto="ax",radio="5",site_name="Test Site",ssid="Corp",timezone=""} 3000
+mist_device_client_transmit_bits_per_second{country_code="",device_mac="a2",device_name="ap-2",proto="n",radio="2.4",site_name="Test Site",ssid="Corp",timezone=""} 500
+# HELP mist_site_clients_by_ssid Number of wireless clients currently connected to the SSID at the site.
+# TYPE mist_site_clients_by_ssid gauge
+mist_site_clients_by_ssid{country_code="",site_name="Test Site",ssid="Corp",timezone=""} 3
+# HELP mist_site_ssid_receive_bits_per_second Sum of bits per second received from the wireless clients connected to the SSID at the site.
+# TYPE mist_site_ssid_receive_bits_per_second gauge
+mist_site_ssid_receive_bits_per_second{country_code="",site_name="Test Site",ssid="Corp",timezone=""} 350
+`
+	if err := testutil.CollectAndCompare(newAggregateCollector(m, 0), strings.NewReader(expected),
+		"mist_device_clients",
+		"mist_device_client_transmit_bits_per_second",
+		"mist_site_clients_by_ssid",
+		"mist_site_ssid_receive_bits_per_second",
+	); err != nil {
+		t.Errorf("unexpected metrics collected:\n%v", err)
+	}
+}
+
+func TestDeviceFilter(t *testing.T) {
+	var err error
+	deviceFilter, err = filter.NewDeviceFilter(&config.DeviceFilter{
+		Exclude: []config.DeviceMatch{{Model: []string{"AP12"}}},
+	})
+	if err != nil {
+		t.Fatalf("NewDeviceFilter() returned an unexpected error: %v", err)
+	}
+	t.Cleanup(func() { deviceFilter = nil })
+
+	site := mistclient.Site{Name: "Test Site"}
+	streamer := newTestStreamCollector(t, site, &config.Clients{TTL: time.Minute})
+	streamer.inventory = map[string]mistclient.Device{
+		"a1": {Mac: "a1", Model: "AP45"},
+		"a2": {Mac: "a2", Model: "AP12"},
+	}
+	m := &MistMetrics{sites: map[string]*StreamCollector{"test-site-id": streamer}}
+
+	if streamer.isDeviceFiltered("a1") {
+		t.Error("isDeviceFiltered() for device a1 returned true, want false")
+	}
+	if !streamer.isDeviceFiltered("a2") {
+		t.Error("isDeviceFiltered() for device a2 returned false, want true")
+	}
+
+	streamer.clients.update("ap-1", mistclient.StreamedClientStat{Client: mistclient.Client{Mac: "c1", APMac: "a1", SSID: "Corp", Band: mistclient.Band5, Proto: mistclient.AX}})
+	streamer.clients.update("ap-2", mistclient.StreamedClientStat{Client: mistclient.Client{Mac: "c2", APMac: "a2", SSID: "Corp", Band: mistclient.Band5, Proto: mistclient.AX}})
+
+	expected := `
+# HELP mist_device_clients Number of wireless clients currently connected to the device, by SSID, band and protocol.
+# TYPE mist_device_clients gauge
+mist_device_clients{country_code="",device_mac="a1",device_name="ap-1",proto="ax",radio="5",site_name="Test Site",ssid="Corp",timezone=""} 1
+# HELP mist_site_clients_by_ssid Number of wireless clients currently connected to the SSID at the site.
+# TYPE mist_site_clients_by_ssid gauge
+mist_site_clients_by_ssid{country_code="",site_name="Test Site",ssid="Corp",timezone=""} 2
+`
+	if err := testutil.CollectAndCompare(newAggregateCollector(m, 0), strings.NewReader(expected),
+		"mist_device_clients",
+		"mist_site_clients_by_ssid",
+	); err != nil {
+		t.Errorf("unexpected metrics collected:\n%v", err)
+	}
+}
+
+func TestClientBreakdowns(t *testing.T) {
+	site := mistclient.Site{Name: "Test Site"}
+	streamer := newTestStreamCollector(t, site, &config.Clients{TTL: time.Minute})
+	m := &MistMetrics{sites: map[string]*StreamCollector{"test-site-id": streamer}}
+
+	for i, c := range []mistclient.Client{
+		{Family: "iOS", OS: "iOS 17", Manufacture: "Apple", Proto: mistclient.AX, Band: mistclient.Band24},
+		{Family: "iOS", OS: "iOS 18", Manufacture: "Apple", Proto: mistclient.AX, Band: mistclient.Band5},
+		{Family: "iOS", OS: "iOS 18", Manufacture: "Apple", Proto: mistclient.AX, Band: mistclient.Band5},
+		{Family: "Android", Manufacture: "Samsung", Proto: mistclient.AC, Band: mistclient.Band5},
+		{Family: "Android", Manufacture: "Google", Proto: mistclient.AC, Band: mistclient.Band5},
+		{Family: "Windows", Manufacture: "Intel", Proto: mistclient.N, Band: mistclient.Band24},
+		{Manufacture: "Espressif", Proto: mistclient.N, Band: mistclient.Band24},
+		// Clients reported with the values of the synthetic buckets are counted in them.
+		{Family: "unknown", Manufacture: "other", Proto: mistclient.N, Band: mistclient.Band24},
+	} {
+		c.Mac = fmt.Sprintf("c%d", i)
+		streamer.clients.update("ap-1", mistclient.StreamedClientStat{Client: c})
+	}
+
+	expected := `
+# HELP mist_site_clients_by_os Number of wireless clients currently connected to the site, by operating system family.
+# TYPE mist_site_clients_by_os gauge
+mist_site_clients_by_os{country_code="",os_family="Android",site_name="Test Site",timezone=""} 2
+mist_site_clients_by_os{country_code="",os_family="iOS",site_name="Test Site",timezone=""} 3
+mist_site_clients_by_os{country_code="",os_family="other",site_name="Test Site",timezone=""} 3
+# HELP mist_site_clients_by_manufacturer Number of wireless clients currently connected to the site, by manufacturer.
+# TYPE mist_site_clients_by_manufacturer gauge
+mist_site_clients_by_manufacturer{country_code="",manufacturer="Apple",site_name="Test Site",timezone=""} 3
+mist_site_clients_by_manufacturer{country_code="",manufacturer="Espressif",site_name="Test Site",timezone=""} 1
+mist_site_clients_by_manufacturer{country_code="",manufacturer="other",site_name="Test Site",timezone=""} 4
+# HELP mist_site_clients_by_protocol Number of wireless clients currently connected to the site, by 802.11 protocol and band.
+# TYPE mist_site_clients_by_protocol gauge
+mist_site_clients_by_protocol{country_code="",proto="ac",radio="5",site_name="Test Site",timezone=""} 2
+mist_site_clients_by_protocol{country_code="",proto="ax",radio="2.4",site_name="Test Site",timezone=""} 1
+mist_site_clients_by_protocol{country_code="",proto="ax",radio="5",site_name="Test Site",timezone=""} 2
+mist_site_clients_by_protocol{country_code="",proto="n",radio="2.4",site_name="Test Site",timezone=""} 3
+`
+	c := newAggregateCollector(m, 2)
+	if err := testutil.CollectAndCompare(c, strings.NewReader(expected),
+		"mist_site_clients_by_os",
+		"mist_site_clients_by_manufacturer",
+		"mist_site_clients_by_protocol",
+	); err != nil {
+		t.Errorf("unexpected metrics collected:\n%v", err)
+	}
+}
+
+func TestClientHistograms(t *testing.T) {
+	h := newClientHistograms(prometheus.NewRegistry(), &config.ClientHistograms{Enabled: true})
+
+	site := mistclient.Site{Name: "Test Site"}
+	h.observe(site, mistclient.StreamedClientStat{Client: mistclient.Client{SSID: "Corp", Band: mistclient.Band5, RSSI: -62, SNR: 30, TxRate: 866}})
+	h.observe(site, mistclient.StreamedClientStat{Client: mistclient.Client{SSID: "Corp", Band: mistclient.Band5, RSSI: -71}})
+
+	if got := testutil.CollectAndCount(h.rssiDbm); got != 1 {
+		t.Errorf("mist_site_client_rssi_dbm series = %d, want 1", got)
+	}
+	if got := testutil.CollectAndCount(h.receiveRateMbps); got != 0 {
+		t.Errorf("mist_site_client_receive_rate_mbps series = %d, want 0", got)
+	}
+
+	expected := `
+# HELP mist_site_client_snr_db Distribution of wireless client Signal-to-Noise Ratio in dB.
+# TYPE mist_site_client_snr_db histogram
+mist_site_client_snr_db_bucket{country_code="",radio="5",site_name="Test Site",ssid="Corp",timezone="",le="5"} 0
+mist_site_client_snr_db_bucket{country_code="",radio="5",site_name="Test Site",ssid="Corp",timezone="",le="10"} 0
+mist_site_client_snr_db_bucket{country_code="",radio="5",site_name="Test Site",ssid="Corp",timezone="",le="15"} 0
+mist_site_client_snr_db_bucket{country_code="",radio="5",site_name="Test Site",ssid="Corp",timezone="",le="20"} 0
+mist_site_client_snr_db_bucket{country_code="",radio="5",site_name="Test Site",ssid="Corp",timezone="",le="25"} 0
+mist_site_client_snr_db_bucket{country_code="",radio="5",site_name="Test Site",ssid="Corp",timezone="",le="30"} 1
+mist_site_client_snr_db_bucket{country_code="",radio="5",site_name="Test Site",ssid="Corp",timezone="",le="35"} 1
+mist_site_client_snr_db_bucket{country_code="",radio="5",site_name="Test Site",ssid="Corp",timezone="",le="40"} 1
+mist_site_client_snr_db_bucket{country_code="",radio="5",site_name="Test Site",ssid="Corp",timezone="",le="45"} 1
+mist_site_client_snr_db_bucket{country_code="",radio="5",site_name="Test Site",ssid="Corp",timezone="",le="50"} 1
+mist_site_client_snr_db_bucket{country_code="",radio="5",site_name="Test Site",ssid="Corp",timezone="",le="+Inf"} 1
+mist_site_client_snr_db_sum{country_code="",radio="5",site_name="Test Site",ssid="Corp",timezone=""} 30
+mist_site_client_snr_db_count{country_code="",radio="5",site_name="Test Site",ssid="Corp",timezone=""} 1
+`
+	if err := testutil.CollectAndCompare(h.snrDb, strings.NewReader(expected)); err != nil {
+		t.Errorf("unexpected metrics collected:\n%v", err)
+	}
+}
+
+func TestClientHistogramSampling(t *testing.T) {
+	clientMetrics = nil
+	clientHistograms = newClientHistograms(prometheus.NewRegistry(), &config.ClientHistograms{Enabled: true})
+	t.Cleanup(func() { clientHistograms = nil })
+
+	site := mistclient.Site{Name: "Test Site"}
+	streamer := newTestStreamCollector(t, site, &config.Clients{TTL: time.Minute})
+
+	// Repeated messages from the same client must not outweigh other clients.
+	for _, rssi := range []int{-50, -52, -54} {
+		handleSiteClientStat(site, "ap-1", mistclient.StreamedClientStat{Client: mistclient.Client{Mac: "aa", SSID: "Corp", Band: mistclient.Band5, RSSI: rssi}}, streamer.clients)
+	}
+	handleSiteClientStat(site, "ap-1", mistclient.StreamedClientStat{Client: mistclient.Client{Mac: "bb", SSID: "Corp", Band: mistclient.Band5, RSSI: -80}}, streamer.clients)
+
+	if got := testutil.CollectAndCount(clientHistograms.rssiDbm); got != 0 {
+		t.Errorf("mist_site_client_rssi_dbm series before sampling = %d, want 0", got)
+	}
+
+	streamer.observeClients()
+
+	metric := &dto.Metric{}
+	if err := clientHistograms.rssiDbm.WithLabelValues(SiteClientLabelValues(site, mistclient.StreamedClientStat{Client: mistclient.Client{SSID: "Corp", Band: mistclient.Band5}})...).(prometheus.Histogram).Write(metric); err != nil {
+		t.Fatalf("Write() returned an unexpected error: %v", err)
+	}
+	if got := metric.GetHistogram().GetSampleCount(); got != 2 {
+		t.Errorf("mist_site_client_rssi_dbm count = %d, want 2", got)
+	}
+	if got := metric.GetHistogram().GetSampleSum(); got != -134 {
+		t.Errorf("mist_site_client_rssi_dbm sum = %v, want -134", got)
+	}
+}
+
+func TestClientLabels(t *testing.T) {
+	l, err := newClientLabels(nil)
+	if err != nil {
+		t.Fatalf("newClientLabels() returned an unexpected error: %v", err)
+	}
+	if !reflect.DeepEqual(l.names, StreamedClientLabelNames()) {
+		t.Errorf("newClientLabels(nil) names = %v, want %v", l.names, StreamedClientLabelNames())
+	}
+	if l.infoNames != nil {
+		t.Errorf("newClientLabels(nil) infoNames = %v, want nil", l.infoNames)
+	}
+
+	for _, keep := range [][]string{
+		{"client_mac", "not_a_label"},
+		{"ssid", "radio"},
+		{"site_name", "client_mac"},
+	} {
+		if _, err := newClientLabels(keep); err == nil {
+			t.Errorf("newClientLabels(%v) expected an error, but got nil", keep)
+		}
+	}
+}
+
+func TestClientInfo(t *testing.T) {
+	labels, err := newClientLabels([]string{"client_mac", "ssid", "radio"})
+	if err != nil {
+		t.Fatalf("newClientLabels() returned an unexpected error: %v", err)
+	}
+	clientMetrics = newClientMetrics(prometheus.NewRegistry(), labels, &privacyPolicy{})
+	clientHistograms = nil
+
+	clients, err := newClientTracker(&config.Clients{TTL: time.Minute})
+	if err != nil {
+		t.Fatalf("newClientTracker() returned an unexpected error: %v", err)
+	}
+
+	site := mistclient.Site{Name: "Test Site"}
+	stat := mistclient.StreamedClientStat{Client: mistclient.Client{Mac: "c1", SSID: "Corp", Band: mistclient.Band5, RSSI: -60}}
+	handleSiteClientStat(site, "ap-1", stat, clients)
+
+	// A late hostname resolution must not create a new value series, and must replace the info series.
+	stat.Hostname = "laptop-1"
+	stat.RSSI = -65
+	handleSiteClientStat(site, "ap-1", stat, clients)
+
+	expected := `
+# HELP mist_client_rssi_dbm The client's Received Signal Strength Indicator in dBm.
+# TYPE mist_client_rssi_dbm gauge
+mist_client_rssi_dbm{client_mac="c1",country_code="",radio="5",site_name="Test Site",ssid="Corp",timezone=""} -65
+# HELP mist_client_info Descriptive labels of the client which are not attached to other client metrics. The value is always 1.
+# TYPE mist_client_info gauge
+mist_client_info{client_family="",client_hostname="laptop-1",client_mac="c1",client_manufacturer="",client_model="",client_os="",client_username="",country_code="",device_mac="",device_name="ap-1",proto="unknown",site_name="Test Site",timezone=""} 1
+`
+	if err := testutil.CollectAndCompare(clientMetrics.rssiDbm, strings.NewReader(expected), "mist_client_rssi_dbm"); err != nil {
+		t.Errorf("unexpected metrics collected:\n%v", err)
+	}
+	if err := testutil.CollectAndCompare(clientMetrics.info, strings.NewReader(expected), "mist_client_info"); err != nil {
+		t.Errorf("unexpected metrics collected:\n%v", err)
+	}
+}
+
+func TestClientMovesToFilteredSSID(t *testing.T) {
+	var err error
+	clientFilter, err = filter.NewClientFilter(&config.ClientFilter{Exclude: []config.ClientMatch{{SSID: []string{"Guest"}}}})
+	if err != nil {
+		t.Fatalf("NewClientFilter() returned an unexpected error: %v", err)
+	}
+	labels, err := newClientLabels(nil)
+	if err != nil {
+		t.Fatalf("newClientLabels() returned an unexpected error: %v", err)
+	}
+	clientMetrics = newClientMetrics(prometheus.NewRegistry(), labels, &privacyPolicy{})
+	t.Cleanup(func() {
+		clientFilter = nil
+		clientMetrics = nil
+	})
+
+	clients, err := newClientTracker(&config.Clients{TTL: time.Minute})
+	if err != nil {
+		t.Fatalf("newClientTracker() returned an unexpected error: %v", err)
+	}
+
+	site := mistclient.Site{Name: "Test Site"}
+	stat := mistclient.StreamedClientStat{Client: mistclient.Client{Mac: "c1", SSID: "Corp", Band: mistclient.Band5, RSSI: -60}}
+	handleSiteClientStat(site, "ap-1", stat, clients)
+	if got := testutil.CollectAndCount(clientMetrics.rssiDbm); got != 1 {
+		t.Fatalf("mist_client_rssi_dbm series = %d, want 1", got)
+	}
+
+	stat.SSID = "Guest"
+	handleSiteClientStat(site, "ap-1", stat, clients)
+	if got := testutil.CollectAndCount(clientMetrics.rssiDbm); got != 0 {
+		t.Errorf("mist_client_rssi_dbm series after moving to a filtered SSID = %d, want 0", got)
+	}
+	if got := len(clients.snapshot()); got != 0 {
+		t.Errorf("tracked clients after moving to a filtered SSID = %d, want 0", got)
+	}
+}
+
+func TestPrivacyPolicy(t *testing.T) {
+	p, err := newPrivacyPolicy(&config.ClientPrivacy{
+		HashKey: "test-key",
+		Labels: map[string]string{
+			"client_username": "hash",
+			"client_hostname": "drop",
+			"client_mac":      "hash",
+			"client_os":       "keep",
+		},
+	})
+	if err != nil {
+		t.Fatalf("newPrivacyPolicy() returned an unexpected error: %v", err)
+	}
+
+	site := mistclient.Site{Name: "Test Site"}
+	stat := mistclient.StreamedClientStat{Client: mistclient.Client{Mac: "c1", Username: "alice", Hostname: "alice-laptop", OS: "macOS"}}
+
+	values := StreamedClientLabelValues(site, "ap-1", stat)
+	p.apply(values)
+	again := StreamedClientLabelValues(site, "ap-1", stat)
+	p.apply(again)
+
+	username := values[slices.Index(StreamedClientLabelNames(), "client_username")]
+	if username == "alice" || len(username) != hashLength {
+		t.Errorf("client_username = %q, want a %d character hash", username, hashLength)
+	}
+	if again[slices.Index(StreamedClientLabelNames(), "client_username")] != username {
+		t.Error("client_username hash is not consistent between updates")
+	}
+	if got := values[slices.Index(StreamedClientLabelNames(), "client_hostname")]; got != "" {
+		t.Errorf("client_hostname = %q, want it dropped", got)
+	}
+	if got := values[slices.Index(StreamedClientLabelNames(), "client_mac")]; got == "c1" || got == "" {
+		t.Errorf("client_mac = %q, want it hashed", got)
+	}
+	if got := values[slices.Index(StreamedClientLabelNames(), "client_os")]; got != "macOS" {
+		t.Errorf("client_os = %q, want %q", got, "macOS")
+	}
+
+	for name, cfg := range map[string]*config.ClientPrivacy{
+		"hash without key": {Labels: map[string]string{"client_username": "hash"}},
+		"drop client mac":  {Labels: map[string]string{"client_mac": "drop"}},
+		"site label":       {HashKey: "k", Labels: map[string]string{"site_name": "hash"}},
+		"unknown label":    {HashKey: "k", Labels: map[string]string{"not_a_label": "hash"}},
+		"unknown action":   {HashKey: "k", Labels: map[string]string{"client_username": "encrypt"}},
+	} {
+		if _, err := newPrivacyPolicy(cfg); err == nil {
+			t.Errorf("newPrivacyPolicy() with %s expected an error, but got nil", name)
+		}
+	}
+}
+
+func TestClientTopN(t *testing.T) {
+	labels, err := newClientLabels([]string{"client_mac"})
+	if err != nil {
+		t.Fatalf("newClientLabels() returned an unexpected error: %v", err)
+	}
+	clientMetrics = newClientMetrics(prometheus.NewRegistry(), labels, &privacyPolicy{})
+	clientHistograms = nil
+
+	site := mistclient.Site{Name: "Test Site"}
+	streamer := newTestStreamCollector(t, site, &config.Clients{
+		TTL:  time.Minute,
+		TopN: &config.ClientTopN{Limit: 2, RankBy: "throughput"},
+	})
+
+	exported := func() []string {
+		var macs []string
+		for mac := range clientMetrics.series {
+			macs = append(macs, mac)
+		}
+		slices.Sort(macs)
+		return macs
+	}
+	rank := func() {
+		streamer.clients.rank(func(tc trackedClient) {
+			clientMetrics.observe(site, tc.deviceName, tc.stat)
+		}, clientMetrics.forget)
+	}
+	update := func(mac string, bps int) {
+		handleSiteClientStat(site, "ap-1", mistclient.StreamedClientStat{Client: mistclient.Client{Mac: mac, TxBps: bps}}, streamer.clients)
+	}
+
+	// Free places are filled as clients are first seen.
+	update("c1", 10)
+	update("c2", 20)
+	update("c3", 30)
+	if got, want := exported(), []string{"c1", "c2"}; !slices.Equal(got, want) {
+		t.Errorf("exported clients before ranking = %v, want %v", got, want)
+	}
+
+	rank()
+	if got, want := exported(), []string{"c2", "c3"}; !slices.Equal(got, want) {
+		t.Errorf("exported clients after ranking = %v, want %v", got, want)
+	}
+	if got := testutil.CollectAndCount(clientMetrics.transmitBps); got != 2 {
+		t.Errorf("mist_client_transmit_bits_per_second series = %d, want 2", got)
+	}
+
+	// Clients outside the top N are not exported until the next ranking.
+	update("c1", 100)
+	if got, want := exported(), []string{"c2", "c3"}; !slices.Equal(got, want) {
+		t.Errorf("exported clients before re-ranking = %v, want %v", got, want)
+	}
+
+	rank()
+	if got, want := exported(), []string{"c1", "c3"}; !slices.Equal(got, want) {
+		t.Errorf("exported clients after re-ranking = %v, want %v", got, want)
+	}
+	if got := testutil.CollectAndCount(clientMetrics.transmitBps); got != 2 {
+		t.Errorf("mist_client_transmit_bits_per_second series = %d, want 2", got)
+	}
+
+	if _, err := newClientTracker(&config.Clients{TopN: &config.ClientTopN{Limit: 1, RankBy: "bogus"}}); err == nil {
+		t.Error("newClientTracker() with an invalid rank metric expected an error, but got nil")
+	}
+}
+
+func TestSeriesLimits(t *testing.T) {
+	t.Cleanup(func() { seriesLimits = nil })
+
+	for _, tc := range []struct {
+		name        string
+		cfg         *config.SeriesLimits
+		wantSeries  []string
+		wantDropped float64
+		untracked   bool
+	}{
+		{
+			name:       "unlimited",
+			cfg:        &config.SeriesLimits{},
+			wantSeries: []string{"ap-1", "ap-2", "ap-3"},
+			untracked:  true,
+		},
+		{
+			name:        "reject",
+			cfg:         &config.SeriesLimits{Default: 2, Policy: "reject"},
+			wantSeries:  []string{"ap-1", "ap-2"},
+			wantDropped: 1,
+		},
+		{
+			name:        "evict",
+			cfg:         &config.SeriesLimits{Default: 2, Policy: "evict"},
+			wantSeries:  []string{"ap-1", "ap-3"},
+			wantDropped: 1,
+		},
+		{
+			name: "family override",
+			cfg: &config.SeriesLimits{
+				Default:  1,
+				Families: map[string]int{"mist_device_uptime_seconds": 0},
+			},
+			wantSeries: []string{"ap-1", "ap-2", "ap-3"},
+			untracked:  true,
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			var err error
+			seriesLimits, err = newSeriesLimiter(tc.cfg)
+			if err != nil {
+				t.Fatalf("newSeriesLimiter() error = %v", err)
+			}
+			vec := newGaugeVec(prometheus.GaugeOpts{
+				Namespace: "mist",
+				Subsystem: "device",
+				Name:      "uptime_seconds",
+			}, []string{"device"})
+
+			vec.WithLabelValues("ap-1").Set(1)
+			vec.WithLabelValues("ap-2").Set(1)
+			vec.WithLabelValues("ap-1").Set(2)
+			vec.WithLabelValues("ap-3").Set(1)
+			vec.WithLabelValues("ap-3").Set(2)
+
+			expected := fmt.Sprintf(`
+# HELP mist_exporter_series Number of series currently exported for each Mist metric family with a series limit.
+# TYPE mist_exporter_series gauge
+mist_exporter_series{family="mist_device_uptime_seconds"} %d
+# HELP mist_exporter_series_dropped_total Number of series dropped because their metric family reached its series limit.
+# TYPE mist_exporter_series_dropped_total counter
+mist_exporter_series_dropped_total{family="mist_device_uptime_seconds"} %v
+`, len(tc.wantSeries), tc.wantDropped)
+			if tc.untracked {
+				expected = ""
+			}
+			if err := testutil.CollectAndCompare(seriesLimits, strings.NewReader(expected)); err != nil {
+				t.Error(err)
+			}
+
+			var got []string
+			for _, name := range []string{"ap-1", "ap-2", "ap-3"} {
+				if vec.DeleteLabelValues(name) {
+					got = append(got, name)
+				}
+			}
+			if !slices.Equal(got, tc.wantSeries) {
+				t.Errorf("exported series = %v, want %v", got, tc.wantSeries)
+			}
+		})
+	}
+
+	if _, err := newSeriesLimiter(&config.SeriesLimits{Policy: "oldest"}); err == nil {
+		t.Error("newSeriesLimiter() with invalid policy, expected error")
+	}
+}
+
+func TestApplyConfig(t *testing.T) {
+	oldFilter, _ := filter.New(nil)
+	newFilter, _ := filter.New(&config.SiteFilter{Exclude: []string{"Lab*"}})
+	m := &MistMetrics{
+		filter:                   oldFilter,
+		siteRefreshInterval:      time.Minute,
+		deviceNameRefreshnterval: time.Minute,
+		reloaded:                 make(chan struct{}),
+	}
+	_, _, reloaded := m.intervals()
+
+	if err := m.ApplyConfig(newFilter, &config.Collector{SiteRefreshInterval: 0, DeviceNameRefreshInterval: time.Minute}); err == nil {
+		t.Error("ApplyConfig() with zero site refresh interval, expected error")
+	}
+	if m.filter != oldFilter {
+		t.Error("ApplyConfig() replaced the site filter of an invalid config")
+	}
+
+	if err := m.ApplyConfig(newFilter, &config.Collector{SiteRefreshInterval: 2 * time.Minute, DeviceNameRefreshInterval: 3 * time.Minute}); err != nil {
+		t.Fatalf("ApplyConfig() returned an unexpected error: %v", err)
+	}
+	select {
+	case <-reloaded:
+	default:
+		t.Error("ApplyConfig() did not signal the refresh loops")
+	}
+	if deviceInterval, siteInterval, _ := m.intervals(); deviceInterval != 3*time.Minute || siteInterval != 2*time.Minute {
+		t.Errorf("intervals() = %v, %v, want 3m0s, 2m0s", deviceInterval, siteInterval)
+	}
+	if m.filter != newFilter {
+		t.Error("ApplyConfig() did not replace the site filter")
+	}
+}
+
+func TestReloadExcludedSiteSeries(t *testing.T) {
+	resetSiteSeries()
+	deviceMetrics = newDeviceMetrics(prometheus.NewRegistry())
+	labels, err := newClientLabels(nil)
+	if err != nil {
+		t.Fatalf("newClientLabels() returned an unexpected error: %v", err)
+	}
+	clientMetrics = newClientMetrics(prometheus.NewRegistry(), labels, &privacyPolicy{})
+	t.Cleanup(func() { clientMetrics = nil })
+
+	kept := mistclient.Site{ID: "s1", Name: "Store"}
+	excluded := mistclient.Site{ID: "s2", Name: "Lab"}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode([]mistclient.Site{kept, excluded})
+	}))
+	defer srv.Close()
+
+	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+	client, err := mistclient.New(&mistclient.Config{BaseURL: srv.URL}, logger)
+	if err != nil {
+		t.Fatalf("mistclient.New() returned an unexpected error: %v", err)
+	}
+	siteFilter, _ := filter.New(nil)
+	m := &MistMetrics{
+		client:                   client,
+		orgID:                    "test-org-id",
+		filter:                   siteFilter,
+		siteRefreshInterval:      time.Minute,
+		deviceNameRefreshnterval: time.Minute,
+		clientsCfg:               &config.Clients{TTL: time.Minute},
+		logger:                   logger,
+		sites:                    make(map[string]*StreamCollector),
+		reloaded:                 make(chan struct{}),
+	}
+	for _, site := range []mistclient.Site{kept, excluded} {
+		streamer := newTestStreamCollector(t, site, m.clientsCfg)
+		m.sites[site.ID] = streamer
+		handleSiteDeviceStat(site, "ap-1", mistclient.StreamedDeviceStat{Mac: site.ID + "-ap", Uptime: mistclient.Seconds(time.Hour)})
+		handleSiteClientStat(site, "ap-1", mistclient.StreamedClientStat{Client: mistclient.Client{Mac: site.ID + "-client", SSID: "Corp", Band: mistclient.Band5}}, streamer.clients)
+	}
+	// The stream of the kept site is marked as running so that it is not started.
+	m.sites[kept.ID].running = true
+	var streamed []mistclient.Site
+	m.OnStreamedSites(func(sites []mistclient.Site) { streamed = sites })
+
+	newFilter, _ := filter.New(&config.SiteFilter{Exclude: []string{"Lab*"}})
+	if err := m.ApplyConfig(newFilter, &config.Collector{SiteRefreshInterval: time.Minute, DeviceNameRefreshInterval: time.Minute}); err != nil {
+		t.Fatalf("ApplyConfig() returned an unexpected error: %v", err)
+	}
+	if err := m.manageSiteStreams(context.Background(), &sync.WaitGroup{}); err != nil {
+		t.Fatalf("manageSiteStreams() returned an unexpected error: %v", err)
+	}
+
+	if _, ok := m.sites[excluded.ID]; ok {
+		t.Error("manageSiteStreams() did not stop the stream of the excluded site")
+	}
+	if len(streamed) != 1 || streamed[0].ID != kept.ID {
+		t.Errorf("manageSiteStreams() reported streamed sites %v, want only %q", streamed, kept.ID)
+	}
+	expected := `
+# HELP mist_device_uptime_seconds Device uptime in seconds.
+# TYPE mist_device_uptime_seconds gauge
+mist_device_uptime_seconds{country_code="",device_mac="s1-ap",device_name="ap-1",device_version="",site_name="Store",timezone=""} 3600
+`
+	if err := testutil.CollectAndCompare(deviceMetrics.uptimeSeconds, strings.NewReader(expected)); err != nil {
+		t.Errorf("series of the excluded site were not deleted:\n%v", err)
+	}
+	if got := testutil.CollectAndCount(clientMetrics.rssiDbm); got != 1 {
+		t.Errorf("mist_client_rssi_dbm series = %d, want 1", got)
+	}
+	if _, ok := clientMetrics.series[excluded.ID+"-client"]; ok {
+		t.Error("the client of the excluded site is still tracked by the client metrics")
+	}
+	if got := len(m.sites[kept.ID].clients.snapshot()); got != 1 {
+		t.Errorf("clients tracked by the kept site = %d, want 1", got)
+	}
+}
+
+func TestSetClient(t *testing.T) {
+	site := mistclient.Site{ID: "test-site-id", Name: "Test Site"}
+	idle := newTestStreamCollector(t, site, &config.Clients{TTL: time.Minute})
+	running := newTestStreamCollector(t, site, &config.Clients{TTL: time.Minute})
+
+	cancelled := false
+	running.running = true
+	running.cancel = func() { cancelled = true }
+
+	m := &MistMetrics{sites: map[string]*StreamCollector{"idle": idle, "running": running}}
+	client, err := mistclient.New(&mistclient.Config{BaseURL: "https://api.mist.com", APIKey: "new-api-key"}, nil)
+	if err != nil {
+		t.Fatalf("mistclient.New() returned an unexpected error: %v", err)
+	}
+	m.SetClient(client)
+
+	for name, streamer := range m.sites {
+		if streamer.apiClient() != client {
+			t.Errorf("%s stream was not switched to the new client", name)
+		}
+	}
+	if idle.restart {
+		t.Error("idle stream was marked for restart")
+	}
+	if !running.restart || !cancelled {
+		t.Error("running stream was not stopped for restart")
+	}
+
+	running.stop()
+	if running.restart {
+		t.Error("stopped stream remains marked for restart")
+	}
+
+	// A restart racing with stop must not start the stream again.
+	wg := &sync.WaitGroup{}
+	wg.Add(1)
+	running.run(context.Background(), wg)
+	wg.Wait()
+	if running.running {
+		t.Error("stopped stream was restarted")
+	}
+}
+
+func TestStatus(t *testing.T) {
+	branch := newTestStreamCollector(t, mistclient.Site{ID: "branch-id", Name: "Branch"}, &config.Clients{TTL: time.Minute})
+	main := newTestStreamCollector(t, mistclient.Site{ID: "main-id", Name: "Main"}, &config.Clients{TTL: time.Minute})
+	main.connected = true
+	main.received()
+
+	updated := time.Now().Add(-time.Minute)
+	m := &MistMetrics{
+		sites:              map[string]*StreamCollector{"main-id": main, "branch-id": branch},
+		deviceNames:        map[string]string{"ap-1": "AP 1", "ap-2": "AP 2"},
+		deviceNamesUpdated: updated,
+		lastAPISuccess:     updated,
+		orgID:              "test-org-id",
+		discovered: []SiteStatus{
+			{ID: "main-id", Name: "Main", Included: true},
+			{ID: "lab-id", Name: "Lab"},
+			{ID: "branch-id", Name: "Branch", Included: true},
+		},
+		ready: make(chan struct{}),
+	}
+
+	if m.Status().Started {
+		t.Error("Status() Started = true before the streamer was ready")
+	}
+	close(m.ready)
+
+	status := m.Status()
+	if !status.Started {
+		t.Error("Status() Started = false after the streamer was ready")
+	}
+	if status.DeviceNames != 2 || !status.DeviceNamesUpdated.Equal(updated) || !status.LastAPISuccess.Equal(updated) {
+		t.Errorf("Status() = %+v, want 2 device names updated and last API success at %v", status, updated)
+	}
+	if status.OrgID != "test-org-id" {
+		t.Errorf("Status() OrgID = %q, want %q", status.OrgID, "test-org-id")
+	}
+	if len(status.Sites) != 3 || status.Sites[0].Name != "Branch" || status.Sites[1].Name != "Lab" || status.Sites[1].Included {
+		t.Errorf("Status() sites = %+v, want Branch, an excluded Lab and Main", status.Sites)
+	}
+	if len(status.Streams) != 2 || status.Streams[0].SiteName != "Branch" || status.Streams[1].SiteName != "Main" {
+		t.Fatalf("Status() streams = %+v, want Branch and Main", status.Streams)
+	}
+	if branch := status.Streams[0]; branch.Connected || !branch.LastMessage.IsZero() {
+		t.Errorf("Status() Branch stream = %+v, want disconnected without messages", branch)
+	}
+	if main := status.Streams[1]; !main.Connected || main.LastMessage.IsZero() {
+		t.Errorf("Status() Main stream = %+v, want connected with a message", main)
+	}
+}
+
+func TestSiteRefreshDoesNotBlockStatus(t *testing.T) {
+	requested := make(chan struct{})
+	release := make(chan struct{})
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		close(requested)
+		<-release
+		json.NewEncoder(w).Encode([]mistclient.Site{})
+	}))
+	defer srv.Close()
+
+	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+	client, err := mistclient.New(&mistclient.Config{BaseURL: srv.URL}, logger)
+	if err != nil {
+		t.Fatalf("mistclient.New() returned an unexpected error: %v", err)
+	}
+	siteFilter, _ := filter.New(nil)
+	m := &MistMetrics{
+		client:     client,
+		orgID:      "test-org-id",
+		filter:     siteFilter,
+		clientsCfg: &config.Clients{TTL: time.Minute},
+		logger:     logger,
+		sites:      make(map[string]*StreamCollector),
+	}
+
+	errs := make(chan error, 1)
+	go func() { errs <- m.manageSiteStreams(context.Background(), &sync.WaitGroup{}) }()
+	<-requested
+
+	status := make(chan Status, 1)
+	go func() { status <- m.Status() }()
+	select {
+	case <-status:
+	case <-time.After(5 * time.Second):
+		t.Error("Status() blocked while the site list was being fetched")
+	}
+
+	close(release)
+	if err := <-errs; err != nil {
+		t.Errorf("manageSiteStreams() returned an unexpected error: %v", err)
+	}
+}
+
+func TestStreamMessages(t *testing.T) {
+	site := mistclient.Site{ID: "main-id", Name: "Main"}
+	streamer, err := newStreamCollector(nil, site, func(string) string { return "" }, time.Minute, &config.Clients{TTL: time.Minute}, &config.StreamDebug{Messages: 2, RedactClients: true}, slog.New(slog.NewTextHandler(io.Discard, nil)))
+	if err != nil {
+		t.Fatalf("newStreamCollector() returned an unexpected error: %v", err)
+	}
+	for _, mac := range []string{"ap-1", "ap-2", "ap-3"} {
+		streamer.deviceMessages.add(fmt.Sprintf(`{"mac":%q,"undecoded_field":1}`, mac))
+	}
+	m := &MistMetrics{sites: map[string]*StreamCollector{site.ID: streamer}}
+
+	for _, key := range []string{"main-id", "Main"} {
+		messages, ok := m.StreamMessages(key)
+		if !ok {
+			t.Fatalf("StreamMessages(%q) did not find the site", key)
+		}
+		if messages.SiteID != "main-id" || messages.SiteName != "Main" {
+			t.Errorf("StreamMessages(%q) site = %q %q, want main-id Main", key, messages.SiteID, messages.SiteName)
+		}
+		if len(messages.DeviceStats) != 2 || string(messages.DeviceStats[0].Message) != `{"mac":"ap-3","undecoded_field":1}` {
+			t.Errorf("StreamMessages(%q) device stats = %+v, want the two most recent, most recent first", key, messages.DeviceStats)
+		}
+		if messages.ClientStats == nil || len(messages.ClientStats) != 0 {
+			t.Errorf("StreamMessages(%q) client stats = %+v, want an empty list", key, messages.ClientStats)
+		}
+	}
+	if _, ok := m.StreamMessages("Lab"); ok {
+		t.Error("StreamMessages() found a site without a stream")
+	}
+
+	disabled := newTestStreamCollector(t, site, &config.Clients{TTL: time.Minute})
+	disabled.deviceMessages.add(`{"mac":"ap-1"}`)
+	if got := disabled.deviceMessages.list(); len(got) != 0 {
+		t.Errorf("disabled message log recorded %+v", got)
+	}
+}
+
+func TestRedactClientMessage(t *testing.T) {
+	for _, tc := range []struct {
+		name string
+		data string
+		want string
+	}{
+		{
+			name: "client identifiers",
+			data: `{"mac":"aabbccddeeff","hostname":"laptop","ip":"10.0.0.1","ssid":"Corp","guest":{"email":"user@example.com","authorized":true}}`,
+			want: `{"guest":{"authorized":true,"email":"*****"},"hostname":"*****","ip":"*****","mac":"*****","ssid":"Corp"}`,
+		},
+		{
+			name: "PSK, VLAN and location",
+			data: `{"psk_id":"psk-1","vlan_id":"42","map_id":"map-1","x":12.5,"y_m":3.2,"rssi":-60}`,
+			want: `{"map_id":"*****","psk_id":"*****","rssi":-60,"vlan_id":"*****","x":"*****","y_m":"*****"}`,
+		},
+		{
+			name: "unset identifiers and undecoded fields kept",
+			data: `{"username":"","guest":null,"tx_bps":1234567890123,"undecoded_field":"value"}`,
+			want: `{"guest":null,"tx_bps":1234567890123,"undecoded_field":"value","username":""}`,
+		},
+		{
+			name: "invalid data",
+			data: `{"mac":"aabbccddeeff"`,
+			want: `"*****"`,
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			if got := string(redactClientMessage(tc.data)); got != tc.want {
+				t.Errorf("redactClientMessage() = %s, want %s", got, tc.want)
+			}
+		})
+	}
+
+	l := newMessageLog(1, false)
+	l.add("not json")
+	if got := string(l.list()[0].Message); got != `"not json"` {
+		t.Errorf("messageLog recorded invalid data as %s, want a string", got)
+	}
+}
@@ -0,0 +1,71 @@
+package metrics
+
+import (
+	"fmt"
+	"maps"
+	"slices"
+	"strings"
+	"sync"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/common/model"
+)
+
+// defaultNamespace is the prefix of the names of all Mist metrics.
+const defaultNamespace = "mist"
+
+var (
+	namespaceMu  sync.RWMutex
+	namespace    = defaultNamespace
+	staticLabels prometheus.Labels
+)
+
+// ConfigureNamespace sets the metric name prefix and the static labels attached to all
+// Mist metrics. It must be called before any metrics are created.
+func ConfigureNamespace(ns string, labels map[string]string) error {
+	if ns == "" {
+		ns = defaultNamespace
+	}
+	if !model.IsValidLegacyMetricName(ns) || strings.Contains(ns, ":") {
+		return fmt.Errorf("invalid namespace %q", ns)
+	}
+
+	reserved := append(SiteLabelNames(), siteScopedLabelNames()...)
+	reserved = append(reserved, "alarm_type", "ticket_status")
+	for name := range labels {
+		if !model.LabelName(name).IsValidLegacy() || strings.HasPrefix(name, model.ReservedLabelPrefix) {
+			return fmt.Errorf("invalid static label name %q", name)
+		}
+		if slices.Contains(reserved, name) {
+			return fmt.Errorf("static label name %q is already used by Mist metrics", name)
+		}
+	}
+
+	namespaceMu.Lock()
+	namespace = ns
+	staticLabels = maps.Clone(labels)
+	namespaceMu.Unlock()
+
+	return nil
+}
+
+// Namespace returns the prefix of the names of all Mist metrics.
+func Namespace() string {
+	namespaceMu.RLock()
+	defer namespaceMu.RUnlock()
+
+	return namespace
+}
+
+// FQName returns the fully-qualified name of the Mist metric with the given name.
+func FQName(name string) string {
+	return prometheus.BuildFQName(Namespace(), "", name)
+}
+
+// ConstLabels returns the static labels attached to all Mist metrics.
+func ConstLabels() prometheus.Labels {
+	namespaceMu.RLock()
+	defer namespaceMu.RUnlock()
+
+	return maps.Clone(staticLabels)
+}
@@ -0,0 +1,91 @@
+package metrics
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"slices"
+
+	"github.com/gregwight/mistexporter/internal/config"
+)
+
+// labelAction defines how the value of a client label is treated before being exported.
+type labelAction int
+
+const (
+	labelKeep labelAction = iota
+	labelDrop
+	labelHash
+)
+
+// hashLength is the number of hex characters retained from a hashed label value.
+const hashLength = 16
+
+// privacyPolicy protects client label values, such as usernames and hostnames, before they are exported.
+type privacyPolicy struct {
+	key     []byte
+	actions map[int]labelAction
+}
+
+// newPrivacyPolicy creates a privacyPolicy from the configuration.
+func newPrivacyPolicy(cfg *config.ClientPrivacy) (*privacyPolicy, error) {
+	p := &privacyPolicy{
+		actions: make(map[int]labelAction),
+	}
+	if cfg == nil {
+		return p, nil
+	}
+
+	for name, action := range cfg.Labels {
+		idx := slices.Index(StreamedClientLabelNames(), name)
+		if idx < len(SiteLabelNames()) {
+			return nil, fmt.Errorf("unknown client label %q", name)
+		}
+
+		switch action {
+		case "keep":
+			continue
+		case "drop":
+			// Dropping the client MAC would merge every client into a single series.
+			if name == "client_mac" {
+				return nil, fmt.Errorf("client label %q cannot be dropped, use %q instead", name, "hash")
+			}
+			p.actions[idx] = labelDrop
+		case "hash":
+			if cfg.HashKey == "" {
+				return nil, fmt.Errorf("client label %q cannot be hashed without a hash key", name)
+			}
+			p.actions[idx] = labelHash
+		default:
+			return nil, fmt.Errorf("invalid action %q for client label %q, must be one of keep, drop or hash", action, name)
+		}
+	}
+	p.key = []byte(cfg.HashKey)
+
+	return p, nil
+}
+
+// apply replaces the protected values within a full set of client label values.
+func (p *privacyPolicy) apply(values []string) {
+	for idx, action := range p.actions {
+		switch action {
+		case labelDrop:
+			values[idx] = ""
+		case labelHash:
+			values[idx] = p.hash(values[idx])
+		}
+	}
+}
+
+// hash returns a keyed hash of the value, allowing the same client to be followed
+// over time without revealing the original value. Empty values remain empty.
+func (p *privacyPolicy) hash(value string) string {
+	if value == "" {
+		return ""
+	}
+
+	mac := hmac.New(sha256.New, p.key)
+	mac.Write([]byte(value))
+	return hex.EncodeToString(mac.Sum(nil))[:hashLength]
+}
@@ -1,19 +1,275 @@
 package metrics
 
-import "github.com/gregwight/mistclient"
+import (
+	"fmt"
+	"slices"
+	"sort"
+	"strings"
+	"sync"
 
-// SiteLabelNames defines the labels attached site metrics.
-var SiteLabelNames = []string{
+	"github.com/gregwight/mistclient"
+	"github.com/gregwight/mistexporter/internal/config"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/common/model"
+)
+
+// Sources of the values of extra site labels.
+const (
+	siteLabelSourceID        = "site_id"
+	siteLabelSourceSiteGroup = "site_group"
+	siteLabelSourceVariable  = "variable"
+	siteLabelSourceAddress   = "address"
+)
+
+// defaultSiteLabelNames defines the labels attached to all site metrics.
+var defaultSiteLabelNames = []string{
 	"site_name",
 	"country_code",
 	"timezone",
 }
 
-// SiteLabelValues generates label values for site metrics.
+// SiteLabelResolvers looks up site attributes which are not held on the site itself.
+type SiteLabelResolvers struct {
+	// SiteGroupName returns the name of the site group with the given ID.
+	SiteGroupName func(id string) string
+	// SiteVariable returns the value of the named variable of the site with the given ID.
+	SiteVariable func(siteID, name string) string
+}
+
+// siteLabel is an extra label attached to site metrics.
+type siteLabel struct {
+	name  string
+	value func(mistclient.Site) string
+}
+
+var (
+	siteLabelsMu sync.RWMutex
+	siteLabels   []siteLabel
+)
+
+// partialDeleter is a metric vector whose series can be deleted by a subset of their labels.
+type partialDeleter interface {
+	DeletePartialMatch(labels prometheus.Labels) int
+}
+
+// The values of extra site labels, such as site variables and site group names, can change
+// while the exporter runs. The current site label values of each site are remembered, keyed
+// by site ID, and when they change the series carrying the previous values are deleted from
+// every streamed metric vector so that stale series do not accumulate.
+var (
+	siteSeriesMu     sync.RWMutex
+	siteSeriesVecs   []partialDeleter
+	siteSeriesLabels map[string][]string
+)
+
+// registerSiteSeries registers a streamed metric vector whose series are deleted when the
+// site label values of a site change.
+func registerSiteSeries(vec partialDeleter) {
+	siteSeriesMu.Lock()
+	defer siteSeriesMu.Unlock()
+
+	siteSeriesVecs = append(siteSeriesVecs, vec)
+}
+
+// resetSiteSeries forgets all registered metric vectors and site label values.
+func resetSiteSeries() {
+	siteSeriesMu.Lock()
+	defer siteSeriesMu.Unlock()
+
+	siteSeriesVecs = nil
+	siteSeriesLabels = nil
+}
+
+// updateSiteSeries records the current site label values of the site with the given ID,
+// deleting the series carrying its previous values if they have changed.
+func updateSiteSeries(siteID string, names, values []string) {
+	siteSeriesMu.RLock()
+	prev, ok := siteSeriesLabels[siteID]
+	siteSeriesMu.RUnlock()
+	if ok && slices.Equal(prev, values) {
+		return
+	}
+
+	siteSeriesMu.Lock()
+	prev, ok = siteSeriesLabels[siteID]
+	if ok && slices.Equal(prev, values) {
+		siteSeriesMu.Unlock()
+		return
+	}
+	if siteSeriesLabels == nil {
+		siteSeriesLabels = make(map[string][]string)
+	}
+	siteSeriesLabels[siteID] = slices.Clone(values)
+	vecs := siteSeriesVecs
+	siteSeriesMu.Unlock()
+
+	if !ok {
+		return
+	}
+	labels := make(prometheus.Labels, len(names))
+	for i, name := range names {
+		labels[name] = prev[i]
+	}
+	for _, vec := range vecs {
+		vec.DeletePartialMatch(labels)
+	}
+}
+
+// deleteSiteSeries deletes the series of a site from every streamed metric vector and
+// forgets its site label values, e.g. once the site is excluded by the site filter.
+func deleteSiteSeries(s mistclient.Site) {
+	names, values, _ := siteLabelValues(s)
+
+	siteSeriesMu.Lock()
+	// The series carry the values last recorded for the site, which may differ from
+	// the current values if they have changed since its last stat was received.
+	if prev, ok := siteSeriesLabels[s.ID]; ok {
+		values = prev
+	}
+	delete(siteSeriesLabels, s.ID)
+	vecs := siteSeriesVecs
+	siteSeriesMu.Unlock()
+
+	labels := make(prometheus.Labels, len(names))
+	for i, name := range names {
+		labels[name] = values[i]
+	}
+	for _, vec := range vecs {
+		vec.DeletePartialMatch(labels)
+	}
+}
+
+// ConfigureSiteLabels sets the extra labels attached to site metrics, and to all metrics
+// scoped to a site. It must be called before any metrics are created.
+func ConfigureSiteLabels(cfg []config.SiteLabel, resolvers SiteLabelResolvers) error {
+	labels := make([]siteLabel, 0, len(cfg))
+	names := slices.Clone(defaultSiteLabelNames)
+	for _, l := range cfg {
+		if !model.LabelName(l.Name).IsValidLegacy() || strings.HasPrefix(l.Name, model.ReservedLabelPrefix) {
+			return fmt.Errorf("invalid site label name %q", l.Name)
+		}
+		if slices.Contains(names, l.Name) || slices.Contains(siteScopedLabelNames(), l.Name) {
+			return fmt.Errorf("duplicate site label name %q", l.Name)
+		}
+		if _, ok := ConstLabels()[l.Name]; ok {
+			return fmt.Errorf("site label name %q is already used by a static label", l.Name)
+		}
+		names = append(names, l.Name)
+
+		label := siteLabel{name: l.Name}
+		switch l.Source {
+		case siteLabelSourceID:
+			label.value = func(s mistclient.Site) string { return s.ID }
+		case siteLabelSourceAddress:
+			label.value = func(s mistclient.Site) string { return s.Address }
+		case siteLabelSourceSiteGroup:
+			if resolvers.SiteGroupName == nil {
+				return fmt.Errorf("site label %q requires a site group resolver", l.Name)
+			}
+			label.value = func(s mistclient.Site) string {
+				return siteGroupNames(s, resolvers.SiteGroupName)
+			}
+		case siteLabelSourceVariable:
+			if l.Variable == "" {
+				return fmt.Errorf("site label %q requires a variable", l.Name)
+			}
+			if resolvers.SiteVariable == nil {
+				return fmt.Errorf("site label %q requires a site variable resolver", l.Name)
+			}
+			variable := l.Variable
+			label.value = func(s mistclient.Site) string {
+				return resolvers.SiteVariable(s.ID, variable)
+			}
+		default:
+			return fmt.Errorf("invalid source %q for site label %q", l.Source, l.Name)
+		}
+		labels = append(labels, label)
+	}
+
+	siteLabelsMu.Lock()
+	siteLabels = labels
+	siteLabelsMu.Unlock()
+
+	siteSeriesMu.Lock()
+	siteSeriesLabels = nil
+	siteSeriesMu.Unlock()
+
+	return nil
+}
+
+// siteGroupNames returns the sorted, comma separated names of the site groups the site
+// belongs to. Site groups without a known name are identified by their ID.
+func siteGroupNames(s mistclient.Site, resolve func(string) string) string {
+	names := make([]string, 0, len(s.SiteGroupIDs))
+	for _, id := range s.SiteGroupIDs {
+		if name := resolve(id); name != "" {
+			names = append(names, name)
+		} else {
+			names = append(names, id)
+		}
+	}
+	sort.Strings(names)
+	return strings.Join(names, ",")
+}
+
+// siteScopedLabelNames returns the names of the labels which follow the site labels
+// on site-scoped metrics, which extra site labels must not duplicate.
+func siteScopedLabelNames() []string {
+	n := len(SiteLabelNames())
+	names := []string{"le"}
+	for _, labelNames := range [][]string{
+		DeviceLabelNames(),
+		StreamedDeviceWithRadioLabelNames(),
+		StreamedDeviceIdentityWithRadioLabelNames(),
+		StreamedClientLabelNames(),
+		DeviceClientLabelNames(),
+		SiteProtocolLabelNames(),
+		SiteOSLabelNames(),
+		SiteManufacturerLabelNames(),
+	} {
+		names = append(names, labelNames[n:]...)
+	}
+	return names
+}
+
+// SiteLabelNames returns the labels attached to site metrics.
+func SiteLabelNames() []string {
+	siteLabelsMu.RLock()
+	defer siteLabelsMu.RUnlock()
+
+	names := slices.Clone(defaultSiteLabelNames)
+	for _, l := range siteLabels {
+		names = append(names, l.name)
+	}
+	return names
+}
+
+// SiteLabelValues generates label values for site metrics. If extra site labels are
+// configured, any series carrying the previous label values of the site are deleted when
+// they change.
 func SiteLabelValues(s mistclient.Site) []string {
-	return []string{
+	names, values, extra := siteLabelValues(s)
+	if extra && s.ID != "" {
+		updateSiteSeries(s.ID, names, values)
+	}
+	return values
+}
+
+// siteLabelValues returns the site label names and values of the site, and whether any
+// extra site labels are configured.
+func siteLabelValues(s mistclient.Site) ([]string, []string, bool) {
+	siteLabelsMu.RLock()
+	defer siteLabelsMu.RUnlock()
+
+	values := []string{
 		s.Name,
 		s.CountryCode,
 		s.Timezone,
 	}
+	names := slices.Clone(defaultSiteLabelNames)
+	for _, l := range siteLabels {
+		values = append(values, l.value(s))
+		names = append(names, l.name)
+	}
+	return names, values, len(siteLabels) > 0
 }
@@ -0,0 +1,140 @@
+package relabel
+
+import (
+	"slices"
+	"strings"
+
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+	"github.com/prometheus/common/model"
+	"google.golang.org/protobuf/proto"
+)
+
+// Gatherer applies relabeling rules to the metrics collected by another Gatherer.
+type Gatherer struct {
+	gatherer prometheus.Gatherer
+	rules    []*Rule
+	dropped  prometheus.Gauge
+	self     *prometheus.Registry
+}
+
+// NewGatherer creates a Gatherer relabeling the metrics of the given Gatherer. The
+// dropped gauge is set to the number of series which were dropped by the rules, or which
+// duplicated another series after relabeling, in each gather, and is gathered along with
+// the relabeled metrics without being relabeled itself. It must not be registered elsewhere.
+func NewGatherer(gatherer prometheus.Gatherer, rules []*Rule, dropped prometheus.Gauge) *Gatherer {
+	self := prometheus.NewRegistry()
+	self.MustRegister(dropped)
+
+	return &Gatherer{
+		gatherer: gatherer,
+		rules:    rules,
+		dropped:  dropped,
+		self:     self,
+	}
+}
+
+// Gather implements the prometheus.Gatherer interface.
+func (g *Gatherer) Gather() ([]*dto.MetricFamily, error) {
+	// As with the registry, any metrics gathered are returned along with the error.
+	mfs, err := g.gatherer.Gather()
+
+	families := make(map[string]*dto.MetricFamily)
+	seen := make(map[string]struct{})
+	dropped := 0
+	for _, mf := range mfs {
+		for _, m := range mf.GetMetric() {
+			labels := map[string]string{model.MetricNameLabel: mf.GetName()}
+			for _, pair := range m.GetLabel() {
+				labels[pair.GetName()] = pair.GetValue()
+			}
+
+			if !Process(labels, g.rules) {
+				dropped++
+				continue
+			}
+
+			name := labels[model.MetricNameLabel]
+			delete(labels, model.MetricNameLabel)
+			if !model.IsValidLegacyMetricName(name) {
+				dropped++
+				continue
+			}
+
+			pairs := labelPairs(labels)
+			key := seriesKey(name, pairs)
+			if _, ok := seen[key]; ok {
+				dropped++
+				continue
+			}
+
+			family, ok := families[name]
+			if !ok {
+				family = &dto.MetricFamily{
+					Name: proto.String(name),
+					Help: mf.Help,
+					Type: mf.Type,
+					Unit: mf.Unit,
+				}
+				families[name] = family
+			} else if family.GetType() != mf.GetType() {
+				// A series renamed into a family of a different type cannot be exposed.
+				dropped++
+				continue
+			}
+
+			seen[key] = struct{}{}
+			m.Label = pairs
+			family.Metric = append(family.Metric, m)
+		}
+	}
+
+	g.dropped.Set(float64(dropped))
+	self, selfErr := g.self.Gather()
+	if selfErr != nil {
+		return nil, selfErr
+	}
+
+	result := make([]*dto.MetricFamily, 0, len(families)+len(self))
+	for _, family := range families {
+		result = append(result, family)
+	}
+	for _, family := range self {
+		if _, ok := families[family.GetName()]; !ok {
+			result = append(result, family)
+		}
+	}
+	slices.SortFunc(result, func(a, b *dto.MetricFamily) int {
+		return strings.Compare(a.GetName(), b.GetName())
+	})
+
+	return result, err
+}
+
+// labelPairs converts labels to label pairs sorted by name. Labels with empty values
+// are omitted, as they are equivalent to the label not being set.
+func labelPairs(labels map[string]string) []*dto.LabelPair {
+	pairs := make([]*dto.LabelPair, 0, len(labels))
+	for _, name := range sortedNames(labels) {
+		if labels[name] == "" {
+			continue
+		}
+		pairs = append(pairs, &dto.LabelPair{
+			Name:  proto.String(name),
+			Value: proto.String(labels[name]),
+		})
+	}
+	return pairs
+}
+
+func seriesKey(name string, pairs []*dto.LabelPair) string {
+	var b strings.Builder
+	b.WriteString(name)
+	for _, pair := range pairs {
+		b.WriteByte(0xff)
+		b.WriteString(pair.GetName())
+		b.WriteByte(0xff)
+		b.WriteString(pair.GetValue())
+	}
+	return b.String()
+}
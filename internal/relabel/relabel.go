@@ -0,0 +1,161 @@
+package relabel
+
+import (
+	"crypto/md5"
+	"fmt"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/gregwight/mistexporter/internal/config"
+	"github.com/prometheus/common/model"
+)
+
+// Relabel actions, matching those of Prometheus.
+const (
+	Replace   = "replace"
+	Keep      = "keep"
+	Drop      = "drop"
+	HashMod   = "hashmod"
+	LabelMap  = "labelmap"
+	LabelDrop = "labeldrop"
+	LabelKeep = "labelkeep"
+)
+
+// Rule is a validated metric relabeling rule.
+type Rule struct {
+	cfg   config.RelabelConfig
+	regex *regexp.Regexp
+}
+
+// New creates relabeling rules from the configuration, validating each rule.
+func New(cfgs []*config.RelabelConfig) ([]*Rule, error) {
+	rules := make([]*Rule, 0, len(cfgs))
+	for i, cfg := range cfgs {
+		rule, err := newRule(cfg)
+		if err != nil {
+			return nil, fmt.Errorf("invalid relabel config %d: %w", i, err)
+		}
+		rules = append(rules, rule)
+	}
+	return rules, nil
+}
+
+func newRule(cfg *config.RelabelConfig) (*Rule, error) {
+	if cfg == nil {
+		return nil, fmt.Errorf("relabel config cannot be empty")
+	}
+
+	// Regular expressions are anchored to match the whole value.
+	regex, err := regexp.Compile("^(?:" + cfg.Regex + ")$")
+	if err != nil {
+		return nil, fmt.Errorf("invalid regex %q: %w", cfg.Regex, err)
+	}
+
+	switch cfg.Action {
+	case Replace:
+		if cfg.TargetLabel == "" {
+			return nil, fmt.Errorf("target_label is required for action %q", cfg.Action)
+		}
+	case HashMod:
+		if !model.LabelName(cfg.TargetLabel).IsValidLegacy() {
+			return nil, fmt.Errorf("invalid target_label %q for action %q", cfg.TargetLabel, cfg.Action)
+		}
+		if cfg.Modulus == 0 {
+			return nil, fmt.Errorf("modulus is required for action %q", cfg.Action)
+		}
+	case Keep, Drop:
+		if len(cfg.SourceLabels) == 0 {
+			return nil, fmt.Errorf("source_labels are required for action %q", cfg.Action)
+		}
+	case LabelMap, LabelDrop, LabelKeep:
+	default:
+		return nil, fmt.Errorf("unknown action %q", cfg.Action)
+	}
+
+	return &Rule{cfg: *cfg, regex: regex}, nil
+}
+
+// Process applies the rules in order to the labels of a series, where the metric name
+// is held in the "__name__" label. The labels are modified in place. It returns false
+// if the series is dropped.
+func Process(labels map[string]string, rules []*Rule) bool {
+	for _, rule := range rules {
+		if !rule.apply(labels) {
+			return false
+		}
+	}
+	return true
+}
+
+func (r *Rule) apply(labels map[string]string) bool {
+	values := make([]string, 0, len(r.cfg.SourceLabels))
+	for _, name := range r.cfg.SourceLabels {
+		values = append(values, labels[name])
+	}
+	value := strings.Join(values, r.cfg.Separator)
+
+	switch r.cfg.Action {
+	case Keep:
+		return r.regex.MatchString(value)
+	case Drop:
+		return !r.regex.MatchString(value)
+	case Replace:
+		indexes := r.regex.FindStringSubmatchIndex(value)
+		if indexes == nil {
+			break
+		}
+		target := string(r.regex.ExpandString(nil, r.cfg.TargetLabel, value, indexes))
+		if !model.LabelName(target).IsValidLegacy() {
+			break
+		}
+		if res := string(r.regex.ExpandString(nil, r.cfg.Replacement, value, indexes)); res != "" {
+			labels[target] = res
+		} else {
+			delete(labels, target)
+		}
+	case HashMod:
+		labels[r.cfg.TargetLabel] = strconv.FormatUint(sum64(md5.Sum([]byte(value)))%r.cfg.Modulus, 10)
+	case LabelMap:
+		names := sortedNames(labels)
+		for _, name := range names {
+			if r.regex.MatchString(name) {
+				labels[r.regex.ReplaceAllString(name, r.cfg.Replacement)] = labels[name]
+			}
+		}
+	case LabelDrop:
+		for name := range labels {
+			if r.regex.MatchString(name) {
+				delete(labels, name)
+			}
+		}
+	case LabelKeep:
+		for name := range labels {
+			if name != model.MetricNameLabel && !r.regex.MatchString(name) {
+				delete(labels, name)
+			}
+		}
+	}
+
+	return true
+}
+
+// sum64 sums the md5 hash to an uint64, as Prometheus does for the hashmod action.
+func sum64(hash [md5.Size]byte) uint64 {
+	var s uint64
+	for i, b := range hash {
+		shift := uint64((md5.Size - 1 - i) * 8)
+		s |= uint64(b) << shift
+	}
+	return s
+}
+
+func sortedNames(labels map[string]string) []string {
+	names := make([]string, 0, len(labels))
+	for name := range labels {
+		names = append(names, name)
+	}
+	slices.Sort(names)
+	return names
+}
@@ -0,0 +1,182 @@
+package relabel
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/gregwight/mistexporter/internal/config"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"gopkg.in/yaml.v3"
+)
+
+func mustRules(t *testing.T, cfg string) []*Rule {
+	t.Helper()
+
+	var cfgs []*config.RelabelConfig
+	if err := yaml.Unmarshal([]byte(cfg), &cfgs); err != nil {
+		t.Fatalf("yaml.Unmarshal() returned an unexpected error: %v", err)
+	}
+	rules, err := New(cfgs)
+	if err != nil {
+		t.Fatalf("New() returned an unexpected error: %v", err)
+	}
+	return rules
+}
+
+func TestProcess(t *testing.T) {
+	testCases := []struct {
+		name       string
+		cfg        string
+		labels     map[string]string
+		wantLabels map[string]string
+		wantKeep   bool
+	}{
+		{
+			name:       "keep match",
+			cfg:        `[{action: keep, source_labels: [__name__], regex: "mist_site_.*"}]`,
+			labels:     map[string]string{"__name__": "mist_site_clients"},
+			wantLabels: map[string]string{"__name__": "mist_site_clients"},
+			wantKeep:   true,
+		},
+		{
+			name:     "keep no match",
+			cfg:      `[{action: keep, source_labels: [__name__], regex: "mist_site"}]`,
+			labels:   map[string]string{"__name__": "mist_site_clients"},
+			wantKeep: false,
+		},
+		{
+			name:     "drop on joined source labels",
+			cfg:      `[{action: drop, source_labels: [site_name, ssid], regex: "Lab;.*"}]`,
+			labels:   map[string]string{"__name__": "mist_site_clients", "site_name": "Lab", "ssid": "Corp"},
+			wantKeep: false,
+		},
+		{
+			name:       "replace with defaults copies value",
+			cfg:        `[{source_labels: [site_name], target_label: site}]`,
+			labels:     map[string]string{"site_name": "HQ"},
+			wantLabels: map[string]string{"site_name": "HQ", "site": "HQ"},
+			wantKeep:   true,
+		},
+		{
+			name:       "replace with capture groups",
+			cfg:        `[{source_labels: [site_name], regex: "(\\w+)-(\\d+)", target_label: store, replacement: "$2"}]`,
+			labels:     map[string]string{"site_name": "Store-42"},
+			wantLabels: map[string]string{"site_name": "Store-42", "store": "42"},
+			wantKeep:   true,
+		},
+		{
+			name:       "replace with empty replacement deletes target",
+			cfg:        `[{source_labels: [ssid], target_label: client_hostname, replacement: ""}]`,
+			labels:     map[string]string{"ssid": "Corp", "client_hostname": "laptop"},
+			wantLabels: map[string]string{"ssid": "Corp"},
+			wantKeep:   true,
+		},
+		{
+			name:       "hashmod",
+			cfg:        `[{action: hashmod, source_labels: [client_mac], modulus: 8, target_label: shard}]`,
+			labels:     map[string]string{"client_mac": "aabbccddeeff"},
+			wantLabels: map[string]string{"client_mac": "aabbccddeeff", "shard": "6"},
+			wantKeep:   true,
+		},
+		{
+			name:       "labelmap",
+			cfg:        `[{action: labelmap, regex: "client_(.+)", replacement: "c_$1"}]`,
+			labels:     map[string]string{"client_mac": "aa", "ssid": "Corp"},
+			wantLabels: map[string]string{"client_mac": "aa", "c_mac": "aa", "ssid": "Corp"},
+			wantKeep:   true,
+		},
+		{
+			name:       "labeldrop",
+			cfg:        `[{action: labeldrop, regex: "client_.*"}]`,
+			labels:     map[string]string{"client_mac": "aa", "client_os": "iOS", "ssid": "Corp"},
+			wantLabels: map[string]string{"ssid": "Corp"},
+			wantKeep:   true,
+		},
+		{
+			name:       "labelkeep retains metric name",
+			cfg:        `[{action: labelkeep, regex: "site_name"}]`,
+			labels:     map[string]string{"__name__": "mist_site_clients", "site_name": "HQ", "ssid": "Corp"},
+			wantLabels: map[string]string{"__name__": "mist_site_clients", "site_name": "HQ"},
+			wantKeep:   true,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			rules := mustRules(t, tc.cfg)
+
+			keep := Process(tc.labels, rules)
+			if keep != tc.wantKeep {
+				t.Fatalf("Process() returned %v, want %v", keep, tc.wantKeep)
+			}
+			if keep && !reflect.DeepEqual(tc.labels, tc.wantLabels) {
+				t.Errorf("Process() labels = %v, want %v", tc.labels, tc.wantLabels)
+			}
+		})
+	}
+}
+
+func TestNewInvalid(t *testing.T) {
+	for _, cfg := range []string{
+		`[{action: replace}]`,
+		`[{action: hashmod, target_label: shard}]`,
+		`[{action: keep}]`,
+		`[{action: unknown, source_labels: [ssid]}]`,
+		`[{action: drop, source_labels: [ssid], regex: "("}]`,
+	} {
+		var cfgs []*config.RelabelConfig
+		if err := yaml.Unmarshal([]byte(cfg), &cfgs); err != nil {
+			t.Fatalf("yaml.Unmarshal() returned an unexpected error: %v", err)
+		}
+		if _, err := New(cfgs); err == nil {
+			t.Errorf("New(%s) expected an error, but got nil", cfg)
+		}
+	}
+}
+
+func TestGatherer(t *testing.T) {
+	reg := prometheus.NewRegistry()
+	clients := prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "mist_site_clients",
+		Help: "Number of wireless clients.",
+	}, []string{"site_name", "ssid"})
+	reg.MustRegister(clients)
+	clients.WithLabelValues("HQ", "Corp").Set(10)
+	clients.WithLabelValues("HQ", "Guest").Set(5)
+	clients.WithLabelValues("Lab", "Corp").Set(2)
+	clients.WithLabelValues("Lab", "Guest").Set(1)
+
+	rules := mustRules(t, `
+- action: drop
+  source_labels: [site_name]
+  regex: Lab
+- action: replace
+  source_labels: [__name__]
+  regex: mist_(.*)
+  target_label: __name__
+  replacement: wifi_$1
+- action: labeldrop
+  regex: ssid
+`)
+	dropped := prometheus.NewGauge(prometheus.GaugeOpts{Name: "dropped", Help: "Dropped series."})
+	g := NewGatherer(reg, rules, dropped)
+
+	// Two Lab series are dropped by the rules, and one HQ series duplicates
+	// another once the ssid label has been dropped. The count reflects the
+	// current gather only, so it does not grow with repeated gathers.
+	expected := `
+# HELP dropped Dropped series.
+# TYPE dropped gauge
+dropped 3
+# HELP wifi_site_clients Number of wireless clients.
+# TYPE wifi_site_clients gauge
+wifi_site_clients{site_name="HQ"} 10
+`
+	for range 2 {
+		if err := testutil.GatherAndCompare(g, strings.NewReader(expected)); err != nil {
+			t.Errorf("unexpected metrics gathered:\n%v", err)
+		}
+	}
+}
@@ -0,0 +1,101 @@
+package server
+
+import (
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+
+	"github.com/gregwight/mistexporter/internal/config"
+)
+
+// sha256Prefix marks a basic auth password given as a hex encoded SHA-256 hash.
+const sha256Prefix = "sha256:"
+
+// authenticator checks the credentials of requests to the protected endpoints.
+type authenticator struct {
+	users       map[string]string
+	bearerToken string
+	endpoints   []string
+}
+
+func newAuthenticator(cfg *config.Auth) (*authenticator, error) {
+	a := &authenticator{
+		users:       cfg.BasicAuthUsers,
+		bearerToken: cfg.BearerToken,
+		endpoints:   cfg.Endpoints,
+	}
+	if cfg.BearerTokenFile != "" {
+		token, err := os.ReadFile(cfg.BearerTokenFile)
+		if err != nil {
+			return nil, fmt.Errorf("unable to read bearer token file: %w", err)
+		}
+		a.bearerToken = strings.TrimSpace(string(token))
+		if a.bearerToken == "" {
+			return nil, fmt.Errorf("bearer token file %s is empty", cfg.BearerTokenFile)
+		}
+	}
+	for user, password := range a.users {
+		if hash, ok := strings.CutPrefix(password, sha256Prefix); ok {
+			if b, err := hex.DecodeString(hash); err != nil || len(b) != sha256.Size {
+				return nil, fmt.Errorf("invalid SHA-256 password hash for user %q", user)
+			}
+		}
+	}
+
+	return a, nil
+}
+
+// wrap returns a handler which requires authentication for the protected endpoints.
+func (a *authenticator) wrap(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if a.protects(r.URL.Path) && !a.authenticated(r) {
+			if len(a.users) > 0 {
+				w.Header().Set("WWW-Authenticate", `Basic realm="mistexporter"`)
+			} else {
+				w.Header().Set("WWW-Authenticate", "Bearer")
+			}
+			w.WriteHeader(http.StatusUnauthorized)
+			w.Write([]byte("Unauthorized"))
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// protects reports whether the path requires authentication.
+func (a *authenticator) protects(path string) bool {
+	if len(a.endpoints) == 0 {
+		return true
+	}
+	for _, endpoint := range a.endpoints {
+		if path == endpoint || (strings.HasSuffix(endpoint, "/") && strings.HasPrefix(path, endpoint)) {
+			return true
+		}
+	}
+	return false
+}
+
+// authenticated reports whether the request presents valid credentials.
+func (a *authenticator) authenticated(r *http.Request) bool {
+	if token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer "); ok {
+		return a.bearerToken != "" && subtle.ConstantTimeCompare([]byte(token), []byte(a.bearerToken)) == 1
+	}
+
+	user, password, ok := r.BasicAuth()
+	if !ok {
+		return false
+	}
+	want, ok := a.users[user]
+	if !ok {
+		return false
+	}
+	if hash, ok := strings.CutPrefix(want, sha256Prefix); ok {
+		sum := sha256.Sum256([]byte(password))
+		return subtle.ConstantTimeCompare([]byte(hex.EncodeToString(sum[:])), []byte(strings.ToLower(hash))) == 1
+	}
+	return subtle.ConstantTimeCompare([]byte(password), []byte(want)) == 1
+}
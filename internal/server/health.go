@@ -0,0 +1,97 @@
+package server
+
+import (
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/gregwight/mistexporter/internal/config"
+	"github.com/gregwight/mistexporter/internal/metrics"
+)
+
+// check is the result of a single readiness check.
+type check struct {
+	Name    string `json:"name"`
+	OK      bool   `json:"ok"`
+	Message string `json:"message"`
+}
+
+// healthResponse is the JSON body of the /-/healthy and /-/ready endpoints.
+type healthResponse struct {
+	Status string  `json:"status"`
+	Checks []check `json:"checks,omitempty"`
+}
+
+// handleHealthy reports that the process is alive.
+func handleHealthy(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, healthResponse{Status: "healthy"})
+}
+
+// handleReady reports whether the exporter is ready to serve metrics, responding with
+// 503 Service Unavailable if any of the readiness checks fail.
+func handleReady(status func() metrics.Status, cfg *config.Readiness) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		resp := healthResponse{
+			Status: "ready",
+			Checks: readinessChecks(status(), cfg, time.Now()),
+		}
+		code := http.StatusOK
+		for _, c := range resp.Checks {
+			if !c.OK {
+				resp.Status = "not ready"
+				code = http.StatusServiceUnavailable
+			}
+		}
+		writeJSON(w, code, resp)
+	}
+}
+
+// readinessChecks checks the state of the metrics streamer against the readiness
+// thresholds. A nil config applies no thresholds.
+func readinessChecks(status metrics.Status, cfg *config.Readiness, now time.Time) []check {
+	if cfg == nil {
+		cfg = &config.Readiness{}
+	}
+
+	deviceNames := check{Name: "device_names", OK: !status.DeviceNamesUpdated.IsZero()}
+	if deviceNames.OK {
+		deviceNames.Message = fmt.Sprintf("%d device names loaded %s ago", status.DeviceNames, age(now, status.DeviceNamesUpdated))
+	} else {
+		deviceNames.Message = "device name map has not been loaded"
+	}
+
+	connected := 0
+	for _, s := range status.Streams {
+		if s.Connected {
+			connected++
+		}
+	}
+	percent := 100.0
+	if len(status.Streams) > 0 {
+		percent = 100 * float64(connected) / float64(len(status.Streams))
+	}
+	streams := check{Name: "streams", OK: status.Started && percent >= cfg.MinStreamsPercent}
+	if status.Started {
+		streams.Message = fmt.Sprintf("%d of %d site streams connected (%.0f%%), minimum %.0f%%", connected, len(status.Streams), percent, cfg.MinStreamsPercent)
+	} else {
+		streams.Message = "site streams have not been started"
+	}
+
+	mistAPI := check{Name: "mist_api"}
+	if status.LastAPISuccess.IsZero() {
+		mistAPI.Message = "no Mist API request has succeeded"
+	} else {
+		mistAPI.OK = cfg.MaxAPISuccessAge <= 0 || now.Sub(status.LastAPISuccess) <= cfg.MaxAPISuccessAge
+		mistAPI.Message = fmt.Sprintf("last successful Mist API request %s ago", age(now, status.LastAPISuccess))
+		if cfg.MaxAPISuccessAge > 0 {
+			mistAPI.Message += fmt.Sprintf(", maximum %s", cfg.MaxAPISuccessAge)
+		}
+	}
+
+	return []check{deviceNames, streams, mistAPI}
+}
+
+// age returns the time elapsed since t, rounded to the second.
+func age(now, t time.Time) time.Duration {
+	return now.Sub(t).Round(time.Second)
+}
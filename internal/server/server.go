@@ -1,46 +1,157 @@
 package server
 
 import (
+	"encoding/json"
 	"fmt"
 	"net/http"
+	"net/http/pprof"
+	"time"
 
 	"github.com/gregwight/mistexporter/internal/config"
+	"github.com/gregwight/mistexporter/internal/errorlog"
+	"github.com/gregwight/mistexporter/internal/metrics"
+	"github.com/gregwight/mistexporter/internal/relabel"
 	"github.com/prometheus/client_golang/prometheus"
 	"github.com/prometheus/client_golang/prometheus/promhttp"
 	"gopkg.in/yaml.v3"
-
-	_ "embed"
 )
 
-//go:embed index.html
-var indexHTML []byte
+// Options provides the live state of the exporter, and the actions, served by the HTTP
+// endpoints. Any of them may be nil, in which case the endpoints depending on it are not
+// served, or show nothing for it.
+type Options struct {
+	// Status is checked by the /-/ready endpoint, and shown with the RecentErrors on the
+	// status page.
+	Status       func() metrics.Status
+	RecentErrors func() []errorlog.Entry
+	// StreamMessages is served on /debug/streams if collector.stream_debug is enabled.
+	StreamMessages func(site string) (metrics.StreamMessages, bool)
+	// Reload is exposed on the /-/reload endpoint to reload the configuration.
+	Reload func() error
+	// Config returns the configuration currently in effect, served on /config. If nil, the
+	// configuration the server was created with is served.
+	Config func() *config.Config
+}
 
-// New creates a new HTTP server for the main exporter API.
-func New(cfg *config.Config, reg *prometheus.Registry) (*http.Server, error) {
+// New creates a new HTTP server for the main exporter API. The status page and the
+// administrative endpoints, /config, /-/reload and /debug/streams, are only served if no
+// admin address is configured; otherwise they are served by the server returned by
+// NewAdmin, and a plain page linking to the public endpoints is served in their place.
+func New(cfg *config.Config, reg *prometheus.Registry, opts Options) (*http.Server, error) {
 	if cfg == nil {
 		return nil, fmt.Errorf("config cannot be nil")
 	}
 
+	var gatherer prometheus.Gatherer = reg
+	if len(cfg.MetricRelabelConfigs) > 0 {
+		rules, err := relabel.New(cfg.MetricRelabelConfigs)
+		if err != nil {
+			return nil, fmt.Errorf("invalid metric relabel configs: %w", err)
+		}
+		dropped := prometheus.NewGauge(prometheus.GaugeOpts{
+			Namespace:   metrics.Namespace(),
+			Subsystem:   "exporter",
+			Name:        "relabel_dropped_series",
+			Help:        "Number of series dropped by metric relabeling in the last scrape.",
+			ConstLabels: metrics.ConstLabels(),
+		})
+		gatherer = relabel.NewGatherer(reg, rules, dropped)
+	}
+
 	mux := http.NewServeMux()
-	mux.HandleFunc("/", handleRoot)
-	mux.Handle("/metrics", promhttp.HandlerFor(reg, promhttp.HandlerOpts{
+	if cfg.Exporter.AdminAddress == "" {
+		mux.HandleFunc("/", handleStatus(opts.Status, opts.RecentErrors, true))
+	} else {
+		mux.HandleFunc("/", handleIndex)
+	}
+	mux.Handle("/metrics", promhttp.HandlerFor(gatherer, promhttp.HandlerOpts{
 		EnableOpenMetrics: true,
 		Registry:          reg,
 		Timeout:           cfg.Collector.CollectTimeout,
 	}))
 	mux.HandleFunc("/health", handleHealth)
-	mux.HandleFunc("/config", handleConfig(cfg))
+	mux.HandleFunc("/-/healthy", handleHealthy)
+	if opts.Status != nil {
+		mux.HandleFunc("/-/ready", handleReady(opts.Status, cfg.Exporter.Readiness))
+	}
+	if cfg.Exporter.AdminAddress == "" {
+		handleAdmin(mux, cfg, opts)
+	}
 
-	return &http.Server{
-		Addr:    fmt.Sprintf("%s:%d", cfg.Exporter.Address, cfg.Exporter.Port),
-		Handler: mux,
-	}, nil
+	return newServer(cfg, fmt.Sprintf("%s:%d", cfg.Exporter.Address, cfg.Exporter.Port), mux)
 }
 
-func handleRoot(w http.ResponseWriter, r *http.Request) {
-	w.Header().Set("Content-Type", "text/html; charset=utf-8")
-	w.WriteHeader(http.StatusOK)
-	w.Write(indexHTML)
+// NewAdmin creates a new HTTP server for the status page and the administrative endpoints
+// on the configured admin address, which also serves the pprof profiles under /debug/pprof/.
+// It returns nil if no admin address is configured.
+func NewAdmin(cfg *config.Config, opts Options) (*http.Server, error) {
+	if cfg == nil {
+		return nil, fmt.Errorf("config cannot be nil")
+	}
+	if cfg.Exporter.AdminAddress == "" {
+		return nil, nil
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/{$}", handleStatus(opts.Status, opts.RecentErrors, false))
+	handleAdmin(mux, cfg, opts)
+	mux.HandleFunc("/debug/pprof/", pprof.Index)
+	mux.HandleFunc("/debug/pprof/cmdline", pprof.Cmdline)
+	mux.HandleFunc("/debug/pprof/profile", pprof.Profile)
+	mux.HandleFunc("/debug/pprof/symbol", pprof.Symbol)
+	mux.HandleFunc("/debug/pprof/trace", pprof.Trace)
+
+	return newServer(cfg, cfg.Exporter.AdminAddress, mux)
+}
+
+// handleAdmin registers the handlers of the administrative endpoints.
+func handleAdmin(mux *http.ServeMux, cfg *config.Config, opts Options) {
+	current := opts.Config
+	if current == nil {
+		current = func() *config.Config { return cfg }
+	}
+	mux.HandleFunc("/config", handleConfig(current))
+	if opts.Reload != nil {
+		mux.HandleFunc("/-/reload", handleReload(opts.Reload))
+	}
+	if opts.StreamMessages != nil && cfg.Collector.StreamDebug != nil && cfg.Collector.StreamDebug.Messages > 0 {
+		mux.HandleFunc("/debug/streams", handleStreams(opts.StreamMessages))
+	}
+}
+
+// newServer creates an HTTP server listening on addr, applying the configured
+// authentication and TLS.
+func newServer(cfg *config.Config, addr string, mux *http.ServeMux) (*http.Server, error) {
+	var handler http.Handler = mux
+	if cfg.Exporter.Auth != nil {
+		auth, err := newAuthenticator(cfg.Exporter.Auth)
+		if err != nil {
+			return nil, fmt.Errorf("invalid authentication config: %w", err)
+		}
+		handler = auth.wrap(handler)
+	}
+
+	srv := &http.Server{
+		Addr:    addr,
+		Handler: handler,
+	}
+	if cfg.Exporter.TLS != nil {
+		tlsConfig, err := newTLSConfig(cfg.Exporter.TLS)
+		if err != nil {
+			return nil, fmt.Errorf("invalid TLS config: %w", err)
+		}
+		srv.TLSConfig = tlsConfig
+	}
+
+	return srv, nil
+}
+
+// ListenAndServe starts the server, serving HTTPS if it has a TLS configuration.
+func ListenAndServe(srv *http.Server) error {
+	if srv.TLSConfig != nil {
+		return srv.ListenAndServeTLS("", "")
+	}
+	return srv.ListenAndServe()
 }
 
 func handleHealth(w http.ResponseWriter, r *http.Request) {
@@ -48,19 +159,100 @@ func handleHealth(w http.ResponseWriter, r *http.Request) {
 	w.Write([]byte("OK"))
 }
 
-func handleConfig(cfg *config.Config) http.HandlerFunc {
+// configResponse is the JSON representation of the configuration served on /config.
+type configResponse struct {
+	Source   string         `json:"source,omitempty"`
+	Modified time.Time      `json:"modified,omitzero"`
+	Config   map[string]any `json:"config"`
+}
+
+// handleConfig serves the running configuration, including defaults, with its secrets
+// redacted. The format query parameter selects "yaml" (the default) or "json" output.
+func handleConfig(current func() *config.Config) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
-		localConfig := *cfg
-		localConfig.MistClient.APIKey = "*****"
+		format := r.URL.Query().Get("format")
+		if format != "" && format != "yaml" && format != "json" {
+			w.WriteHeader(http.StatusBadRequest)
+			fmt.Fprintf(w, "Unsupported format %q, must be yaml or json", format)
+			return
+		}
 
-		configBytes, err := yaml.Marshal(localConfig)
+		cfg := current()
+
+		configBytes, err := yaml.Marshal(cfg.Redacted())
 		if err != nil {
 			w.WriteHeader(http.StatusInternalServerError)
 			fmt.Fprintf(w, "Error marshaling config: %v", err)
 			return
 		}
+		source, modTime := cfg.Source()
+
+		if format == "json" {
+			resp := configResponse{Source: source, Modified: modTime}
+			if err := yaml.Unmarshal(configBytes, &resp.Config); err != nil {
+				w.WriteHeader(http.StatusInternalServerError)
+				fmt.Fprintf(w, "Error marshaling config: %v", err)
+				return
+			}
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusOK)
+			json.NewEncoder(w).Encode(resp)
+			return
+		}
+
 		w.Header().Set("Content-Type", "text/plain")
 		w.WriteHeader(http.StatusOK)
+		if source == "" {
+			fmt.Fprintln(w, "# Source: defaults, environment variables and flags")
+		} else {
+			fmt.Fprintf(w, "# Source: %s (modified %s)\n", source, modTime.Format(time.RFC3339))
+		}
 		w.Write(append([]byte("---\n"), configBytes...))
 	}
 }
+
+func handleReload(reload func() error) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost && r.Method != http.MethodPut {
+			w.Header().Set("Allow", "POST, PUT")
+			w.WriteHeader(http.StatusMethodNotAllowed)
+			w.Write([]byte("Only POST or PUT requests allowed"))
+			return
+		}
+		if err := reload(); err != nil {
+			w.WriteHeader(http.StatusInternalServerError)
+			fmt.Fprintf(w, "Failed to reload config: %v", err)
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+		w.Write([]byte("OK"))
+	}
+}
+
+// handleStreams serves the last stats received from the stream of the site given by the
+// site query parameter, which is either its ID or name. The stats are the parsed structs
+// re-marshalled as JSON, not the raw messages received from Mist.
+func handleStreams(streamMessages func(site string) (metrics.StreamMessages, bool)) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		site := r.URL.Query().Get("site")
+		if site == "" {
+			w.WriteHeader(http.StatusBadRequest)
+			w.Write([]byte("The site parameter is required"))
+			return
+		}
+		messages, ok := streamMessages(site)
+		if !ok {
+			w.WriteHeader(http.StatusNotFound)
+			fmt.Fprintf(w, "No stream found for site %q", site)
+			return
+		}
+		writeJSON(w, http.StatusOK, messages)
+	}
+}
+
+// writeJSON writes v as the JSON body of the response.
+func writeJSON(w http.ResponseWriter, code int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	json.NewEncoder(w).Encode(v)
+}
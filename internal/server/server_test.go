@@ -1,14 +1,33 @@
 package server
 
 import (
+	"bytes"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/hex"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"math/big"
 	"net/http"
 	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"slices"
 	"strings"
 	"testing"
 	"time"
 
 	"github.com/gregwight/mistclient"
 	"github.com/gregwight/mistexporter/internal/config"
+	"github.com/gregwight/mistexporter/internal/errorlog"
+	"github.com/gregwight/mistexporter/internal/metrics"
+	"github.com/gregwight/mistexporter/internal/version"
 	"github.com/prometheus/client_golang/prometheus"
 	"gopkg.in/yaml.v3"
 )
@@ -25,7 +44,7 @@ func TestNew(t *testing.T) {
 	}
 	reg := prometheus.NewRegistry()
 
-	srv, err := New(cfg, reg)
+	srv, err := New(cfg, reg, Options{})
 	if err != nil {
 		t.Fatalf("New() returned an unexpected error: %v", err)
 	}
@@ -53,25 +72,29 @@ func TestServerHandlers(t *testing.T) {
 		Collector: &config.Collector{
 			CollectTimeout: 5 * time.Second,
 		},
-		MistClient: &mistclient.Config{
-			BaseURL: "https://test.api.com",
-			APIKey:  "supersecretapikey",
+		MistClient: &config.MistAPI{
+			Config: mistclient.Config{
+				BaseURL: "https://test.api.com",
+				APIKey:  "supersecretapikey",
+			},
 		},
 	}
 	reg := prometheus.NewRegistry()
 
-	srv, err := New(cfg, reg)
+	srv, err := New(cfg, reg, Options{})
 	if err != nil {
 		t.Fatalf("failed to create server: %v", err)
 	}
 
-	expectedConfig := *cfg
-	expectedConfig.MistClient.APIKey = "*****"
-	configBytes, err := yaml.Marshal(expectedConfig)
+	configBytes, err := yaml.Marshal(cfg.Redacted())
 	if err != nil {
 		t.Fatalf("failed to marshal expected config: %v", err)
 	}
-	expectedConfigYAML := "---\n" + string(configBytes)
+	expectedConfigYAML := "# Source: defaults, environment variables and flags\n---\n" + string(configBytes)
+	var emptyStatusPage bytes.Buffer
+	if err := statusTemplate.Execute(&emptyStatusPage, statusPage{Version: version.Version(), Main: true}); err != nil {
+		t.Fatalf("failed to render expected status page: %v", err)
+	}
 
 	testCases := []struct {
 		name           string
@@ -85,7 +108,7 @@ func TestServerHandlers(t *testing.T) {
 			name:           "Root",
 			path:           "/",
 			wantStatusCode: http.StatusOK,
-			wantBody:       string(indexHTML),
+			wantBody:       emptyStatusPage.String(),
 			wantHeaders:    map[string]string{"Content-Type": "text/html; charset=utf-8"},
 		},
 		{
@@ -112,7 +135,7 @@ func TestServerHandlers(t *testing.T) {
 			name:           "Not Found",
 			path:           "/not-a-real-path",
 			wantStatusCode: http.StatusOK,
-			wantBody:       string(indexHTML),
+			wantBody:       emptyStatusPage.String(),
 			wantHeaders:    map[string]string{"Content-Type": "text/html; charset=utf-8"},
 		},
 	}
@@ -148,3 +171,568 @@ func TestServerHandlers(t *testing.T) {
 		})
 	}
 }
+
+func TestReloadHandler(t *testing.T) {
+	cfg := &config.Config{
+		Exporter:  &config.Exporter{Address: "localhost", Port: 9090},
+		Collector: &config.Collector{CollectTimeout: 5 * time.Second},
+	}
+
+	var reloadErr error
+	reloads := 0
+	srv, err := New(cfg, prometheus.NewRegistry(), Options{Reload: func() error {
+		reloads++
+		return reloadErr
+	}})
+	if err != nil {
+		t.Fatalf("failed to create server: %v", err)
+	}
+
+	for _, tc := range []struct {
+		name           string
+		method         string
+		err            error
+		wantStatusCode int
+		wantReloads    int
+	}{
+		{name: "GET not allowed", method: http.MethodGet, wantStatusCode: http.StatusMethodNotAllowed},
+		{name: "POST", method: http.MethodPost, wantStatusCode: http.StatusOK, wantReloads: 1},
+		{name: "PUT", method: http.MethodPut, wantStatusCode: http.StatusOK, wantReloads: 2},
+		{name: "failed", method: http.MethodPost, err: errors.New("invalid config"), wantStatusCode: http.StatusInternalServerError, wantReloads: 3},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			reloadErr = tc.err
+			rr := httptest.NewRecorder()
+			srv.Handler.ServeHTTP(rr, httptest.NewRequest(tc.method, "/-/reload", nil))
+
+			if rr.Code != tc.wantStatusCode {
+				t.Errorf("reload handler returned wrong status code: got %v want %v", rr.Code, tc.wantStatusCode)
+			}
+			if reloads != tc.wantReloads {
+				t.Errorf("reload called %d times, want %d", reloads, tc.wantReloads)
+			}
+		})
+	}
+}
+
+func TestAuth(t *testing.T) {
+	sum := sha256.Sum256([]byte("hashed-password"))
+	cfg := &config.Config{
+		Exporter: &config.Exporter{
+			Address: "localhost",
+			Port:    9090,
+			Auth: &config.Auth{
+				BasicAuthUsers: map[string]string{
+					"plain":  "plain-password",
+					"hashed": "sha256:" + hex.EncodeToString(sum[:]),
+				},
+				BearerToken: "secret-token",
+				Endpoints:   []string{"/metrics", "/debug/"},
+			},
+		},
+		Collector: &config.Collector{CollectTimeout: 5 * time.Second},
+	}
+	srv, err := New(cfg, prometheus.NewRegistry(), Options{})
+	if err != nil {
+		t.Fatalf("failed to create server: %v", err)
+	}
+
+	for _, tc := range []struct {
+		name           string
+		path           string
+		setAuth        func(r *http.Request)
+		wantStatusCode int
+	}{
+		{name: "no credentials", path: "/metrics", wantStatusCode: http.StatusUnauthorized},
+		{name: "plain password", path: "/metrics", setAuth: func(r *http.Request) { r.SetBasicAuth("plain", "plain-password") }, wantStatusCode: http.StatusOK},
+		{name: "hashed password", path: "/metrics", setAuth: func(r *http.Request) { r.SetBasicAuth("hashed", "hashed-password") }, wantStatusCode: http.StatusOK},
+		{name: "wrong password", path: "/metrics", setAuth: func(r *http.Request) { r.SetBasicAuth("plain", "wrong") }, wantStatusCode: http.StatusUnauthorized},
+		{name: "unknown user", path: "/metrics", setAuth: func(r *http.Request) { r.SetBasicAuth("nobody", "plain-password") }, wantStatusCode: http.StatusUnauthorized},
+		{name: "bearer token", path: "/metrics", setAuth: func(r *http.Request) { r.Header.Set("Authorization", "Bearer secret-token") }, wantStatusCode: http.StatusOK},
+		{name: "wrong bearer token", path: "/metrics", setAuth: func(r *http.Request) { r.Header.Set("Authorization", "Bearer wrong") }, wantStatusCode: http.StatusUnauthorized},
+		{name: "protected prefix", path: "/debug/pprof/", wantStatusCode: http.StatusUnauthorized},
+		{name: "unprotected endpoint", path: "/", wantStatusCode: http.StatusOK},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, tc.path, nil)
+			if tc.setAuth != nil {
+				tc.setAuth(req)
+			}
+			rr := httptest.NewRecorder()
+			srv.Handler.ServeHTTP(rr, req)
+
+			if rr.Code != tc.wantStatusCode {
+				t.Errorf("handler returned wrong status code: got %v want %v", rr.Code, tc.wantStatusCode)
+			}
+			if rr.Code == http.StatusUnauthorized && rr.Header().Get("WWW-Authenticate") == "" {
+				t.Error("unauthorized response is missing the WWW-Authenticate header")
+			}
+		})
+	}
+}
+
+func TestTLS(t *testing.T) {
+	dir := t.TempDir()
+	certFile, keyFile := filepath.Join(dir, "tls.crt"), filepath.Join(dir, "tls.key")
+	writeCertificate(t, certFile, keyFile, "first")
+
+	tlsConfig, err := newTLSConfig(&config.TLS{CertFile: certFile, KeyFile: keyFile, MinVersion: "TLS13"})
+	if err != nil {
+		t.Fatalf("newTLSConfig() returned an unexpected error: %v", err)
+	}
+	if tlsConfig.MinVersion != tls.VersionTLS13 {
+		t.Errorf("newTLSConfig() min version = %x, want %x", tlsConfig.MinVersion, tls.VersionTLS13)
+	}
+
+	commonName := func() string {
+		cert, err := tlsConfig.GetCertificate(nil)
+		if err != nil {
+			t.Fatalf("GetCertificate() returned an unexpected error: %v", err)
+		}
+		leaf, err := x509.ParseCertificate(cert.Certificate[0])
+		if err != nil {
+			t.Fatalf("failed to parse certificate: %v", err)
+		}
+		return leaf.Subject.CommonName
+	}
+	if got := commonName(); got != "first" {
+		t.Errorf("certificate common name = %q, want %q", got, "first")
+	}
+
+	writeCertificate(t, certFile, keyFile, "second")
+	future := time.Now().Add(time.Minute)
+	for _, file := range []string{certFile, keyFile} {
+		if err := os.Chtimes(file, future, future); err != nil {
+			t.Fatalf("failed to update modification time: %v", err)
+		}
+	}
+	if got := commonName(); got != "second" {
+		t.Errorf("certificate common name after reload = %q, want %q", got, "second")
+	}
+
+	if _, err := newTLSConfig(&config.TLS{CertFile: certFile, KeyFile: keyFile, ClientAuthType: "RequireAndVerifyClientCert"}); err == nil {
+		t.Error("newTLSConfig() with client verification but no client CA, expected error")
+	}
+
+	if err := os.WriteFile(certFile, []byte("invalid"), 0600); err != nil {
+		t.Fatalf("failed to write certificate: %v", err)
+	}
+	if err := os.Chtimes(certFile, future.Add(time.Minute), future.Add(time.Minute)); err != nil {
+		t.Fatalf("failed to update modification time: %v", err)
+	}
+	if got := commonName(); got != "second" {
+		t.Errorf("certificate common name after failed reload = %q, want %q", got, "second")
+	}
+
+	if _, err := newTLSConfig(&config.TLS{CertFile: certFile, KeyFile: keyFile}); err == nil {
+		t.Error("newTLSConfig() with an invalid certificate, expected error")
+	}
+}
+
+// writeCertificate writes a self-signed certificate and its key to the given files.
+func writeCertificate(t *testing.T, certFile, keyFile, commonName string) {
+	t.Helper()
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("failed to generate key: %v", err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: commonName},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		DNSNames:     []string{"localhost"},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatalf("failed to create certificate: %v", err)
+	}
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatalf("failed to marshal key: %v", err)
+	}
+
+	if err := os.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0600); err != nil {
+		t.Fatalf("failed to write certificate: %v", err)
+	}
+	if err := os.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}), 0600); err != nil {
+		t.Fatalf("failed to write key: %v", err)
+	}
+}
+
+func TestConfigHandler(t *testing.T) {
+	configPath := filepath.Join(t.TempDir(), "config.yaml")
+	configYAML := `
+mist_api:
+  api_key: supersecretapikey
+exporter:
+  auth:
+    basic_auth_users:
+      prometheus: supersecretpassword
+    bearer_token: supersecrettoken
+collector:
+  clients:
+    privacy:
+      hash_key: supersecrethashkey
+`
+	if err := os.WriteFile(configPath, []byte(configYAML), 0600); err != nil {
+		t.Fatalf("failed to write config file: %v", err)
+	}
+	cfg, err := config.LoadConfig(configPath, nil)
+	if err != nil {
+		t.Fatalf("failed to load config: %v", err)
+	}
+	srv, err := New(cfg, prometheus.NewRegistry(), Options{})
+	if err != nil {
+		t.Fatalf("failed to create server: %v", err)
+	}
+
+	get := func(path string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodGet, path, nil)
+		req.SetBasicAuth("prometheus", "supersecretpassword")
+		rr := httptest.NewRecorder()
+		srv.Handler.ServeHTTP(rr, req)
+		return rr
+	}
+
+	for _, path := range []string{"/config", "/config?format=yaml", "/config?format=json"} {
+		rr := get(path)
+		if rr.Code != http.StatusOK {
+			t.Fatalf("GET %s returned wrong status code: got %v want %v", path, rr.Code, http.StatusOK)
+		}
+		body := rr.Body.String()
+		if strings.Contains(body, "supersecret") {
+			t.Errorf("GET %s exposed a secret:\n%s", path, body)
+		}
+		for _, want := range []string{configPath, "*****", "collect_timeout", "prometheus"} {
+			if !strings.Contains(body, want) {
+				t.Errorf("GET %s body does not contain %q:\n%s", path, want, body)
+			}
+		}
+	}
+
+	var resp configResponse
+	if err := json.Unmarshal(get("/config?format=json").Body.Bytes(), &resp); err != nil {
+		t.Fatalf("failed to decode JSON config: %v", err)
+	}
+	if resp.Source != configPath || resp.Modified.IsZero() {
+		t.Errorf("JSON config source = %q modified %v, want %q and a modification time", resp.Source, resp.Modified, configPath)
+	}
+	if mistAPI, _ := resp.Config["mist_api"].(map[string]any); mistAPI["api_key"] != "*****" {
+		t.Errorf("JSON config mist_api = %v, want a redacted api_key", resp.Config["mist_api"])
+	}
+
+	if rr := get("/config?format=xml"); rr.Code != http.StatusBadRequest {
+		t.Errorf("GET /config?format=xml returned wrong status code: got %v want %v", rr.Code, http.StatusBadRequest)
+	}
+
+	if cfg.MistClient.APIKey != "supersecretapikey" {
+		t.Errorf("running config API key = %q after serving /config, want it unchanged", cfg.MistClient.APIKey)
+	}
+	if cfg.Exporter.Auth.BasicAuthUsers["prometheus"] != "supersecretpassword" || cfg.Exporter.Auth.BearerToken != "supersecrettoken" {
+		t.Errorf("running config auth = %+v after serving /config, want it unchanged", cfg.Exporter.Auth)
+	}
+	if cfg.Collector.Clients.Privacy.HashKey != "supersecrethashkey" {
+		t.Errorf("running config hash key = %q after serving /config, want it unchanged", cfg.Collector.Clients.Privacy.HashKey)
+	}
+
+	// After a reload, the configuration in effect is served rather than the startup configuration.
+	reloaded, err := config.LoadConfig("", nil)
+	if err != nil {
+		t.Fatalf("failed to load config: %v", err)
+	}
+	reloaded.Collector.SiteRefreshInterval = 5 * time.Minute
+	srv, err = New(cfg, prometheus.NewRegistry(), Options{Config: func() *config.Config { return reloaded }})
+	if err != nil {
+		t.Fatalf("failed to create server: %v", err)
+	}
+	if body := get("/config").Body.String(); !strings.Contains(body, "site_refresh_interval: 5m0s") || strings.Contains(body, configPath) {
+		t.Errorf("GET /config did not serve the reloaded config:\n%s", body)
+	}
+}
+
+func TestAdminServer(t *testing.T) {
+	cfg := &config.Config{
+		Exporter:  &config.Exporter{Address: "0.0.0.0", Port: 9090},
+		Collector: &config.Collector{CollectTimeout: 5 * time.Second},
+	}
+	if admin, err := NewAdmin(cfg, Options{}); err != nil || admin != nil {
+		t.Fatalf("NewAdmin() without an admin address = %v, %v, want nil, nil", admin, err)
+	}
+
+	cfg.Exporter.AdminAddress = "127.0.0.1:9091"
+	reloads := 0
+	reload := func() error {
+		reloads++
+		return nil
+	}
+	srv, err := New(cfg, prometheus.NewRegistry(), Options{Reload: reload})
+	if err != nil {
+		t.Fatalf("failed to create server: %v", err)
+	}
+	admin, err := NewAdmin(cfg, Options{Reload: reload})
+	if err != nil {
+		t.Fatalf("failed to create admin server: %v", err)
+	}
+	if admin.Addr != "127.0.0.1:9091" {
+		t.Errorf("NewAdmin() server address = %q, want %q", admin.Addr, "127.0.0.1:9091")
+	}
+
+	for _, tc := range []struct {
+		name           string
+		handler        http.Handler
+		method         string
+		path           string
+		wantStatusCode int
+		wantConfig     bool
+	}{
+		{name: "main metrics", handler: srv.Handler, method: http.MethodGet, path: "/metrics", wantStatusCode: http.StatusOK},
+		{name: "main health", handler: srv.Handler, method: http.MethodGet, path: "/health", wantStatusCode: http.StatusOK},
+		{name: "main config", handler: srv.Handler, method: http.MethodGet, path: "/config", wantStatusCode: http.StatusOK},
+		{name: "main reload", handler: srv.Handler, method: http.MethodPost, path: "/-/reload", wantStatusCode: http.StatusOK},
+		{name: "admin config", handler: admin.Handler, method: http.MethodGet, path: "/config", wantStatusCode: http.StatusOK, wantConfig: true},
+		{name: "admin reload", handler: admin.Handler, method: http.MethodPost, path: "/-/reload", wantStatusCode: http.StatusOK},
+		{name: "admin status", handler: admin.Handler, method: http.MethodGet, path: "/", wantStatusCode: http.StatusOK},
+		{name: "admin pprof", handler: admin.Handler, method: http.MethodGet, path: "/debug/pprof/", wantStatusCode: http.StatusOK},
+		{name: "admin metrics", handler: admin.Handler, method: http.MethodGet, path: "/metrics", wantStatusCode: http.StatusNotFound},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			rr := httptest.NewRecorder()
+			tc.handler.ServeHTTP(rr, httptest.NewRequest(tc.method, tc.path, nil))
+
+			if rr.Code != tc.wantStatusCode {
+				t.Errorf("handler for %q returned wrong status code: got %v want %v", tc.path, rr.Code, tc.wantStatusCode)
+			}
+			if got := strings.Contains(rr.Body.String(), "admin_address"); got != tc.wantConfig {
+				t.Errorf("handler for %q served the config = %v, want %v", tc.path, got, tc.wantConfig)
+			}
+		})
+	}
+	if reloads != 1 {
+		t.Errorf("reload called %d times, want 1", reloads)
+	}
+}
+
+func TestReadiness(t *testing.T) {
+	now := time.Now()
+	thresholds := &config.Readiness{MinStreamsPercent: 50, MaxAPISuccessAge: 5 * time.Minute}
+	connected := []metrics.StreamStatus{{SiteName: "Branch"}, {SiteName: "Main", Connected: true}}
+
+	for _, tc := range []struct {
+		name      string
+		status    metrics.Status
+		wantReady bool
+		wantFails []string
+	}{
+		{
+			name:      "ready",
+			status:    metrics.Status{Started: true, DeviceNames: 2, DeviceNamesUpdated: now, LastAPISuccess: now.Add(-time.Minute), Streams: connected},
+			wantReady: true,
+		},
+		{
+			name:      "no sites",
+			status:    metrics.Status{Started: true, DeviceNamesUpdated: now, LastAPISuccess: now},
+			wantReady: true,
+		},
+		{
+			name:      "not started",
+			status:    metrics.Status{},
+			wantFails: []string{"device_names", "streams", "mist_api"},
+		},
+		{
+			name:      "site list not loaded",
+			status:    metrics.Status{DeviceNamesUpdated: now, LastAPISuccess: now},
+			wantFails: []string{"streams"},
+		},
+		{
+			name:      "streams disconnected",
+			status:    metrics.Status{Started: true, DeviceNamesUpdated: now, LastAPISuccess: now, Streams: connected[:1]},
+			wantFails: []string{"streams"},
+		},
+		{
+			name:      "stale API",
+			status:    metrics.Status{Started: true, DeviceNamesUpdated: now, LastAPISuccess: now.Add(-10 * time.Minute), Streams: connected},
+			wantFails: []string{"mist_api"},
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			cfg := &config.Config{
+				Exporter:  &config.Exporter{Address: "localhost", Port: 9090, Readiness: thresholds},
+				Collector: &config.Collector{CollectTimeout: 5 * time.Second},
+			}
+			srv, err := New(cfg, prometheus.NewRegistry(), Options{Status: func() metrics.Status { return tc.status }})
+			if err != nil {
+				t.Fatalf("failed to create server: %v", err)
+			}
+
+			rr := httptest.NewRecorder()
+			srv.Handler.ServeHTTP(rr, httptest.NewRequest(http.MethodGet, "/-/ready", nil))
+
+			wantStatusCode := http.StatusServiceUnavailable
+			if tc.wantReady {
+				wantStatusCode = http.StatusOK
+			}
+			if rr.Code != wantStatusCode {
+				t.Errorf("ready handler returned wrong status code: got %v want %v", rr.Code, wantStatusCode)
+			}
+			var resp healthResponse
+			if err := json.Unmarshal(rr.Body.Bytes(), &resp); err != nil {
+				t.Fatalf("failed to decode readiness response: %v", err)
+			}
+			if len(resp.Checks) != 3 {
+				t.Errorf("readiness response has %d checks, want 3: %+v", len(resp.Checks), resp)
+			}
+			var fails []string
+			for _, c := range resp.Checks {
+				if !c.OK {
+					fails = append(fails, c.Name)
+				}
+			}
+			if !slices.Equal(fails, tc.wantFails) {
+				t.Errorf("failed checks = %v, want %v: %+v", fails, tc.wantFails, resp.Checks)
+			}
+		})
+	}
+
+	cfg := &config.Config{
+		Exporter:  &config.Exporter{Address: "localhost", Port: 9090},
+		Collector: &config.Collector{CollectTimeout: 5 * time.Second},
+	}
+	srv, err := New(cfg, prometheus.NewRegistry(), Options{})
+	if err != nil {
+		t.Fatalf("failed to create server: %v", err)
+	}
+	rr := httptest.NewRecorder()
+	srv.Handler.ServeHTTP(rr, httptest.NewRequest(http.MethodGet, "/-/healthy", nil))
+	if rr.Code != http.StatusOK || !strings.Contains(rr.Body.String(), `"healthy"`) {
+		t.Errorf("healthy handler returned %v %q, want 200 and a healthy status", rr.Code, rr.Body.String())
+	}
+}
+
+func TestStatusPage(t *testing.T) {
+	now := time.Now()
+	status := metrics.Status{
+		OrgID:              "test-org-id",
+		DeviceNames:        412,
+		DeviceNamesUpdated: now,
+		LastAPISuccess:     now,
+		Sites: []metrics.SiteStatus{
+			{ID: "branch-id", Name: "Branch <Office>", Included: true},
+			{ID: "lab-id", Name: "Lab", Included: false},
+			{ID: "main-id", Name: "Main", Included: true},
+		},
+		Streams: []metrics.StreamStatus{
+			{SiteID: "branch-id", SiteName: "Branch <Office>"},
+			{SiteID: "main-id", SiteName: "Main", Connected: true, LastMessage: now},
+		},
+	}
+	recentErrors := []errorlog.Entry{{Time: now, Message: "unable to start site device stats stream", Attrs: "site=Branch"}}
+
+	cfg := &config.Config{
+		Exporter:  &config.Exporter{Address: "localhost", Port: 9090, AdminAddress: "127.0.0.1:9091"},
+		Collector: &config.Collector{CollectTimeout: 5 * time.Second},
+	}
+	opts := Options{
+		Status:       func() metrics.Status { return status },
+		RecentErrors: func() []errorlog.Entry { return recentErrors },
+	}
+	srv, err := New(cfg, prometheus.NewRegistry(), opts)
+	if err != nil {
+		t.Fatalf("failed to create server: %v", err)
+	}
+	admin, err := NewAdmin(cfg, opts)
+	if err != nil {
+		t.Fatalf("failed to create admin server: %v", err)
+	}
+
+	// With an admin address, the main listener only serves a plain page of links.
+	rr := httptest.NewRecorder()
+	srv.Handler.ServeHTTP(rr, httptest.NewRequest(http.MethodGet, "/", nil))
+	if rr.Code != http.StatusOK || !bytes.Equal(rr.Body.Bytes(), indexHTML) {
+		t.Errorf("main root page returned %v, want 200 and the plain index page:\n%s", rr.Code, rr.Body.String())
+	}
+
+	rr = httptest.NewRecorder()
+	admin.Handler.ServeHTTP(rr, httptest.NewRequest(http.MethodGet, "/", nil))
+	if rr.Code != http.StatusOK {
+		t.Fatalf("status page returned wrong status code: got %v want %v", rr.Code, http.StatusOK)
+	}
+
+	body := rr.Body.String()
+	for _, want := range []string{
+		"test-org-id",
+		"412 loaded",
+		"Branch &lt;Office&gt;",
+		"excluded",
+		`<span class="ok">connected</span>`,
+		`<span class="error">disconnected</span>`,
+		"unable to start site device stats stream",
+		"site=Branch",
+		version.Version(),
+	} {
+		if !strings.Contains(body, want) {
+			t.Errorf("status page does not contain %q:\n%s", want, body)
+		}
+	}
+	if strings.Contains(body, `href="/metrics"`) {
+		t.Error("admin status page links to /metrics, which is served by the main listener")
+	}
+}
+
+func TestStreamsHandler(t *testing.T) {
+	streamMessages := func(site string) (metrics.StreamMessages, bool) {
+		if site != "Main" {
+			return metrics.StreamMessages{}, false
+		}
+		return metrics.StreamMessages{
+			SiteID:      "main-id",
+			SiteName:    "Main",
+			DeviceStats: []metrics.StreamMessage{{Received: time.Now(), Message: json.RawMessage(`{"mac":"ap-1"}`)}},
+			ClientStats: []metrics.StreamMessage{},
+		}, true
+	}
+	newConfig := func(messages int) *config.Config {
+		return &config.Config{
+			Exporter:  &config.Exporter{Address: "localhost", Port: 9090},
+			Collector: &config.Collector{CollectTimeout: 5 * time.Second, StreamDebug: &config.StreamDebug{Messages: messages}},
+		}
+	}
+
+	srv, err := New(newConfig(10), prometheus.NewRegistry(), Options{StreamMessages: streamMessages})
+	if err != nil {
+		t.Fatalf("failed to create server: %v", err)
+	}
+	for _, tc := range []struct {
+		name           string
+		path           string
+		wantStatusCode int
+		wantBody       string
+	}{
+		{name: "missing site", path: "/debug/streams", wantStatusCode: http.StatusBadRequest},
+		{name: "unknown site", path: "/debug/streams?site=Lab", wantStatusCode: http.StatusNotFound},
+		{name: "site", path: "/debug/streams?site=Main", wantStatusCode: http.StatusOK, wantBody: `"mac":"ap-1"`},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			rr := httptest.NewRecorder()
+			srv.Handler.ServeHTTP(rr, httptest.NewRequest(http.MethodGet, tc.path, nil))
+
+			if rr.Code != tc.wantStatusCode {
+				t.Errorf("streams handler returned wrong status code: got %v want %v", rr.Code, tc.wantStatusCode)
+			}
+			if !strings.Contains(rr.Body.String(), tc.wantBody) {
+				t.Errorf("streams handler body does not contain %q:\n%s", tc.wantBody, rr.Body.String())
+			}
+		})
+	}
+
+	srv, err = New(newConfig(0), prometheus.NewRegistry(), Options{StreamMessages: streamMessages})
+	if err != nil {
+		t.Fatalf("failed to create server: %v", err)
+	}
+	rr := httptest.NewRecorder()
+	srv.Handler.ServeHTTP(rr, httptest.NewRequest(http.MethodGet, "/debug/streams?site=Main", nil))
+	if rr.Header().Get("Content-Type") == "application/json" {
+		t.Error("streams handler is served while stream debugging is disabled")
+	}
+}
@@ -0,0 +1,109 @@
+package server
+
+import (
+	"bytes"
+	"html/template"
+	"net/http"
+	"time"
+
+	"github.com/gregwight/mistexporter/internal/errorlog"
+	"github.com/gregwight/mistexporter/internal/metrics"
+	"github.com/gregwight/mistexporter/internal/version"
+
+	_ "embed"
+)
+
+//go:embed status.html
+var statusHTML string
+
+//go:embed index.html
+var indexHTML []byte
+
+var statusTemplate = template.Must(template.New("status").Funcs(template.FuncMap{
+	"since": since,
+}).Parse(statusHTML))
+
+// statusPage is the data rendered by the status page.
+type statusPage struct {
+	Version            string
+	OrgID              string
+	DeviceNames        int
+	DeviceNamesUpdated time.Time
+	LastAPISuccess     time.Time
+	Sites              []siteRow
+	Errors             []errorlog.Entry
+	// Main is set if the page is served by the main listener, alongside /metrics, rather
+	// than by the admin listener.
+	Main bool
+}
+
+// siteRow is a site shown on the status page, with the state of its stream if it has one.
+type siteRow struct {
+	metrics.SiteStatus
+	Stream *metrics.StreamStatus
+}
+
+// handleIndex serves a plain page linking to the public endpoints, shown on the main
+// listener in place of the status page when an admin address is configured.
+func handleIndex(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.WriteHeader(http.StatusOK)
+	w.Write(indexHTML)
+}
+
+// handleStatus renders the status page from the live state of the exporter. Either of
+// status or recentErrors may be nil, in which case the state they provide is not shown.
+func handleStatus(status func() metrics.Status, recentErrors func() []errorlog.Entry, main bool) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		page := statusPage{
+			Version: version.Version(),
+			Main:    main,
+		}
+		if status != nil {
+			s := status()
+			page.OrgID = s.OrgID
+			page.DeviceNames = s.DeviceNames
+			page.DeviceNamesUpdated = s.DeviceNamesUpdated
+			page.LastAPISuccess = s.LastAPISuccess
+			page.Sites = siteRows(s)
+		}
+		if recentErrors != nil {
+			page.Errors = recentErrors()
+		}
+
+		var buf bytes.Buffer
+		if err := statusTemplate.Execute(&buf, page); err != nil {
+			http.Error(w, "Error rendering status page: "+err.Error(), http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		w.WriteHeader(http.StatusOK)
+		w.Write(buf.Bytes())
+	}
+}
+
+// siteRows joins the discovered sites with the state of their streams.
+func siteRows(s metrics.Status) []siteRow {
+	streams := make(map[string]metrics.StreamStatus, len(s.Streams))
+	for _, stream := range s.Streams {
+		streams[stream.SiteID] = stream
+	}
+
+	rows := make([]siteRow, 0, len(s.Sites))
+	for _, site := range s.Sites {
+		row := siteRow{SiteStatus: site}
+		if stream, ok := streams[site.ID]; ok {
+			row.Stream = &stream
+		}
+		rows = append(rows, row)
+	}
+	return rows
+}
+
+// since describes the time elapsed since t, which is "never" if t is zero.
+func since(t time.Time) string {
+	if t.IsZero() {
+		return "never"
+	}
+	return time.Since(t).Round(time.Second).String() + " ago"
+}
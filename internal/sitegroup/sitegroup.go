@@ -0,0 +1,129 @@
+package sitegroup
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"sync"
+	"time"
+
+	"github.com/gregwight/mistclient"
+)
+
+// SiteGroup represents a named group of sites within an organization.
+type SiteGroup struct {
+	ID      string   `json:"id,omitempty"`
+	Name    string   `json:"name,omitempty"`
+	SiteIDs []string `json:"site_ids,omitempty"`
+}
+
+// Cache holds the site groups of an organization, periodically refreshed from the Mist API.
+type Cache struct {
+	url             *url.URL
+	refreshInterval time.Duration
+	logger          *slog.Logger
+
+	mu     sync.RWMutex
+	client *mistclient.APIClient
+	names  map[string]string
+}
+
+// NewCache creates a new site group cache. The base URL is that of the Mist API, as
+// the site groups endpoint is not provided by the API client.
+func NewCache(client *mistclient.APIClient, baseURL, orgID string, refreshInterval time.Duration, logger *slog.Logger) (*Cache, error) {
+	if client == nil {
+		return nil, fmt.Errorf("client cannot be nil")
+	}
+
+	u, err := url.Parse(baseURL)
+	if err != nil {
+		return nil, fmt.Errorf("invalid base URL: %w", err)
+	}
+
+	return &Cache{
+		client:          client,
+		url:             u.JoinPath(fmt.Sprintf("/api/v1/orgs/%s/sitegroups", orgID)),
+		refreshInterval: refreshInterval,
+		logger:          logger.With(slog.String("component", "sitegroup")),
+		names:           make(map[string]string),
+	}, nil
+}
+
+// SetClient replaces the Mist API client, e.g. after the API key has been rotated.
+func (c *Cache) SetClient(client *mistclient.APIClient) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.client = client
+}
+
+// apiClient returns the current Mist API client.
+func (c *Cache) apiClient() *mistclient.APIClient {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	return c.client
+}
+
+// Run periodically refreshes the site groups until the context is done.
+func (c *Cache) Run(ctx context.Context) error {
+	ticker := time.NewTicker(c.refreshInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-ticker.C:
+			if err := c.Update(); err != nil {
+				c.logger.Error("unable to refresh site groups", "error", err)
+			}
+		}
+	}
+}
+
+// Update fetches the site groups of the organization from the Mist API.
+func (c *Cache) Update() error {
+	c.logger.Debug("running site group updater...")
+	defer c.logger.Debug("site group updater finished")
+
+	resp, err := c.apiClient().Get(c.url)
+	if err != nil {
+		return fmt.Errorf("unable to fetch site groups: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		body, _ := io.ReadAll(resp.Body)
+		return fmt.Errorf("API request failed with status %d: %s", resp.StatusCode, string(body))
+	}
+
+	var groups []SiteGroup
+	if err := json.NewDecoder(resp.Body).Decode(&groups); err != nil {
+		return fmt.Errorf("unable to decode site groups: %w", err)
+	}
+
+	names := make(map[string]string, len(groups))
+	for _, group := range groups {
+		names[group.ID] = group.Name
+	}
+
+	c.mu.Lock()
+	c.names = names
+	c.mu.Unlock()
+
+	return nil
+}
+
+// Name returns the name of the site group with the given ID, or an empty string if
+// the site group is unknown.
+func (c *Cache) Name(id string) string {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	return c.names[id]
+}
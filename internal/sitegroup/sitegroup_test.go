@@ -0,0 +1,55 @@
+package sitegroup
+
+import (
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gregwight/mistclient"
+)
+
+func TestCache(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/api/v1/orgs/test-org/sitegroups" {
+			http.NotFound(w, r)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`[{"id":"g1","name":"EMEA","site_ids":["s1"]},{"id":"g2","name":"Retail"}]`))
+	}))
+	defer server.Close()
+
+	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+	client, err := mistclient.New(&mistclient.Config{BaseURL: server.URL, APIKey: "test-api-key"}, logger)
+	if err != nil {
+		t.Fatalf("mistclient.New() returned an unexpected error: %v", err)
+	}
+
+	c, err := NewCache(client, server.URL, "test-org", time.Minute, logger)
+	if err != nil {
+		t.Fatalf("NewCache() returned an unexpected error: %v", err)
+	}
+	if got := c.Name("g1"); got != "" {
+		t.Errorf("Name() before Update() = %q, want empty", got)
+	}
+
+	if err := c.Update(); err != nil {
+		t.Fatalf("Update() returned an unexpected error: %v", err)
+	}
+	for id, want := range map[string]string{"g1": "EMEA", "g2": "Retail", "g3": ""} {
+		if got := c.Name(id); got != want {
+			t.Errorf("Name(%q) = %q, want %q", id, got, want)
+		}
+	}
+
+	bad, err := NewCache(client, server.URL, "other-org", time.Minute, logger)
+	if err != nil {
+		t.Fatalf("NewCache() returned an unexpected error: %v", err)
+	}
+	if err := bad.Update(); err == nil {
+		t.Error("Update() for unknown org expected an error, but got nil")
+	}
+}
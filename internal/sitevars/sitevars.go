@@ -0,0 +1,197 @@
+package sitevars
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/gregwight/mistclient"
+	"golang.org/x/sync/errgroup"
+)
+
+// maxConcurrentFetches is the maximum number of site settings requests made at once.
+const maxConcurrentFetches = 8
+
+// Cache holds the variables of a set of sites, normally those whose metrics are streamed,
+// periodically refreshed from the Mist API.
+type Cache struct {
+	baseURL         *url.URL
+	refreshInterval time.Duration
+	logger          *slog.Logger
+
+	// updateMu serializes updates so that concurrent refreshes cannot overwrite each other.
+	updateMu sync.Mutex
+
+	mu     sync.RWMutex
+	client *mistclient.APIClient
+	sites  []mistclient.Site
+	vars   map[string]map[string]string
+}
+
+// NewCache creates a new site variable cache. The base URL is that of the Mist API, as
+// the site settings endpoint is not provided by the API client. No variables are fetched
+// until the sites are set by SetSites.
+func NewCache(client *mistclient.APIClient, baseURL string, refreshInterval time.Duration, logger *slog.Logger) (*Cache, error) {
+	if client == nil {
+		return nil, fmt.Errorf("client cannot be nil")
+	}
+
+	u, err := url.Parse(baseURL)
+	if err != nil {
+		return nil, fmt.Errorf("invalid base URL: %w", err)
+	}
+
+	return &Cache{
+		client:          client,
+		baseURL:         u,
+		refreshInterval: refreshInterval,
+		logger:          logger.With(slog.String("component", "sitevars")),
+		vars:            make(map[string]map[string]string),
+	}, nil
+}
+
+// SetClient replaces the Mist API client, e.g. after the API key has been rotated.
+func (c *Cache) SetClient(client *mistclient.APIClient) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.client = client
+}
+
+// apiClient returns the current Mist API client.
+func (c *Cache) apiClient() *mistclient.APIClient {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	return c.client
+}
+
+// Run periodically refreshes the site variables until the context is done.
+func (c *Cache) Run(ctx context.Context) error {
+	ticker := time.NewTicker(c.refreshInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-ticker.C:
+			c.Update()
+		}
+	}
+}
+
+// SetSites sets the sites whose variables are cached, fetching the variables of any sites
+// which were not previously set. The variables of sites which are no longer set are forgotten.
+func (c *Cache) SetSites(sites []mistclient.Site) {
+	c.updateMu.Lock()
+	defer c.updateMu.Unlock()
+
+	c.mu.RLock()
+	previous := c.vars
+	c.mu.RUnlock()
+
+	vars := make(map[string]map[string]string, len(sites))
+	var added []mistclient.Site
+	for _, site := range sites {
+		if siteVars, ok := previous[site.ID]; ok {
+			vars[site.ID] = siteVars
+			continue
+		}
+		added = append(added, site)
+	}
+	for id, siteVars := range c.fetchAll(added, previous) {
+		vars[id] = siteVars
+	}
+
+	c.mu.Lock()
+	c.sites = slices.Clone(sites)
+	c.vars = vars
+	c.mu.Unlock()
+}
+
+// Update fetches the variables of every site set by SetSites from the Mist API. Sites
+// whose variables cannot be fetched retain their previous values.
+func (c *Cache) Update() {
+	c.logger.Debug("running site variable updater...")
+	defer c.logger.Debug("site variable updater finished")
+
+	c.updateMu.Lock()
+	defer c.updateMu.Unlock()
+
+	c.mu.RLock()
+	sites := c.sites
+	previous := c.vars
+	c.mu.RUnlock()
+
+	vars := c.fetchAll(sites, previous)
+
+	c.mu.Lock()
+	c.vars = vars
+	c.mu.Unlock()
+}
+
+// fetchAll fetches the variables of the given sites, making at most maxConcurrentFetches
+// requests at once. Sites whose variables cannot be fetched retain their previous values.
+func (c *Cache) fetchAll(sites []mistclient.Site, previous map[string]map[string]string) map[string]map[string]string {
+	var mu sync.Mutex
+	vars := make(map[string]map[string]string, len(sites))
+
+	g := &errgroup.Group{}
+	g.SetLimit(maxConcurrentFetches)
+	for _, site := range sites {
+		g.Go(func() error {
+			siteVars, err := c.fetch(site.ID)
+			if err != nil {
+				c.logger.Error("unable to fetch site variables", "site", site.Name, "error", err)
+				siteVars = previous[site.ID]
+			}
+
+			mu.Lock()
+			vars[site.ID] = siteVars
+			mu.Unlock()
+			return nil
+		})
+	}
+	g.Wait()
+
+	return vars
+}
+
+func (c *Cache) fetch(siteID string) (map[string]string, error) {
+	resp, err := c.apiClient().Get(c.baseURL.JoinPath(fmt.Sprintf("/api/v1/sites/%s/setting", siteID)))
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		body, _ := io.ReadAll(resp.Body)
+		return nil, fmt.Errorf("API request failed with status %d: %s", resp.StatusCode, string(body))
+	}
+
+	var setting struct {
+		Vars map[string]string `json:"vars"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&setting); err != nil {
+		return nil, err
+	}
+
+	return setting.Vars, nil
+}
+
+// Value returns the value of the named variable of the site with the given ID, or an
+// empty string if the variable is not set.
+func (c *Cache) Value(siteID, name string) string {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	return c.vars[siteID][name]
+}
@@ -0,0 +1,102 @@
+package sitevars
+
+import (
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gregwight/mistclient"
+)
+
+func TestCache(t *testing.T) {
+	var mu sync.Mutex
+	var requested []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		requested = append(requested, r.URL.Path)
+		mu.Unlock()
+
+		w.Header().Set("Content-Type", "application/json")
+		switch r.URL.Path {
+		case "/api/v1/sites/s1/setting":
+			w.Write([]byte(`{"vars":{"store_number":"0001","region":"north"}}`))
+		case "/api/v1/sites/s2/setting":
+			w.Write([]byte(`{}`))
+		case "/api/v1/sites/s3/setting":
+			w.Write([]byte(`{"vars":{"store_number":"0003"}}`))
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer server.Close()
+
+	// requests returns the sorted paths requested since it was last called.
+	requests := func() []string {
+		mu.Lock()
+		defer mu.Unlock()
+
+		paths := requested
+		requested = nil
+		slices.Sort(paths)
+		return paths
+	}
+
+	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+	client, err := mistclient.New(&mistclient.Config{BaseURL: server.URL, APIKey: "test-api-key"}, logger)
+	if err != nil {
+		t.Fatalf("mistclient.New() returned an unexpected error: %v", err)
+	}
+
+	c, err := NewCache(client, server.URL, time.Minute, logger)
+	if err != nil {
+		t.Fatalf("NewCache() returned an unexpected error: %v", err)
+	}
+
+	// Only the variables of the sites which are set are fetched.
+	c.SetSites([]mistclient.Site{{ID: "s1", Name: "Store 1"}, {ID: "s2", Name: "Store 2"}})
+	if got, want := requests(), []string{"/api/v1/sites/s1/setting", "/api/v1/sites/s2/setting"}; !slices.Equal(got, want) {
+		t.Errorf("SetSites() requested %v, want %v", got, want)
+	}
+
+	for _, tc := range []struct {
+		siteID, name, want string
+	}{
+		{"s1", "store_number", "0001"},
+		{"s1", "region", "north"},
+		{"s1", "missing", ""},
+		{"s2", "store_number", ""},
+		{"s3", "store_number", ""},
+	} {
+		if got := c.Value(tc.siteID, tc.name); got != tc.want {
+			t.Errorf("Value(%q, %q) = %q, want %q", tc.siteID, tc.name, got, tc.want)
+		}
+	}
+
+	c.Update()
+	if got, want := requests(), []string{"/api/v1/sites/s1/setting", "/api/v1/sites/s2/setting"}; !slices.Equal(got, want) {
+		t.Errorf("Update() requested %v, want %v", got, want)
+	}
+
+	// Sites which remain set are not fetched again, and removed sites are forgotten.
+	c.SetSites([]mistclient.Site{{ID: "s1", Name: "Store 1"}, {ID: "s3", Name: "Store 3"}})
+	if got, want := requests(), []string{"/api/v1/sites/s3/setting"}; !slices.Equal(got, want) {
+		t.Errorf("SetSites() requested %v, want %v", got, want)
+	}
+	if got := c.Value("s3", "store_number"); got != "0003" {
+		t.Errorf("Value(%q, %q) = %q, want %q", "s3", "store_number", got, "0003")
+	}
+	if got := c.Value("s1", "store_number"); got != "0001" {
+		t.Errorf("Value(%q, %q) = %q, want %q", "s1", "store_number", got, "0001")
+	}
+	c.mu.RLock()
+	_, ok := c.vars["s2"]
+	c.mu.RUnlock()
+	if ok {
+		t.Error("SetSites() did not forget the variables of a removed site")
+	}
+}
@@ -7,6 +7,7 @@ import (
 	"os"
 	"path/filepath"
 	"strconv"
+	"strings"
 )
 
 //go:embed VERSION
@@ -30,3 +31,8 @@ func AddVersionFlag() {
 	var sv showVersion
 	flag.CommandLine.Var(&sv, "version", "show build information and exit")
 }
+
+// Version returns the version of the exporter.
+func Version() string {
+	return strings.TrimSpace(versionString)
+}